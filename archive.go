@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/oscarzhao/image-sync/archive"
+	"github.com/oscarzhao/image-sync/config"
+	"github.com/oscarzhao/image-sync/registry"
+	"github.com/oscarzhao/image-sync/report"
+)
+
+var (
+	// archives are the archives images are exported to with their baselines,
+	// and sources the archives images are imported from, jobs share them
+	archives   = make(map[string]*archive.Layout)
+	baselines  = make(map[string]string)
+	sources    = make(map[string]*archive.Reader)
+	archivesMu sync.Mutex
+)
+
+// openArchive returns the archive ref refers to, it is created by the first
+// job exporting to it. With a baseline, the archive is a delta of it.
+func openArchive(ref, baseline string) (*archive.Layout, error) {
+	archivesMu.Lock()
+	defer archivesMu.Unlock()
+	if l, ok := archives[ref]; ok {
+		if baselines[ref] != baseline {
+			return nil, fmt.Errorf("archive %s has baseline %q in another job", ref, baselines[ref])
+		}
+		return l, nil
+	}
+	format, path, err := archive.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	// the baseline is loaded first, it may be the archive overwritten
+	var b *archive.Bundle
+	if baseline != "" {
+		if b, err = archive.LoadBundle(baseline); err != nil {
+			return nil, fmt.Errorf("load baseline %s fails, error:%s", baseline, err)
+		}
+	}
+	l, err := archive.Create(format, path)
+	if err != nil {
+		return nil, err
+	}
+	if b != nil {
+		l.SetBaseline(b)
+		glog.V(2).Infof("archive %s is a delta of %s, %d images and %d blobs\n", ref, baseline, len(b.Images), len(b.Blobs))
+	}
+	archives[ref], baselines[ref] = l, baseline
+	return l, nil
+}
+
+// openSource returns the archive ref refers to for reading, it is opened by the first job importing it
+func openSource(ref string) (*archive.Reader, error) {
+	archivesMu.Lock()
+	defer archivesMu.Unlock()
+	if r, ok := sources[ref]; ok {
+		return r, nil
+	}
+	format, path, err := archive.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	r, err := archive.Open(format, path)
+	if err != nil {
+		return nil, err
+	}
+	sources[ref] = r
+	return r, nil
+}
+
+// closeArchives writes the indexes of all archives and closes the archives
+// read, false is returned if any archive cannot be written
+func closeArchives() bool {
+	archivesMu.Lock()
+	defer archivesMu.Unlock()
+	ok := true
+	for ref, l := range archives {
+		if err := l.Close(); err != nil {
+			glog.Errorf("write archive %s fails, error:%s\n", ref, err)
+			ok = false
+		}
+		delete(archives, ref)
+		delete(baselines, ref)
+	}
+	for ref, r := range sources {
+		r.Close()
+		delete(sources, ref)
+	}
+	return ok
+}
+
+// checkArchives returns an error if jobs of cfg import or export archives in
+// a run which cannot, archives are handled by the registry copy mode of
+// single runs
+func checkArchives(cfg *config.Config, serveMode bool) error {
+	for _, job := range cfg.Jobs {
+		if job.Source.Archive == "" && job.Destination.Archive == "" {
+			continue
+		}
+		switch {
+		case serveMode:
+			return errors.New("archives cannot be imported or exported by serve")
+		case dryRun:
+			return errors.New("archives cannot be planned by --dry-run")
+		case copyMode == "docker":
+			return errors.New("archives cannot be imported or exported with --copy-mode docker")
+		}
+	}
+	return nil
+}
+
+// listArchive lists selected tags of selected repos under the source namespace
+// in the archive of the job
+func (s *syncer) listArchive() map[string][]string {
+	repoTags := make(map[string][]config.Tag)
+	for _, image := range s.source.Images() {
+		if s.job.Source.Namespace != "" && !strings.HasPrefix(image.Repo, s.job.Source.Namespace+"/") {
+			continue
+		}
+		if !s.job.Repos.Match(s.relativeRepo(image.Repo)) {
+			glog.V(4).Infof("repo %s is filtered out\n", image.Repo)
+			continue
+		}
+		repoTags[image.Repo] = append(repoTags[image.Repo], config.Tag{Name: image.Tag})
+	}
+
+	repo2tags := make(map[string][]string, len(repoTags))
+	s.upstream = make(map[string]map[string]time.Time, len(repoTags))
+	for repo, tags := range repoTags {
+		repo2tags[repo] = s.job.TagFilter(s.relativeRepo(repo)).Select(tags)
+		s.upstream[repo] = upstreamTags(tags)
+	}
+	return repo2tags
+}
+
+// verifyBaseline checks that the destination has the blobs the bundle of the
+// job leaves out, so that a delta is not imported before its baseline. The
+// blobs are mounted from the repos holding them.
+func (s *syncer) verifyBaseline() error {
+	b := s.source.Bundle()
+	if b == nil || len(b.Required) == 0 {
+		return nil
+	}
+	blobs := make([]string, 0, len(b.Required))
+	for dgst := range b.Required {
+		blobs = append(blobs, dgst)
+	}
+	sort.Strings(blobs)
+
+	blobRepos := make(map[string]string, len(blobs))
+	for _, dgst := range blobs {
+		repo := s.dstImage(Image{repo: b.Required[dgst]}).repo
+		release := s.limiter.acquire(s.job.Destination.Registry)
+		exists, err := s.dstClient.HasBlob(repo, dgst)
+		release()
+		if err != nil {
+			return fmt.Errorf("check blob %s of the baseline in %s fails, error:%s", dgst, repo, err)
+		}
+		if !exists {
+			return fmt.Errorf("blob %s of the baseline is not in %s, import the baseline %s first", dgst, repo, b.Baseline)
+		}
+		blobRepos[dgst] = repo
+	}
+	s.copyOptions.BlobRepos = blobRepos
+	glog.V(2).Infof("job %s, %d blobs of the baseline of %s are in the destination\n", s.job.Name, len(blobs), s.source)
+	return nil
+}
+
+// importImages imports images from the archive of the job to the destination
+// registry, blobs the registry has already are not uploaded. With skipSynced,
+// images the destination has with the same digest are skipped.
+func (s *syncer) importImages(tasks <-chan *task) <-chan *task {
+	return runStage(s.concurrency.Copy, tasks, s.timed("copy", func(t *task) bool {
+		image, dstImg := t.src, t.dst
+		release := s.limiter.acquire(dstImg.registry)
+		defer release()
+
+		s.start(t)
+		m := s.source.Image(image.repo, image.tag).Manifest
+		if skipSynced {
+			if dgst, err := s.dstClient.ManifestDigest(dstImg.repo, dstImg.tag); err == nil && dgst == m.Digest {
+				glog.V(2).Infof("image %s exists in %s, skip it\n", image, dstImg)
+				t.digest = dgst
+				s.record(t, report.StatusSkipped, nil)
+				return false
+			}
+		}
+		result, err := registry.ImportImage(s.source, m, s.dstClient, dstImg.repo, dstImg.tag, s.copyOptions)
+		if err != nil {
+			glog.Errorf("registry.ImportImage from %s of %s to %s failed, error:%s\n", image, s.source, dstImg, err)
+			status := report.StatusPullFailed
+			if copyErr, ok := err.(*registry.CopyError); ok && copyErr.Op == "push" {
+				status = report.StatusPushFailed
+			}
+			s.record(t, status, err)
+			return false
+		}
+		t.digest, t.bytes = result.Digest, result.Bytes
+		return true
+	}))
+}
+
+// exportImages exports images from the source registry to the archive of the
+// job, blobs in the archive already are not downloaded again
+func (s *syncer) exportImages(tasks <-chan *task) <-chan *task {
+	return runStage(s.concurrency.Copy, tasks, s.timed("copy", func(t *task) bool {
+		image, dstImg := t.src, t.dst
+		release := s.limiter.acquire(image.registry)
+		defer release()
+
+		s.start(t)
+		if dgst := s.archive.BaselineDigest(dstImg.String()); dgst != "" {
+			// the image is in the destination already unless it is changed
+			if srcDigest, err := s.srcClient.ManifestDigest(image.repo, image.tag); err == nil && srcDigest == dgst {
+				glog.V(2).Infof("image %s is in the baseline of %s, skip it\n", image, s.archive)
+				t.digest = dgst
+				s.record(t, report.StatusSkipped, nil)
+				return false
+			}
+		}
+		result, err := registry.ExportImage(s.srcClient, image.repo, image.tag, dstImg.String(), s.archive, s.copyOptions)
+		if err != nil {
+			glog.Errorf("registry.ExportImage from %s to %s of %s failed, error:%s\n", image, dstImg, s.archive, err)
+			status := report.StatusPullFailed
+			if copyErr, ok := err.(*registry.CopyError); ok && copyErr.Op == "push" {
+				status = report.StatusPushFailed
+			}
+			s.record(t, status, err)
+			return false
+		}
+		t.digest, t.bytes = result.Digest, result.Bytes
+		return true
+	}))
+}
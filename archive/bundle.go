@@ -0,0 +1,131 @@
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	bundleFile    = "bundle.json"
+	bundleSumFile = "bundle.json.sha256"
+	bundleVersion = 1
+)
+
+// Bundle is the index of the images an archive brings to a destination,
+// written to bundle.json with its checksum in bundle.json.sha256. It lists
+// what the destination has once the archive is imported, so that the next
+// archive, a delta of it, leaves out the blobs the destination has already.
+type Bundle struct {
+	Version int `json:"version"`
+	// Baseline is the checksum of the index the archive is a delta of, empty
+	// if the archive has all blobs of its images
+	Baseline string `json:"baseline,omitempty"`
+	// Images maps the images the destination has, such as
+	// docker_library/busybox:latest, to their manifest digests
+	Images map[string]string `json:"images"`
+	// Blobs maps the configs and layers the destination has to a repo
+	// holding each, such as docker_library/busybox
+	Blobs map[string]string `json:"blobs"`
+	// Required maps the blobs the images of the archive refer to but the
+	// archive leaves out to a repo holding each, they must be in the
+	// destination before the archive is imported
+	Required map[string]string `json:"required,omitempty"`
+
+	// checksum is the sha256 of the index as it is read or written
+	checksum string
+}
+
+func newBundle() *Bundle {
+	return &Bundle{Version: bundleVersion, Images: make(map[string]string), Blobs: make(map[string]string)}
+}
+
+// Checksum returns the sha256 of b as it is read or written
+func (b *Bundle) Checksum() string {
+	return b.checksum
+}
+
+// LoadBundle loads the index of a bundle, ref is an archive such as
+// oci:/mnt/images, or the path of a bundle.json, an inventory of the
+// destination. The checksum is verified if bundle.json.sha256 is next to the file.
+func LoadBundle(ref string) (*Bundle, error) {
+	if format, path, err := Parse(ref); err == nil {
+		r, err := Open(format, path)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		if r.Bundle() == nil {
+			return nil, fmt.Errorf("%s has no %s", ref, bundleFile)
+		}
+		return r.Bundle(), nil
+	}
+
+	content, err := ioutil.ReadFile(ref)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := ioutil.ReadFile(ref + ".sha256")
+	if os.IsNotExist(err) {
+		return parseBundle(content, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseBundle(content, sum)
+}
+
+// parseBundle parses the index of a bundle, and verifies it against sum, the
+// content of bundle.json.sha256, unless sum is nil
+func parseBundle(content, sum []byte) (*Bundle, error) {
+	checksum := sha256Digest(content)
+	if sum != nil {
+		fields := strings.Fields(string(sum))
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("invalid %s, expected <sha256>  %s", bundleSumFile, bundleFile)
+		}
+		if expected := "sha256:" + fields[0]; expected != checksum {
+			return nil, fmt.Errorf("checksum of %s mismatches, expected %s, got %s", bundleFile, expected, checksum)
+		}
+	}
+	b := newBundle()
+	if err := json.Unmarshal(content, b); err != nil {
+		return nil, fmt.Errorf("invalid %s, error:%s", bundleFile, err)
+	}
+	if b.Version != bundleVersion {
+		return nil, fmt.Errorf("unsupported %s version %d", bundleFile, b.Version)
+	}
+	if b.Images == nil {
+		b.Images = make(map[string]string)
+	}
+	if b.Blobs == nil {
+		b.Blobs = make(map[string]string)
+	}
+	b.checksum = checksum
+	return b, nil
+}
+
+// encode returns the content of bundle.json and bundle.json.sha256 of b
+func (b *Bundle) encode() (content, sum []byte, err error) {
+	if content, err = json.MarshalIndent(b, "", "  "); err != nil {
+		return nil, nil, err
+	}
+	content = append(content, '\n')
+	b.checksum = sha256Digest(content)
+	sum = []byte(strings.TrimPrefix(b.checksum, "sha256:") + "  " + bundleFile + "\n")
+	return content, sum, nil
+}
+
+// readBundle parses the index of a bundle read from an archive, which must
+// carry its checksum. nil is returned if the archive has no index.
+func readBundle(content, sum []byte) (*Bundle, error) {
+	if content == nil {
+		return nil, nil
+	}
+	if sum == nil {
+		return nil, fmt.Errorf("%s has no checksum in %s", bundleFile, bundleSumFile)
+	}
+	return parseBundle(content, sum)
+}
@@ -0,0 +1,82 @@
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBundleDelta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatalf("create temp dir fails, error:%s\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	full := filepath.Join(dir, "full.tar")
+	l, err := Create(FormatDockerArchive, full)
+	if err != nil {
+		t.Fatalf("create layout fails, error:%s\n", err)
+	}
+	busybox := writeTestImage(t, l, "docker_library/busybox:1.36", "layer-a", "layer-b")
+	if err := l.Close(); err != nil {
+		t.Fatalf("close layout fails, error:%s\n", err)
+	}
+	baseline, err := LoadBundle(FormatDockerArchive + ":" + full)
+	if err != nil {
+		t.Fatalf("load baseline fails, error:%s\n", err)
+	}
+	if baseline.Baseline != "" || baseline.Images["docker_library/busybox:1.36"] != busybox.Digest || len(baseline.Blobs) != 3 {
+		t.Fatalf("index of the full bundle is wrong: %+v\n", baseline)
+	}
+
+	delta := filepath.Join(dir, "delta")
+	if l, err = Create(FormatOCI, delta); err != nil {
+		t.Fatalf("create layout fails, error:%s\n", err)
+	}
+	l.SetBaseline(baseline)
+	if l.BaselineDigest("docker_library/busybox:1.36") != busybox.Digest {
+		t.Errorf("baseline digest of docker_library/busybox:1.36 should be %s\n", busybox.Digest)
+	}
+	// the config and layer-a are in the baseline
+	writeTestImage(t, l, "docker_library/alpine:3.19", "layer-a", "layer-c")
+	if err := l.Close(); err != nil {
+		t.Fatalf("close layout fails, error:%s\n", err)
+	}
+	files, _ := ioutil.ReadDir(filepath.Join(delta, "blobs", "sha256"))
+	// layer-c and the manifest
+	if len(files) != 2 {
+		t.Errorf("blobs of the baseline should be left out, expected 2 blobs, got %d\n", len(files))
+	}
+
+	r, err := Open(FormatOCI, delta)
+	if err != nil {
+		t.Fatalf("open delta fails, error:%s\n", err)
+	}
+	defer r.Close()
+	b := r.Bundle()
+	if b == nil || b.Baseline != baseline.Checksum() {
+		t.Fatalf("delta should refer to the checksum of the baseline %s, got %+v\n", baseline.Checksum(), b)
+	}
+	required := map[string]string{
+		testDigest([]byte(`{"architecture":"amd64","os":"linux"}`)): "docker_library/busybox",
+		testDigest([]byte("layer-a")):                               "docker_library/busybox",
+	}
+	if !reflect.DeepEqual(b.Required, required) {
+		t.Errorf("expected required blobs %v, got %v\n", required, b.Required)
+	}
+	// the inventory includes the baseline
+	if len(b.Images) != 2 || len(b.Blobs) != 4 {
+		t.Errorf("expected 2 images and 4 blobs in the inventory, got %v and %v\n", b.Images, b.Blobs)
+	}
+
+	// a changed index is refused
+	if err := ioutil.WriteFile(filepath.Join(delta, bundleFile), []byte(`{"version":1}`), 0644); err != nil {
+		t.Fatalf("write index fails, error:%s\n", err)
+	}
+	if _, err := Open(FormatOCI, delta); err == nil {
+		t.Errorf("index mismatching its checksum should be refused\n")
+	}
+}
@@ -0,0 +1,433 @@
+// Package archive stores images on disk in an OCI image layout, either as a
+// directory or as a tar which docker load reads too
+package archive
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oscarzhao/image-sync/registry"
+)
+
+const (
+	// FormatOCI is an OCI image layout directory
+	FormatOCI = "oci"
+	// FormatDockerArchive is a tar of an OCI image layout with the
+	// manifest.json of docker save, so that docker load reads it
+	FormatDockerArchive = "docker-archive"
+
+	// AnnotationImageName is the annotation of manifests in index.json holding
+	// the full name of an image, as docker and containerd write it
+	AnnotationImageName = "io.containerd.image.name"
+	// AnnotationRefName is the annotation of manifests in index.json holding the tag
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+
+	layoutFile   = "oci-layout"
+	indexFile    = "index.json"
+	manifestFile = "manifest.json"
+	layoutJSON   = `{"imageLayoutVersion":"1.0.0"}`
+)
+
+// Parse parses an archive reference, format:path such as oci:/mnt/images
+// or docker-archive:/mnt/images.tar
+func Parse(ref string) (format, path string, err error) {
+	i := strings.Index(ref, ":")
+	if i < 0 {
+		return "", "", fmt.Errorf("invalid archive %s, should be oci:<dir> or docker-archive:<file>", ref)
+	}
+	format, path = ref[:i], ref[i+1:]
+	if format != FormatOCI && format != FormatDockerArchive {
+		return "", "", fmt.Errorf("unknown archive format %s, should be %s or %s", format, FormatOCI, FormatDockerArchive)
+	}
+	if path == "" {
+		return "", "", fmt.Errorf("archive %s has no path", ref)
+	}
+	return format, path, nil
+}
+
+// index is the index.json of an OCI image layout
+type index struct {
+	SchemaVersion int                   `json:"schemaVersion"`
+	MediaType     string                `json:"mediaType,omitempty"`
+	Manifests     []registry.Descriptor `json:"manifests"`
+}
+
+// dockerImage is an entry of the manifest.json of docker save
+type dockerImage struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// Layout writes images to an OCI image layout, it implements
+// registry.ImageWriter. Index files are written by Close.
+type Layout struct {
+	format string
+	path   string
+
+	mu        sync.Mutex
+	manifests []registry.Descriptor
+	images    []dockerImage
+	// bundle is the index written to bundle.json, blobs of baseline are left out
+	bundle   *Bundle
+	baseline *Bundle
+	// blobs are the blobs written to the tar
+	blobs map[string]bool
+	file  *os.File
+	tw    *tar.Writer
+}
+
+// Create creates the layout of format at path. An existing directory layout
+// is added to, a tar is overwritten.
+func Create(format, path string) (*Layout, error) {
+	l := &Layout{format: format, path: path, blobs: make(map[string]bool), bundle: newBundle()}
+	switch format {
+	case FormatOCI:
+		if err := os.MkdirAll(filepath.Join(path, "blobs", "sha256"), 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(filepath.Join(path, layoutFile), []byte(layoutJSON), 0644); err != nil {
+			return nil, err
+		}
+		idx, err := readIndex(filepath.Join(path, indexFile))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if idx != nil {
+			l.manifests = idx.Manifests
+		}
+		content, sum := readOptional(filepath.Join(path, bundleFile)), readOptional(filepath.Join(path, bundleSumFile))
+		b, err := readBundle(content, sum)
+		if err != nil {
+			return nil, err
+		}
+		if b != nil {
+			l.bundle = b
+		}
+	case FormatDockerArchive:
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		l.file, l.tw = f, tar.NewWriter(f)
+		if err := l.writeTarFile(layoutFile, []byte(layoutJSON)); err != nil {
+			f.Close()
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown archive format %s", format)
+	}
+	return l, nil
+}
+
+// String returns the archive reference of l
+func (l *Layout) String() string {
+	return l.format + ":" + l.path
+}
+
+// SetBaseline makes l a delta of baseline, the index of a previous bundle or
+// an inventory of the destination. Blobs of baseline are left out of l, and
+// the images and blobs of baseline are kept in the index of l.
+func (l *Layout) SetBaseline(baseline *Bundle) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.baseline = baseline
+	l.bundle.Baseline = baseline.checksum
+	for name, dgst := range baseline.Images {
+		if _, ok := l.bundle.Images[name]; !ok {
+			l.bundle.Images[name] = dgst
+		}
+	}
+	for dgst, repo := range baseline.Blobs {
+		if _, ok := l.bundle.Blobs[dgst]; !ok {
+			l.bundle.Blobs[dgst] = repo
+		}
+	}
+}
+
+// BaselineDigest returns the manifest digest of image ref in the baseline of
+// l, empty if it has none
+func (l *Layout) BaselineDigest(ref string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.baseline == nil {
+		return ""
+	}
+	return l.baseline.Images[ref]
+}
+
+// HasBlob returns true if blob dgst is written already, or is left out
+// because the baseline has it
+func (l *Layout) HasBlob(dgst string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.baseline != nil && l.baseline.Blobs[dgst] != "" {
+		return true
+	}
+	return l.written(dgst)
+}
+
+// written returns true if blob dgst is in l, l.mu is held
+func (l *Layout) written(dgst string) bool {
+	if l.format == FormatOCI {
+		_, err := os.Stat(l.blobPath(dgst))
+		return err == nil
+	}
+	return l.blobs[dgst]
+}
+
+// WriteBlob writes blob dgst of size bytes read from r. The content is
+// spooled to a temporary file and verified first, so that a broken download
+// leaves nothing behind.
+func (l *Layout) WriteBlob(dgst string, size int64, r io.Reader) error {
+	if !strings.HasPrefix(dgst, "sha256:") {
+		return fmt.Errorf("unsupported digest %s", dgst)
+	}
+	dir := filepath.Dir(l.path)
+	if l.format == FormatOCI {
+		dir = filepath.Join(l.path, "blobs", "sha256")
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return err
+	}
+	if actual := "sha256:" + hex.EncodeToString(h.Sum(nil)); actual != dgst {
+		return fmt.Errorf("digest of blob %s mismatches, got %s", dgst, actual)
+	}
+	if size >= 0 && n != size {
+		return fmt.Errorf("size of blob %s mismatches, expected %d, got %d", dgst, size, n)
+	}
+
+	if l.format == FormatOCI {
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmp.Name(), l.blobPath(dgst))
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.blobs[dgst] {
+		return nil
+	}
+	if err := l.tw.WriteHeader(tarHeader(blobName(dgst), n)); err != nil {
+		return err
+	}
+	if _, err := io.Copy(l.tw, tmp); err != nil {
+		return err
+	}
+	l.blobs[dgst] = true
+	return nil
+}
+
+// Tag names m as ref, an image name such as docker_library/busybox:latest,
+// replacing the image named ref before. docker load takes the first image of
+// a manifest list.
+func (l *Layout) Tag(ref string, m *registry.Manifest, images []*registry.Manifest) error {
+	desc := registry.Descriptor{
+		MediaType: m.MediaType,
+		Size:      int64(len(m.Raw)),
+		Digest:    m.Digest,
+		Annotations: map[string]string{
+			AnnotationImageName: ref,
+			AnnotationRefName:   refTag(ref),
+		},
+	}
+	var image *dockerImage
+	var blobs []string
+	for i, img := range images {
+		im, err := img.Image()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, im.Config.Digest)
+		for _, layer := range im.Layers {
+			if len(layer.URLs) == 0 {
+				blobs = append(blobs, layer.Digest)
+			}
+		}
+		if l.format == FormatDockerArchive && i == 0 {
+			image = &dockerImage{Config: blobName(im.Config.Digest), RepoTags: []string{ref}}
+			for _, layer := range im.Layers {
+				image.Layers = append(image.Layers, blobName(layer.Digest))
+			}
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, d := range l.manifests {
+		if d.Annotations[AnnotationImageName] == ref {
+			l.manifests = append(l.manifests[:i], l.manifests[i+1:]...)
+			break
+		}
+	}
+	l.manifests = append(l.manifests, desc)
+	for i, im := range l.images {
+		if im.RepoTags[0] == ref {
+			l.images = append(l.images[:i], l.images[i+1:]...)
+			break
+		}
+	}
+	if image != nil {
+		l.images = append(l.images, *image)
+	}
+
+	repo := strings.TrimSuffix(ref, ":"+refTag(ref))
+	l.bundle.Images[ref] = m.Digest
+	for _, dgst := range blobs {
+		if _, ok := l.bundle.Blobs[dgst]; !ok {
+			l.bundle.Blobs[dgst] = repo
+		}
+		if l.baseline != nil && l.baseline.Blobs[dgst] != "" && !l.written(dgst) {
+			if l.bundle.Required == nil {
+				l.bundle.Required = make(map[string]string)
+			}
+			l.bundle.Required[dgst] = l.baseline.Blobs[dgst]
+		}
+	}
+	return nil
+}
+
+// Close writes the index of l with the index of the bundle, and the
+// manifest.json of docker save to a tar
+func (l *Layout) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	idx, err := json.Marshal(index{SchemaVersion: 2, MediaType: registry.MediaTypeImageIndex, Manifests: l.manifests})
+	if err != nil {
+		return err
+	}
+	bundle, sum, err := l.bundle.encode()
+	if err != nil {
+		return err
+	}
+	if l.format == FormatOCI {
+		if err := writeFileAtomic(filepath.Join(l.path, bundleFile), bundle); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(l.path, bundleSumFile), sum); err != nil {
+			return err
+		}
+		return writeFileAtomic(filepath.Join(l.path, indexFile), idx)
+	}
+
+	images := l.images
+	if images == nil {
+		images = []dockerImage{}
+	}
+	manifest, err := json.Marshal(images)
+	if err != nil {
+		l.file.Close()
+		return err
+	}
+	if err := l.writeTarFile(indexFile, idx); err != nil {
+		l.file.Close()
+		return err
+	}
+	if err := l.writeTarFile(manifestFile, manifest); err != nil {
+		l.file.Close()
+		return err
+	}
+	if err := l.writeTarFile(bundleFile, bundle); err != nil {
+		l.file.Close()
+		return err
+	}
+	if err := l.writeTarFile(bundleSumFile, sum); err != nil {
+		l.file.Close()
+		return err
+	}
+	if err := l.tw.Close(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+func (l *Layout) blobPath(dgst string) string {
+	return filepath.Join(l.path, filepath.FromSlash(blobName(dgst)))
+}
+
+func (l *Layout) writeTarFile(name string, content []byte) error {
+	if err := l.tw.WriteHeader(tarHeader(name, int64(len(content)))); err != nil {
+		return err
+	}
+	_, err := l.tw.Write(content)
+	return err
+}
+
+// blobName returns the path of blob dgst in a layout
+func blobName(dgst string) string {
+	return "blobs/" + strings.Replace(dgst, ":", "/", 1)
+}
+
+// refTag returns the tag of image name ref
+func refTag(ref string) string {
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[i+1:]
+	}
+	return "latest"
+}
+
+func tarHeader(name string, size int64) *tar.Header {
+	return &tar.Header{Name: name, Size: size, Mode: 0644, ModTime: time.Unix(0, 0), Typeflag: tar.TypeReg}
+}
+
+func readIndex(path string) (*index, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := parseIndex(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s, error:%s", path, err)
+	}
+	return idx, nil
+}
+
+func parseIndex(content []byte) (*index, error) {
+	idx := &index{}
+	if err := json.Unmarshal(content, idx); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// readOptional returns the content of file path, nil if it cannot be read
+func readOptional(path string) []byte {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return content
+}
+
+// writeFileAtomic writes content to a temporary file renamed to path, so that
+// path is never left half written
+func writeFileAtomic(path string, content []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,183 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/oscarzhao/image-sync/registry"
+)
+
+func testDigest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// writeTestImage writes an image with a config and the given layers to l as ref
+func writeTestImage(t *testing.T, l *Layout, ref string, layers ...string) *registry.Manifest {
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	image := registry.ImageManifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeManifest,
+		Config:        registry.Descriptor{MediaType: "application/vnd.docker.container.image.v1+json", Size: int64(len(config)), Digest: testDigest(config)},
+	}
+	blobs := [][]byte{config}
+	for _, layer := range layers {
+		blobs = append(blobs, []byte(layer))
+		image.Layers = append(image.Layers, registry.Descriptor{MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip", Size: int64(len(layer)), Digest: testDigest([]byte(layer))})
+	}
+	raw, err := json.Marshal(image)
+	if err != nil {
+		t.Fatalf("marshal manifest fails, error:%s\n", err)
+	}
+	m := &registry.Manifest{MediaType: registry.MediaTypeManifest, Raw: raw, Digest: testDigest(raw)}
+	for _, blob := range append(blobs, raw) {
+		if l.HasBlob(testDigest(blob)) {
+			continue
+		}
+		if err := l.WriteBlob(testDigest(blob), int64(len(blob)), bytes.NewReader(blob)); err != nil {
+			t.Fatalf("write blob fails, error:%s\n", err)
+		}
+	}
+	if err := l.Tag(ref, m, []*registry.Manifest{m}); err != nil {
+		t.Fatalf("tag %s fails, error:%s\n", ref, err)
+	}
+	return m
+}
+
+func TestLayoutDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layout")
+	if err != nil {
+		t.Fatalf("create temp dir fails, error:%s\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := Create(FormatOCI, dir)
+	if err != nil {
+		t.Fatalf("create layout fails, error:%s\n", err)
+	}
+	busybox := writeTestImage(t, l, "docker_library/busybox:latest", "layer-a", "layer-b")
+	if err := l.WriteBlob(testDigest([]byte("x")), 1, bytes.NewReader([]byte("y"))); err == nil {
+		t.Errorf("blob of a wrong digest should not be written\n")
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("close layout fails, error:%s\n", err)
+	}
+
+	// the layout is added to, and images of the same name are replaced
+	if l, err = Create(FormatOCI, dir); err != nil {
+		t.Fatalf("open layout fails, error:%s\n", err)
+	}
+	alpine := writeTestImage(t, l, "docker_library/alpine:3.19", "layer-a", "layer-c")
+	if err := l.Close(); err != nil {
+		t.Fatalf("close layout fails, error:%s\n", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		t.Fatalf("read index fails, error:%s\n", err)
+	}
+	var idx index
+	if err := json.Unmarshal(content, &idx); err != nil {
+		t.Fatalf("invalid index, error:%s\n", err)
+	}
+	var names []string
+	for _, m := range idx.Manifests {
+		names = append(names, m.Annotations[AnnotationImageName]+"@"+m.Digest)
+	}
+	expected := []string{"docker_library/busybox:latest@" + busybox.Digest, "docker_library/alpine:3.19@" + alpine.Digest}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected images %v in the index, got %v\n", expected, names)
+	}
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "blobs", "sha256"))
+	// a config, 3 layers and 2 manifests
+	if len(files) != 6 {
+		t.Errorf("expected 6 blobs, got %d\n", len(files))
+	}
+}
+
+func TestLayoutDockerArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layout")
+	if err != nil {
+		t.Fatalf("create temp dir fails, error:%s\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "images.tar")
+	l, err := Create(FormatDockerArchive, path)
+	if err != nil {
+		t.Fatalf("create layout fails, error:%s\n", err)
+	}
+	writeTestImage(t, l, "docker_library/busybox:latest", "layer-a", "layer-b")
+	writeTestImage(t, l, "docker_library/alpine:3.19", "layer-a")
+	if err := l.Close(); err != nil {
+		t.Fatalf("close layout fails, error:%s\n", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open tar fails, error:%s\n", err)
+	}
+	defer f.Close()
+	entries := make(map[string][]byte)
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read tar fails, error:%s\n", err)
+		}
+		if _, ok := entries[h.Name]; ok {
+			t.Errorf("%s is written twice\n", h.Name)
+		}
+		entries[h.Name], _ = ioutil.ReadAll(tr)
+	}
+	// oci-layout, index.json, manifest.json, bundle.json with its checksum, a
+	// config, 2 layers and 2 manifests
+	if len(entries) != 10 {
+		t.Errorf("expected 10 files in the tar, got %d\n", len(entries))
+	}
+	var images []dockerImage
+	if err := json.Unmarshal(entries["manifest.json"], &images); err != nil {
+		t.Fatalf("invalid manifest.json, error:%s\n", err)
+	}
+	if len(images) != 2 || images[1].RepoTags[0] != "docker_library/alpine:3.19" || len(images[0].Layers) != 2 {
+		t.Errorf("manifest.json is wrong: %s\n", entries["manifest.json"])
+	}
+	for _, name := range append(images[0].Layers, images[0].Config) {
+		if _, ok := entries[name]; !ok {
+			t.Errorf("%s in manifest.json is not in the tar\n", name)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		ref    string
+		format string
+		path   string
+		valid  bool
+	}{
+		{"oci:/mnt/images", FormatOCI, "/mnt/images", true},
+		{"docker-archive:images.tar", FormatDockerArchive, "images.tar", true},
+		{"oci:", "", "", false},
+		{"/mnt/images", "", "", false},
+		{"zip:images.zip", "", "", false},
+	}
+	for _, tc := range testCases {
+		format, path, err := Parse(tc.ref)
+		if (err == nil) != tc.valid || format != tc.format || path != tc.path {
+			t.Errorf("parse %s: expected %s, %s, valid:%v, got %s, %s, error:%v\n", tc.ref, tc.format, tc.path, tc.valid, format, path, err)
+		}
+	}
+}
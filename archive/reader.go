@@ -0,0 +1,319 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"github.com/oscarzhao/image-sync/registry"
+)
+
+const (
+	// mediaTypeConfig and mediaTypeLayer are the media types of blobs of
+	// images written by docker save before it wrote OCI image layouts
+	mediaTypeConfig = "application/vnd.docker.container.image.v1+json"
+	mediaTypeLayer  = "application/vnd.docker.image.rootfs.diff.tar"
+)
+
+// Image is an image of an archive
+type Image struct {
+	// Repo and Tag are the name of the image without a registry, such as
+	// docker_library/busybox and latest
+	Repo     string
+	Tag      string
+	Manifest *registry.Manifest
+}
+
+// tarEntry is where the content of a file is in a tar
+type tarEntry struct {
+	offset int64
+	size   int64
+}
+
+// Reader reads the images of an OCI image layout, of a tar of it, or of a
+// tar written by docker save, it implements registry.ImageReader. Images
+// written by docker save without an OCI image layout get schema2 manifests
+// made from their files.
+type Reader struct {
+	format string
+	path   string
+	images []Image
+	bundle *Bundle
+
+	file    *os.File
+	entries map[string]tarEntry
+	// files maps digests of blobs made from docker save files to the files
+	files map[string]string
+	// manifests are the manifests made for images of docker save
+	manifests map[string][]byte
+}
+
+// Open opens the archive of format at path and reads its index
+func Open(format, path string) (*Reader, error) {
+	r := &Reader{format: format, path: path, files: make(map[string]string), manifests: make(map[string][]byte)}
+	switch format {
+	case FormatOCI:
+		idx, err := readIndex(filepath.Join(path, indexFile))
+		if err != nil {
+			return nil, err
+		}
+		content, sum := readOptional(filepath.Join(path, bundleFile)), readOptional(filepath.Join(path, bundleSumFile))
+		if r.bundle, err = readBundle(content, sum); err != nil {
+			return nil, fmt.Errorf("invalid bundle %s, error:%s", r, err)
+		}
+		if err := r.readImages(idx); err != nil {
+			return nil, err
+		}
+	case FormatDockerArchive:
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		r.file = f
+		if err := r.readTar(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown archive format %s", format)
+	}
+	return r, nil
+}
+
+// String returns the archive reference of r
+func (r *Reader) String() string {
+	return r.format + ":" + r.path
+}
+
+// Images returns the images of r, in the order of the index
+func (r *Reader) Images() []Image {
+	return r.images
+}
+
+// Bundle returns the index of the bundle r is, nil if r has none, such as
+// archives written by docker save
+func (r *Reader) Bundle() *Bundle {
+	return r.bundle
+}
+
+// Image returns image repo:tag of r, nil if there is none
+func (r *Reader) Image(repo, tag string) *Image {
+	for i := range r.images {
+		if r.images[i].Repo == repo && r.images[i].Tag == tag {
+			return &r.images[i]
+		}
+	}
+	return nil
+}
+
+// OpenBlob reads blob dgst from offset
+func (r *Reader) OpenBlob(dgst string, offset int64) (io.ReadCloser, error) {
+	if m, ok := r.manifests[dgst]; ok {
+		return ioutil.NopCloser(bytes.NewReader(m[offset:])), nil
+	}
+	if r.format == FormatOCI {
+		f, err := os.Open(filepath.Join(r.path, filepath.FromSlash(blobName(dgst))))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+
+	name, ok := r.files[dgst]
+	if !ok {
+		name = blobName(dgst)
+	}
+	e, ok := r.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("blob %s is not in %s", dgst, r)
+	}
+	return ioutil.NopCloser(io.NewSectionReader(r.file, e.offset+offset, e.size-offset)), nil
+}
+
+// Close closes the tar of r
+func (r *Reader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// readTar finds the files in the tar, and reads the images of its OCI image
+// layout, or of the manifest.json of docker save if it has no index
+func (r *Reader) readTar() error {
+	r.entries = make(map[string]tarEntry)
+	tr := tar.NewReader(r.file)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		// archive/tar reads no more than the header, the content starts here
+		offset, err := r.file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		r.entries[path.Clean(h.Name)] = tarEntry{offset: offset, size: h.Size}
+	}
+
+	if _, ok := r.entries[bundleFile]; ok {
+		content, err := r.readFile(bundleFile)
+		if err != nil {
+			return err
+		}
+		sum, _ := r.readFile(bundleSumFile)
+		if r.bundle, err = readBundle(content, sum); err != nil {
+			return fmt.Errorf("invalid bundle %s, error:%s", r, err)
+		}
+	}
+	if _, ok := r.entries[indexFile]; ok {
+		content, err := r.readFile(indexFile)
+		if err != nil {
+			return err
+		}
+		idx, err := parseIndex(content)
+		if err != nil {
+			return fmt.Errorf("invalid %s of %s, error:%s", indexFile, r, err)
+		}
+		return r.readImages(idx)
+	}
+	content, err := r.readFile(manifestFile)
+	if err != nil {
+		return fmt.Errorf("%s has neither %s nor %s", r, indexFile, manifestFile)
+	}
+	var images []dockerImage
+	if err := json.Unmarshal(content, &images); err != nil {
+		return fmt.Errorf("invalid %s of %s, error:%s", manifestFile, r, err)
+	}
+	for _, image := range images {
+		if err := r.readDockerImage(image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readImages reads the manifests of the images in idx, manifests without an
+// image name cannot be imported and are skipped
+func (r *Reader) readImages(idx *index) error {
+	for _, desc := range idx.Manifests {
+		name := desc.Annotations[AnnotationImageName]
+		if name == "" && strings.ContainsAny(desc.Annotations[AnnotationRefName], "/:") {
+			name = desc.Annotations[AnnotationRefName]
+		}
+		repo, tag, ok := parseName(name)
+		if !ok {
+			glog.Warningf("manifest %s of %s has no image name, skip it\n", desc.Digest, r)
+			continue
+		}
+		m, err := registry.ReadManifest(r, desc.Digest, desc.MediaType)
+		if err != nil {
+			return fmt.Errorf("read manifest %s of %s fails, error:%s", desc.Digest, r, err)
+		}
+		r.images = append(r.images, Image{Repo: repo, Tag: tag, Manifest: m})
+	}
+	return nil
+}
+
+// readDockerImage makes a schema2 manifest for an image of docker save, the
+// layers are the uncompressed tars docker save writes
+func (r *Reader) readDockerImage(image dockerImage) error {
+	m := registry.ImageManifest{SchemaVersion: 2, MediaType: registry.MediaTypeManifest}
+	var err error
+	if m.Config, err = r.fileBlob(image.Config, mediaTypeConfig); err != nil {
+		return err
+	}
+	for _, layer := range image.Layers {
+		desc, err := r.fileBlob(layer, mediaTypeLayer)
+		if err != nil {
+			return err
+		}
+		m.Layers = append(m.Layers, desc)
+	}
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	dgst := sha256Digest(raw)
+	r.manifests[dgst] = raw
+	for _, name := range image.RepoTags {
+		repo, tag, ok := parseName(name)
+		if !ok {
+			continue
+		}
+		r.images = append(r.images, Image{Repo: repo, Tag: tag, Manifest: &registry.Manifest{MediaType: m.MediaType, Raw: raw, Digest: dgst}})
+	}
+	return nil
+}
+
+// fileBlob returns the descriptor of file name of the tar as a blob
+func (r *Reader) fileBlob(name, mediaType string) (registry.Descriptor, error) {
+	e, ok := r.entries[path.Clean(name)]
+	if !ok {
+		return registry.Descriptor{}, fmt.Errorf("%s is not in %s", name, r)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r.file, e.offset, e.size)); err != nil {
+		return registry.Descriptor{}, err
+	}
+	dgst := "sha256:" + hex.EncodeToString(h.Sum(nil))
+	r.files[dgst] = path.Clean(name)
+	return registry.Descriptor{MediaType: mediaType, Size: e.size, Digest: dgst}, nil
+}
+
+// readFile reads file name of the tar
+func (r *Reader) readFile(name string) ([]byte, error) {
+	e, ok := r.entries[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.ReadAll(io.NewSectionReader(r.file, e.offset, e.size))
+}
+
+// parseName parses an image name of an archive, such as
+// docker.io/library/busybox:latest or busybox:latest, into the repo without
+// the registry and the tag. Names of docker hub images get library/.
+func parseName(name string) (repo, tag string, ok bool) {
+	if name == "" || strings.Contains(name, "@") {
+		return "", "", false
+	}
+	repo, tag = name, "latest"
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		repo, tag = name[:i], name[i+1:]
+	}
+	if i := strings.Index(repo, "/"); i >= 0 {
+		if host := repo[:i]; strings.ContainsAny(host, ".:") || host == "localhost" {
+			repo = repo[i+1:]
+		}
+	}
+	if !strings.Contains(repo, "/") {
+		repo = "library/" + repo
+	}
+	return repo, tag, repo != "" && tag != ""
+}
+
+func sha256Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,149 @@
+package archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oscarzhao/image-sync/registry"
+)
+
+func TestReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layout")
+	if err != nil {
+		t.Fatalf("create temp dir fails, error:%s\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		format string
+		path   string
+	}{
+		{FormatOCI, filepath.Join(dir, "images")},
+		{FormatDockerArchive, filepath.Join(dir, "images.tar")},
+	} {
+		l, err := Create(tc.format, tc.path)
+		if err != nil {
+			t.Fatalf("create layout fails, error:%s\n", err)
+		}
+		busybox := writeTestImage(t, l, "docker_library/busybox:latest", "layer-a", "layer-b")
+		writeTestImage(t, l, "docker.io/library/alpine:3.19", "layer-a")
+		if err := l.Close(); err != nil {
+			t.Fatalf("close layout fails, error:%s\n", err)
+		}
+
+		r, err := Open(tc.format, tc.path)
+		if err != nil {
+			t.Fatalf("open %s fails, error:%s\n", tc.format, err)
+		}
+		if n := len(r.Images()); n != 2 {
+			t.Errorf("%s: expected 2 images, got %d\n", tc.format, n)
+		}
+		if r.Image("library/alpine", "3.19") == nil {
+			t.Errorf("%s: the registry of docker.io/library/alpine:3.19 should be dropped\n", tc.format)
+		}
+		image := r.Image("docker_library/busybox", "latest")
+		if image == nil || image.Manifest.Digest != busybox.Digest {
+			t.Fatalf("%s: expected docker_library/busybox:latest of %s, got %v\n", tc.format, busybox.Digest, image)
+		}
+		im, err := image.Manifest.Image()
+		if err != nil {
+			t.Fatalf("%s: invalid manifest, error:%s\n", tc.format, err)
+		}
+		blob, err := r.OpenBlob(im.Layers[1].Digest, 2)
+		if err != nil {
+			t.Fatalf("%s: open blob fails, error:%s\n", tc.format, err)
+		}
+		content, _ := ioutil.ReadAll(blob)
+		blob.Close()
+		if string(content) != "yer-b" {
+			t.Errorf("%s: expected yer-b from offset 2, got %q\n", tc.format, content)
+		}
+		r.Close()
+	}
+}
+
+func TestReaderDockerSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layout")
+	if err != nil {
+		t.Fatalf("create temp dir fails, error:%s\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// docker save before 25.0 writes no OCI image layout
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	configFile := testDigest(config)[len("sha256:"):] + ".json"
+	manifest, _ := json.Marshal([]dockerImage{{Config: configFile, RepoTags: []string{"busybox:latest", "busybox:1.36"}, Layers: []string{"1a2b/layer.tar"}}})
+	path := filepath.Join(dir, "busybox.tar")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create tar fails, error:%s\n", err)
+	}
+	tw := tar.NewWriter(f)
+	for _, file := range []struct {
+		name    string
+		content []byte
+	}{
+		{"1a2b/VERSION", []byte("1.0")},
+		{"1a2b/layer.tar", []byte("layer-a")},
+		{configFile, config},
+		{manifestFile, manifest},
+	} {
+		tw.WriteHeader(tarHeader(file.name, int64(len(file.content))))
+		tw.Write(file.content)
+	}
+	tw.Close()
+	f.Close()
+
+	r, err := Open(FormatDockerArchive, path)
+	if err != nil {
+		t.Fatalf("open docker save tar fails, error:%s\n", err)
+	}
+	defer r.Close()
+	image := r.Image("library/busybox", "1.36")
+	if image == nil || len(r.Images()) != 2 {
+		t.Fatalf("expected library/busybox:latest and 1.36, got %v\n", r.Images())
+	}
+	m, err := registry.ReadManifest(r, image.Manifest.Digest, "")
+	if err != nil {
+		t.Fatalf("read the manifest made for the image fails, error:%s\n", err)
+	}
+	im, err := m.Image()
+	if err != nil || im.Config.Digest != testDigest(config) || len(im.Layers) != 1 || im.Layers[0].Digest != testDigest([]byte("layer-a")) {
+		t.Fatalf("manifest made for the image is wrong: %s\n", m.Raw)
+	}
+	blob, err := r.OpenBlob(im.Layers[0].Digest, 0)
+	if err != nil {
+		t.Fatalf("open layer fails, error:%s\n", err)
+	}
+	defer blob.Close()
+	if content, _ := ioutil.ReadAll(blob); string(content) != "layer-a" {
+		t.Errorf("expected layer-a, got %q\n", content)
+	}
+}
+
+func TestParseName(t *testing.T) {
+	testCases := []struct {
+		name string
+		repo string
+		tag  string
+		ok   bool
+	}{
+		{"docker_library/busybox:1.36", "docker_library/busybox", "1.36", true},
+		{"busybox", "library/busybox", "latest", true},
+		{"docker.io/library/busybox:latest", "library/busybox", "latest", true},
+		{"localhost:5000/team/app:v1", "team/app", "v1", true},
+		{"localhost/app:v1", "library/app", "v1", true},
+		{"busybox@sha256:1a2b", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tc := range testCases {
+		repo, tag, ok := parseName(tc.name)
+		if repo != tc.repo || tag != tc.tag || ok != tc.ok {
+			t.Errorf("parse %s: expected %s, %s, %v, got %s, %s, %v\n", tc.name, tc.repo, tc.tag, tc.ok, repo, tag, ok)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/oscarzhao/image-sync/archive"
+	"github.com/oscarzhao/image-sync/config"
+	"github.com/oscarzhao/image-sync/registry"
+)
+
+func TestOpenArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatalf("create temp dir fails, error:%s\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ref := "oci:" + filepath.Join(dir, "images")
+	l, err := openArchive(ref, "")
+	if err != nil {
+		t.Fatalf("open archive fails, error:%s\n", err)
+	}
+	if shared, _ := openArchive(ref, ""); shared != l {
+		t.Errorf("jobs exporting to %s should share the archive\n", ref)
+	}
+	if !closeArchives() {
+		t.Errorf("close archives fails\n")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "images", "index.json")); err != nil {
+		t.Errorf("index of %s is not written, error:%s\n", ref, err)
+	}
+}
+
+func TestCheckArchives(t *testing.T) {
+	defer func(mode string, dry bool) { copyMode, dryRun = mode, dry }(copyMode, dryRun)
+	cfg := &config.Config{Jobs: []config.Job{
+		{Name: "a", Destination: config.Endpoint{Namespace: "docker_library"}},
+		{Name: "b", Destination: config.Endpoint{Namespace: "docker_library", Archive: "oci:images"}},
+	}}
+	testCases := []struct {
+		copyMode  string
+		dryRun    bool
+		serveMode bool
+		valid     bool
+	}{
+		{"registry", false, false, true},
+		{"registry", false, true, false},
+		{"registry", true, false, false},
+		{"docker", false, false, false},
+	}
+	for _, tc := range testCases {
+		copyMode, dryRun = tc.copyMode, tc.dryRun
+		if err := checkArchives(cfg, tc.serveMode); (err == nil) != tc.valid {
+			t.Errorf("copy mode %s, dry run %v, serve %v: expected valid %v, got error %v\n", tc.copyMode, tc.dryRun, tc.serveMode, tc.valid, err)
+		}
+	}
+}
+
+func TestListArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatalf("create temp dir fails, error:%s\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := archive.Create(archive.FormatOCI, dir)
+	if err != nil {
+		t.Fatalf("create archive fails, error:%s\n", err)
+	}
+	raw := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","config":{},"layers":[]}`)
+	sum := sha256.Sum256(raw)
+	m := &registry.Manifest{MediaType: registry.MediaTypeManifest, Raw: raw, Digest: "sha256:" + hex.EncodeToString(sum[:])}
+	if err := l.WriteBlob(m.Digest, int64(len(raw)), bytes.NewReader(raw)); err != nil {
+		t.Fatalf("write manifest fails, error:%s\n", err)
+	}
+	for _, ref := range []string{"docker_library/busybox:1.36", "docker_library/busybox:1.36-rc1", "docker_library/nginx:1.25", "docker_library/redis:7", "library/alpine:3.19"} {
+		l.Tag(ref, m, nil)
+	}
+	l.Close()
+	r, err := archive.Open(archive.FormatOCI, dir)
+	if err != nil {
+		t.Fatalf("open archive fails, error:%s\n", err)
+	}
+	defer r.Close()
+
+	cfg, err := config.Parse([]byte(`
+jobs:
+- name: import
+  source: {namespace: docker_library}
+  destination: {namespace: library}
+  repos: {exclude: ["redis"]}
+  tags: {exclude: [".*-rc\\d*"]}
+`))
+	if err != nil {
+		t.Fatalf("parse config fails, error:%s\n", err)
+	}
+	s := &syncer{job: cfg.Jobs[0], source: r}
+	repo2tags := s.listArchive()
+	expected := map[string][]string{
+		"docker_library/busybox": {"1.36"},
+		"docker_library/nginx":   {"1.25"},
+	}
+	if !reflect.DeepEqual(repo2tags, expected) {
+		t.Errorf("expected images %v, got %v\n", expected, repo2tags)
+	}
+	var upstream []string
+	for tag := range s.upstream["docker_library/busybox"] {
+		upstream = append(upstream, tag)
+	}
+	sort.Strings(upstream)
+	if !reflect.DeepEqual(upstream, []string{"1.36", "1.36-rc1"}) {
+		t.Errorf("all tags of the archive should be upstream tags, got %v\n", upstream)
+	}
+}
@@ -0,0 +1,342 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/oscarzhao/image-sync/archive"
+	"github.com/oscarzhao/image-sync/schedule"
+)
+
+// Config declares sync jobs, and credentials they refer to
+type Config struct {
+	Credentials map[string]Credential `yaml:"credentials"`
+	Concurrency Concurrency           `yaml:"concurrency"`
+	Jobs        []Job                 `yaml:"jobs"`
+}
+
+// Concurrency configures the number of workers of each pipeline stage,
+// zero values are replaced by command line flags
+type Concurrency struct {
+	Copy  int `yaml:"copy"`
+	Check int `yaml:"check"`
+	Pull  int `yaml:"pull"`
+	Tag   int `yaml:"tag"`
+	Push  int `yaml:"push"`
+	// PerRegistry limits concurrent operations against each registry host, 0 means unlimited
+	PerRegistry int `yaml:"perRegistry"`
+}
+
+// Credential holds the credential of a registry, username, password
+// and identity token may be read from environment variables
+type Credential struct {
+	Username         string `yaml:"username"`
+	Password         string `yaml:"password"`
+	IdentityToken    string `yaml:"identityToken"`
+	UsernameEnv      string `yaml:"usernameEnv"`
+	PasswordEnv      string `yaml:"passwordEnv"`
+	IdentityTokenEnv string `yaml:"identityTokenEnv"`
+}
+
+// Endpoint is a namespace in a registry, an empty registry means docker hub
+type Endpoint struct {
+	Registry    string `yaml:"registry"`
+	Version     string `yaml:"version"`
+	Proto       string `yaml:"proto"`
+	Namespace   string `yaml:"namespace"`
+	Credentials string `yaml:"credentials"`
+	// PageSize is the hint of entries per page when listing repos and tags, 0 lets the registry decide
+	PageSize int `yaml:"pageSize"`
+	// Archive is an image layout on disk images are imported from or
+	// exported to instead of the registry, oci:<dir> or docker-archive:<file>
+	Archive string `yaml:"archive"`
+	// Baseline makes the destination archive a delta of a previous one, it is
+	// an archive or the bundle.json of one, or an inventory of the destination
+	Baseline string `yaml:"baseline"`
+}
+
+// Filter selects names by regular expressions, a name is selected if it matches
+// any include pattern (or include is empty) and matches no exclude pattern.
+// Patterns must match the whole name.
+type Filter struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// Job synchronizes repos under source namespace to destination namespace
+type Job struct {
+	Name        string   `yaml:"name"`
+	Source      Endpoint `yaml:"source"`
+	Destination Endpoint `yaml:"destination"`
+	// Repos filters repo names without the source namespace
+	Repos Filter    `yaml:"repos"`
+	Tags  TagFilter `yaml:"tags"`
+	// RepoTags overrides Tags for some repos, keys are repo names without the source namespace
+	RepoTags map[string]TagFilter `yaml:"repoTags"`
+	// Platforms selects images of multi-arch tags, such as linux/arm64, all platforms if empty
+	Platforms []string `yaml:"platforms"`
+	// Schedule is a cron expression of the runs in serve mode, such as "0 */6 * * *" or @daily
+	Schedule string `yaml:"schedule"`
+	// Prune deletes destination tags missing upstream
+	Prune PrunePolicy `yaml:"prune"`
+	// Retention deletes destination tags its rules do not keep, RepoRetention
+	// overrides it for some repos, keys are repo names without the source namespace
+	Retention     RetentionPolicy            `yaml:"retention"`
+	RepoRetention map[string]RetentionPolicy `yaml:"repoRetention"`
+}
+
+// PrunePolicy deletes destination tags which are missing upstream after a run,
+// tags outside the tag filter of the job are never pruned
+type PrunePolicy struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxDeletions stops pruning the job if more tags would be deleted in a
+	// run, zero is replaced by a command line flag
+	MaxDeletions int `yaml:"maxDeletions"`
+	// Protect are patterns of tags never pruned, they must match the whole tag
+	Protect []string `yaml:"protect"`
+
+	protect []*regexp.Regexp
+}
+
+// Load reads and validates the config file at path
+func Load(path string) (*Config, error) {
+	rawBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(rawBytes)
+}
+
+// Parse parses and validates a yaml config
+func Parse(rawBytes []byte) (*Config, error) {
+	var c Config
+	if err := yaml.UnmarshalStrict(rawBytes, &c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Validate checks the config, fills default values and compiles filters
+func (c *Config) Validate() error {
+	if len(c.Jobs) == 0 {
+		return errors.New("no sync job found")
+	}
+	cc := c.Concurrency
+	for _, n := range []int{cc.Copy, cc.Check, cc.Pull, cc.Tag, cc.Push, cc.PerRegistry} {
+		if n < 0 {
+			return fmt.Errorf("concurrency must not be negative, got %d", n)
+		}
+	}
+	names := make(map[string]bool)
+	for i := range c.Jobs {
+		job := &c.Jobs[i]
+		if job.Name == "" {
+			return fmt.Errorf("job #%d has no name", i)
+		}
+		if names[job.Name] {
+			return fmt.Errorf("job name %s is duplicated", job.Name)
+		}
+		names[job.Name] = true
+
+		for _, endpoint := range []*Endpoint{&job.Source, &job.Destination} {
+			if endpoint.Version == "" {
+				endpoint.Version = "v2"
+			}
+			if endpoint.Proto == "" {
+				endpoint.Proto = "https"
+			}
+			if endpoint.PageSize < 0 {
+				return fmt.Errorf("job %s has negative page size %d", job.Name, endpoint.PageSize)
+			}
+			if endpoint.Credentials == "" {
+				continue
+			}
+			if _, ok := c.Credentials[endpoint.Credentials]; !ok {
+				return fmt.Errorf("job %s refers to undefined credentials %s", job.Name, endpoint.Credentials)
+			}
+		}
+		if job.Destination.Namespace == "" {
+			return fmt.Errorf("job %s has no destination namespace", job.Name)
+		}
+		if job.Source.Baseline != "" || job.Destination.Baseline != "" && job.Destination.Archive == "" {
+			return fmt.Errorf("job %s has a baseline, which is for destination archives only", job.Name)
+		}
+		if job.Source.Archive != "" && job.Destination.Archive != "" {
+			return fmt.Errorf("job %s has archives at both source and destination", job.Name)
+		}
+		if job.Source.Archive != "" {
+			if _, _, err := archive.Parse(job.Source.Archive); err != nil {
+				return fmt.Errorf("job %s has invalid archive, error:%s", job.Name, err)
+			}
+		}
+		if job.Destination.Archive != "" {
+			if _, _, err := archive.Parse(job.Destination.Archive); err != nil {
+				return fmt.Errorf("job %s has invalid archive, error:%s", job.Name, err)
+			}
+			if job.Prune.Enabled || job.Retention.Enabled() || len(job.RepoRetention) > 0 {
+				return fmt.Errorf("job %s exports to an archive, which cannot be pruned or have retention", job.Name)
+			}
+		}
+
+		if err := job.Repos.Compile(); err != nil {
+			return fmt.Errorf("job %s has invalid repo filter, error:%s", job.Name, err)
+		}
+		if err := job.Tags.Compile(); err != nil {
+			return fmt.Errorf("job %s has invalid tag filter, error:%s", job.Name, err)
+		}
+		for repo, filter := range job.RepoTags {
+			if err := filter.Compile(); err != nil {
+				return fmt.Errorf("job %s has invalid tag filter for repo %s, error:%s", job.Name, repo, err)
+			}
+			job.RepoTags[repo] = filter
+		}
+		for _, platform := range job.Platforms {
+			if arr := strings.Split(platform, "/"); len(arr) < 2 || len(arr) > 3 || arr[0] == "" || arr[1] == "" {
+				return fmt.Errorf("job %s has invalid platform %q, should be os/arch[/variant]", job.Name, platform)
+			}
+		}
+		if job.Prune.MaxDeletions < 0 {
+			return fmt.Errorf("job %s has negative max deletions %d", job.Name, job.Prune.MaxDeletions)
+		}
+		if err := job.Prune.Compile(); err != nil {
+			return fmt.Errorf("job %s has invalid protected tags, error:%s", job.Name, err)
+		}
+		if err := job.Retention.Compile(); err != nil {
+			return fmt.Errorf("job %s has invalid retention, error:%s", job.Name, err)
+		}
+		for repo, policy := range job.RepoRetention {
+			if err := policy.Compile(); err != nil {
+				return fmt.Errorf("job %s has invalid retention for repo %s, error:%s", job.Name, repo, err)
+			}
+			job.RepoRetention[repo] = policy
+		}
+		if job.Schedule != "" {
+			if _, err := schedule.Parse(job.Schedule); err != nil {
+				return fmt.Errorf("job %s has invalid schedule, error:%s", job.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// TagFilter returns the tag filter of repo, repo is the name without the source namespace
+func (j *Job) TagFilter(repo string) *TagFilter {
+	if filter, ok := j.RepoTags[repo]; ok {
+		return &filter
+	}
+	return &j.Tags
+}
+
+// RetentionOf returns the retention policy of repo, repo is the name without the source namespace
+func (j *Job) RetentionOf(repo string) *RetentionPolicy {
+	if policy, ok := j.RepoRetention[repo]; ok {
+		return &policy
+	}
+	return &j.Retention
+}
+
+// SetDefaults replaces zero values of c with values in d
+func (c *Concurrency) SetDefaults(d Concurrency) {
+	setDefault(&c.Copy, d.Copy)
+	setDefault(&c.Check, d.Check)
+	setDefault(&c.Pull, d.Pull)
+	setDefault(&c.Tag, d.Tag)
+	setDefault(&c.Push, d.Push)
+	setDefault(&c.PerRegistry, d.PerRegistry)
+}
+
+func setDefault(value *int, defaultValue int) {
+	if *value == 0 {
+		*value = defaultValue
+	}
+}
+
+// Credential returns the resolved credential named name, empty name means anonymous
+func (c *Config) Credential(name string) (Credential, error) {
+	if name == "" {
+		return Credential{}, nil
+	}
+	cred, ok := c.Credentials[name]
+	if !ok {
+		return Credential{}, fmt.Errorf("credentials %s not found", name)
+	}
+	if cred.UsernameEnv != "" {
+		cred.Username = os.Getenv(cred.UsernameEnv)
+	}
+	if cred.PasswordEnv != "" {
+		cred.Password = os.Getenv(cred.PasswordEnv)
+	}
+	if cred.IdentityTokenEnv != "" {
+		cred.IdentityToken = os.Getenv(cred.IdentityTokenEnv)
+	}
+	return cred, nil
+}
+
+// Compile compiles the patterns of f, it must be called before Match
+func (f *Filter) Compile() error {
+	var err error
+	if f.include, err = compilePatterns(f.Include); err != nil {
+		return err
+	}
+	f.exclude, err = compilePatterns(f.Exclude)
+	return err
+}
+
+// Match returns true if name is selected by f
+func (f *Filter) Match(name string) bool {
+	for _, re := range f.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compile compiles the protected patterns of p, it must be called before Protected
+func (p *PrunePolicy) Compile() error {
+	var err error
+	p.protect, err = compilePatterns(p.Protect)
+	return err
+}
+
+// Protected returns true if tag must never be pruned
+func (p *PrunePolicy) Protected(tag string) bool {
+	for _, re := range p.protect {
+		if re.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+credentials:
+  tenx:
+    username: oscarzhao
+    passwordEnv: TEST_TENX_PASSWORD
+  hub:
+    username: oscarzhao
+    identityTokenEnv: TEST_HUB_TOKEN
+concurrency:
+  copy: 8
+  perRegistry: 4
+jobs:
+- name: google-containers
+  source:
+    registry: gcr.io
+    namespace: google_containers
+  destination:
+    registry: index.tenxcloud.com
+    namespace: google_containers
+    credentials: tenx
+  repos:
+    include: ["pause", "etcd.*"]
+  tags:
+    exclude: [".*-rc\\d*"]
+  repoTags:
+    etcd:
+      semver: ">=3.0"
+      latest: 2
+- name: library
+  source:
+    namespace: library
+  destination:
+    registry: index.tenxcloud.com
+    namespace: docker_library
+    credentials: tenx
+  schedule: "0 */6 * * *"
+  prune:
+    enabled: true
+    protect: ["latest", "v1\\..*"]
+  retention:
+    keepLatest: 5
+    keepWithin: 30d
+    keep: ["latest"]
+  repoRetention:
+    nginx:
+      keepLatest: 10
+`
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(testConfig))
+	if err != nil {
+		t.Fatalf("parse config should succeed, error:%s\n", err)
+	}
+	if len(c.Jobs) != 2 {
+		t.Fatalf("should get 2 jobs, got %d\n", len(c.Jobs))
+	}
+
+	c.Concurrency.SetDefaults(Concurrency{Copy: 1, Pull: 2, PerRegistry: 0})
+	if c.Concurrency.Copy != 8 || c.Concurrency.Pull != 2 || c.Concurrency.PerRegistry != 4 {
+		t.Errorf("concurrency should be merged with defaults, got %#v\n", c.Concurrency)
+	}
+
+	job := c.Jobs[0]
+	if job.Source.Version != "v2" || job.Source.Proto != "https" {
+		t.Errorf("default version and proto should be set, got %#v\n", job.Source)
+	}
+	repos := map[string]bool{"pause": true, "etcd": true, "etcd-amd64": true, "kube-proxy": false, "xpause": false}
+	for repo, expected := range repos {
+		if job.Repos.Match(repo) != expected {
+			t.Errorf("repo %s should match: %v\n", repo, expected)
+		}
+	}
+	tags := map[string]bool{"3.0": true, "v1.4.0-rc1": false, "v1.4.0-rc": false}
+	for tag, expected := range tags {
+		if job.Tags.Match(tag) != expected {
+			t.Errorf("tag %s should match: %v\n", tag, expected)
+		}
+	}
+
+	etcdTags := []Tag{{Name: "2.2.5"}, {Name: "3.0.4"}, {Name: "3.0.14"}, {Name: "3.1.0"}, {Name: "latest"}}
+	if selected := job.TagFilter("etcd").Select(etcdTags); strings.Join(selected, ",") != "3.1.0,3.0.14" {
+		t.Errorf("etcd should select 3.1.0 and 3.0.14, got %v\n", selected)
+	}
+	if selected := job.TagFilter("pause").Select(etcdTags); len(selected) != len(etcdTags) {
+		t.Errorf("pause should select all tags, got %v\n", selected)
+	}
+
+	prune := c.Jobs[1].Prune
+	for tag, expected := range map[string]bool{"latest": true, "v1.2": true, "v2.0": false, "xlatest": false} {
+		if prune.Protected(tag) != expected {
+			t.Errorf("tag %s should be protected: %v\n", tag, expected)
+		}
+	}
+
+	if job := c.Jobs[1]; job.RetentionOf("nginx").KeepLatest != 10 || job.RetentionOf("busybox").KeepLatest != 5 {
+		t.Errorf("retention of repos is wrong: %#v\n", job.RepoRetention)
+	}
+
+	os.Setenv("TEST_TENX_PASSWORD", "secret")
+	defer os.Unsetenv("TEST_TENX_PASSWORD")
+	cred, err := c.Credential(job.Destination.Credentials)
+	if err != nil {
+		t.Fatalf("credential tenx should be found, error:%s\n", err)
+	}
+	if cred.Username != "oscarzhao" || cred.Password != "secret" {
+		t.Errorf("credential tenx resolved wrong: %#v\n", cred)
+	}
+
+	os.Setenv("TEST_HUB_TOKEN", "refresh-token")
+	defer os.Unsetenv("TEST_HUB_TOKEN")
+	if cred, err := c.Credential("hub"); err != nil || cred.IdentityToken != "refresh-token" || cred.Password != "" {
+		t.Errorf("credential hub resolved wrong: %#v, error:%v\n", cred, err)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	shouldFails := []string{
+		"jobs: []",
+		"jobs:\n- source: {namespace: library}\n  destination: {namespace: docker_library}",
+		"jobs:\n- name: a\n  source: {namespace: library}\n  destination: {namespace: docker_library, credentials: notfound}",
+		"jobs:\n- name: a\n  source: {namespace: library}\n  destination: {}",
+		"jobs:\n- name: a\n  source: {namespace: library, pageSize: -1}\n  destination: {namespace: docker_library}",
+		"jobs:\n- name: a\n  source: {namespace: library}\n  destination: {namespace: docker_library}\n  platforms: [linux]",
+		"jobs:\n- name: a\n  source: {namespace: library}\n  destination: {namespace: docker_library}\n  schedule: '0 25 * * *'",
+		"jobs:\n- name: a\n  source: {namespace: library}\n  destination: {namespace: docker_library}\n  prune: {enabled: true, protect: ['(']}",
+		"jobs:\n- name: a\n  source: {namespace: library}\n  destination: {namespace: docker_library}\n  prune: {maxDeletions: -1}",
+		"jobs:\n- name: a\n  source: {namespace: library}\n  destination: {namespace: docker_library}\n  retention: {keepWithin: 30days}",
+		"jobs:\n- name: a\n  source: {namespace: library}\n  destination: {namespace: docker_library, archive: 'zip:images.zip'}",
+		"jobs:\n- name: a\n  source: {namespace: library}\n  destination: {namespace: docker_library, archive: 'oci:images'}\n  prune: {enabled: true}",
+		"jobs:\n- name: a\n  source: {namespace: library, archive: 'oci:images'}\n  destination: {namespace: docker_library, archive: 'oci:export'}",
+		"jobs:\n- name: a\n  source: {namespace: library, archive: 'images.tar'}\n  destination: {namespace: docker_library}",
+		"jobs:\n- name: a\n  source: {namespace: library}\n  destination: {namespace: docker_library, baseline: 'oci:images'}",
+		"jobs:\n- name: a\n  source: {namespace: library}\n  destination: {namespace: docker_library}\n  repoRetention: {nginx: {keepLatest: -1}}",
+		"jobs:\n- name: a\n  source: {namespace: library}\n  destination: {namespace: docker_library}\n  tags: {include: ['(']}",
+		"jobs:\n- name: a\n  source: {namespace: library}\n  destination: {namespace: docker_library}\n- name: a\n  source: {namespace: library}\n  destination: {namespace: docker_library}",
+		"jobs:\n- name: a\n  unknown: field",
+		"jobs:\n- name: a\n  source: {namespace: library}\n  destination: {namespace: docker_library}\n  tags: {semver: '>=latest'}",
+		"jobs:\n- name: a\n  source: {namespace: library}\n  destination: {namespace: docker_library}\n  repoTags: {ubuntu: {updatedAfter: yesterday}}",
+		"concurrency: {copy: -1}\njobs:\n- name: a\n  source: {namespace: library}\n  destination: {namespace: docker_library}",
+	}
+	for _, config := range shouldFails {
+		if _, err := Parse([]byte(config)); err == nil {
+			t.Errorf("parse config should fail:\n%s\n", config)
+		}
+	}
+}
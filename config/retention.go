@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oscarzhao/image-sync/semver"
+)
+
+// RetentionPolicy keeps the destination tags of a repo which any of its rules
+// keeps, the others are deleted after each run. It is disabled if no rule is set.
+type RetentionPolicy struct {
+	// KeepLatest keeps the newest N tags by semver
+	KeepLatest int `yaml:"keepLatest"`
+	// KeepWithin keeps tags pushed within the duration, such as 30d or 72h,
+	// tags whose push time is unknown are kept
+	KeepWithin string `yaml:"keepWithin"`
+	// Keep are patterns of tags always kept, they must match the whole tag
+	Keep []string `yaml:"keep"`
+
+	keep       []*regexp.Regexp
+	keepWithin time.Duration
+}
+
+// Compile compiles the rules of p, it must be called before Expired
+func (p *RetentionPolicy) Compile() error {
+	if p.KeepLatest < 0 {
+		return fmt.Errorf("keepLatest must not be negative, got %d", p.KeepLatest)
+	}
+	p.keepWithin = 0
+	if p.KeepWithin != "" {
+		d, err := parseDays(p.KeepWithin)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid keepWithin %s, should be a positive duration such as 30d or 72h", p.KeepWithin)
+		}
+		p.keepWithin = d
+	}
+	var err error
+	p.keep, err = compilePatterns(p.Keep)
+	return err
+}
+
+// Enabled returns true if p has a rule
+func (p *RetentionPolicy) Enabled() bool {
+	return p.KeepLatest > 0 || p.KeepWithin != "" || len(p.Keep) > 0
+}
+
+// Expired returns names of tags which no rule of p keeps at now, in the order of tags
+func (p *RetentionPolicy) Expired(tags []Tag, now time.Time) []string {
+	kept := make(map[string]bool)
+	var versioned []Tag
+	versions := make(map[string]*semver.Version)
+	for _, tag := range tags {
+		for _, re := range p.keep {
+			if re.MatchString(tag.Name) {
+				kept[tag.Name] = true
+			}
+		}
+		if p.keepWithin > 0 && (tag.Updated.IsZero() || now.Sub(tag.Updated) <= p.keepWithin) {
+			kept[tag.Name] = true
+		}
+		if v, err := semver.Parse(tag.Name); err == nil {
+			versioned = append(versioned, tag)
+			versions[tag.Name] = v
+		}
+	}
+	sort.SliceStable(versioned, func(i, j int) bool {
+		return versions[versioned[i].Name].Compare(versions[versioned[j].Name]) > 0
+	})
+	for i := 0; i < p.KeepLatest && i < len(versioned); i++ {
+		kept[versioned[i].Name] = true
+	}
+
+	var expired []string
+	for _, tag := range tags {
+		if !kept[tag.Name] {
+			expired = append(expired, tag.Name)
+		}
+	}
+	return expired
+}
+
+// parseDays parses a duration, which may be a number of days such as 30d
+func parseDays(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetentionExpired(t *testing.T) {
+	now := time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)
+	tags := []Tag{
+		{Name: "latest", Updated: now.Add(-time.Hour)},
+		{Name: "1.7.3", Updated: now.AddDate(0, 0, -90)},
+		{Name: "1.8.0", Updated: now.AddDate(0, 0, -60)},
+		{Name: "1.9.2", Updated: now.AddDate(0, 0, -20)},
+		{Name: "2.0.0"},
+		{Name: "edge", Updated: now.AddDate(0, 0, -40)},
+	}
+
+	testCases := []struct {
+		policy   RetentionPolicy
+		expected []string
+	}{
+		{RetentionPolicy{KeepLatest: 2}, []string{"latest", "1.7.3", "1.8.0", "edge"}},
+		{RetentionPolicy{KeepWithin: "30d"}, []string{"1.7.3", "1.8.0", "edge"}},
+		{RetentionPolicy{KeepWithin: "1500h", Keep: []string{"edge"}}, []string{"1.7.3"}},
+		{RetentionPolicy{KeepLatest: 3, Keep: []string{"latest|edge"}}, []string{"1.7.3"}},
+		{RetentionPolicy{KeepLatest: 1, KeepWithin: "30d", Keep: []string{"latest"}}, []string{"1.7.3", "1.8.0", "edge"}},
+	}
+	for _, tc := range testCases {
+		if err := tc.policy.Compile(); err != nil {
+			t.Errorf("compile policy %#v should succeed, error:%s\n", tc.policy, err)
+			continue
+		}
+		if expired := tc.policy.Expired(tags, now); !reflect.DeepEqual(expired, tc.expected) {
+			t.Errorf("policy %#v should expire %v, got %v\n", tc.policy, tc.expected, expired)
+		}
+	}
+}
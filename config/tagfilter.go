@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/oscarzhao/image-sync/semver"
+)
+
+// Tag is a tag found in the source registry, Updated is zero if unknown
+type Tag struct {
+	Name    string
+	Updated time.Time
+}
+
+// TagFilter selects tags of a repo. Tags are first matched against patterns,
+// then against the semver constraint and the update time, and at last the
+// newest Latest tags by semver are kept.
+type TagFilter struct {
+	Filter `yaml:",inline"`
+	// Semver is a constraint like ">=1.8 <2.0", tags which are not semantic versions are dropped
+	Semver string `yaml:"semver"`
+	// Latest keeps the newest N tags by semver, tags which are not semantic versions are dropped
+	Latest int `yaml:"latest"`
+	// UpdatedAfter drops tags updated before the date (2006-01-02 or RFC3339),
+	// tags whose update time is unknown are kept
+	UpdatedAfter string `yaml:"updatedAfter"`
+
+	constraints  *semver.Constraints
+	updatedAfter time.Time
+}
+
+// Compile compiles the patterns and constraints of f, it must be called before Select
+func (f *TagFilter) Compile() error {
+	if err := f.Filter.Compile(); err != nil {
+		return err
+	}
+	f.constraints = nil
+	if f.Semver != "" {
+		c, err := semver.ParseConstraints(f.Semver)
+		if err != nil {
+			return err
+		}
+		f.constraints = c
+	}
+	if f.Latest < 0 {
+		return fmt.Errorf("latest must not be negative, got %d", f.Latest)
+	}
+	f.updatedAfter = time.Time{}
+	if f.UpdatedAfter != "" {
+		t, err := time.Parse(time.RFC3339, f.UpdatedAfter)
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", f.UpdatedAfter); err != nil {
+				return fmt.Errorf("invalid updatedAfter %s, should be 2006-01-02 or RFC3339", f.UpdatedAfter)
+			}
+		}
+		f.updatedAfter = t
+	}
+	return nil
+}
+
+// Select returns names of tags selected by f
+func (f *TagFilter) Select(tags []Tag) []string {
+	type versionedTag struct {
+		name    string
+		version *semver.Version
+	}
+	var selected []versionedTag
+	for _, tag := range tags {
+		if !f.Match(tag.Name) {
+			continue
+		}
+		if !f.updatedAfter.IsZero() && !tag.Updated.IsZero() && tag.Updated.Before(f.updatedAfter) {
+			continue
+		}
+		v, err := semver.Parse(tag.Name)
+		if err != nil && (f.constraints != nil || f.Latest > 0) {
+			continue
+		}
+		if f.constraints != nil && !f.constraints.Check(v) {
+			continue
+		}
+		selected = append(selected, versionedTag{name: tag.Name, version: v})
+	}
+
+	if f.Latest > 0 && len(selected) > f.Latest {
+		sort.SliceStable(selected, func(i, j int) bool {
+			return selected[i].version.Compare(selected[j].version) > 0
+		})
+		selected = selected[:f.Latest]
+	}
+
+	names := make([]string, 0, len(selected))
+	for _, tag := range selected {
+		names = append(names, tag.name)
+	}
+	return names
+}
+
+// Covers returns true if f may select tag, that is tag matches the patterns
+// and the semver constraint. The update time and Latest depend on other tags
+// and are not checked.
+func (f *TagFilter) Covers(tag string) bool {
+	if !f.Match(tag) {
+		return false
+	}
+	if f.constraints == nil && f.Latest == 0 {
+		return true
+	}
+	v, err := semver.Parse(tag)
+	if err != nil {
+		return false
+	}
+	return f.constraints == nil || f.constraints.Check(v)
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTagFilterSelect(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	tags := []Tag{
+		{Name: "latest", Updated: day("2016-08-01")},
+		{Name: "1.7.3", Updated: day("2016-01-01")},
+		{Name: "1.8.0", Updated: day("2016-03-01")},
+		{Name: "1.8.1-rc1", Updated: day("2016-04-01")},
+		{Name: "1.9.2", Updated: day("2016-06-01")},
+		{Name: "v1.10.0"},
+		{Name: "2.0.0", Updated: day("2016-07-01")},
+	}
+
+	testCases := []struct {
+		filter   TagFilter
+		expected []string
+	}{
+		{TagFilter{}, []string{"latest", "1.7.3", "1.8.0", "1.8.1-rc1", "1.9.2", "v1.10.0", "2.0.0"}},
+		{TagFilter{Semver: ">=1.8 <2.0"}, []string{"1.8.0", "1.8.1-rc1", "1.9.2", "v1.10.0"}},
+		{TagFilter{Filter: Filter{Exclude: []string{".*-rc\\d*"}}, Latest: 3}, []string{"2.0.0", "v1.10.0", "1.9.2"}},
+		{TagFilter{UpdatedAfter: "2016-05-01"}, []string{"latest", "1.9.2", "v1.10.0", "2.0.0"}},
+		{TagFilter{Filter: Filter{Include: []string{"latest", "v?1\\..*"}}, UpdatedAfter: "2016-02-15T00:00:00Z", Latest: 2}, []string{"v1.10.0", "1.9.2"}},
+	}
+	for _, tc := range testCases {
+		if err := tc.filter.Compile(); err != nil {
+			t.Errorf("compile filter %#v should succeed, error:%s\n", tc.filter, err)
+			continue
+		}
+		if selected := tc.filter.Select(tags); !reflect.DeepEqual(selected, tc.expected) {
+			t.Errorf("filter %#v should select %v, got %v\n", tc.filter, tc.expected, selected)
+		}
+	}
+}
+
+func TestTagFilterCovers(t *testing.T) {
+	testCases := []struct {
+		filter   TagFilter
+		tag      string
+		expected bool
+	}{
+		{TagFilter{}, "latest", true},
+		{TagFilter{Semver: ">=1.8 <2.0"}, "1.9.2", true},
+		{TagFilter{Semver: ">=1.8 <2.0"}, "2.0.0", false},
+		{TagFilter{Latest: 3}, "latest", false},
+		{TagFilter{Latest: 3}, "1.0.0", true},
+		{TagFilter{Filter: Filter{Exclude: []string{".*-rc\\d*"}}}, "1.8.1-rc1", false},
+		{TagFilter{UpdatedAfter: "2016-05-01"}, "1.7.3", true},
+	}
+	for _, tc := range testCases {
+		if err := tc.filter.Compile(); err != nil {
+			t.Errorf("compile filter %#v should succeed, error:%s\n", tc.filter, err)
+			continue
+		}
+		if covered := tc.filter.Covers(tc.tag); covered != tc.expected {
+			t.Errorf("filter %#v should cover %s: %v, got %v\n", tc.filter, tc.tag, tc.expected, covered)
+		}
+	}
+}
@@ -9,11 +9,38 @@ import (
 	"strings"
 
 	"github.com/golang/glog"
+
+	"github.com/oscarzhao/image-sync/retry"
 )
 
+// transientStderrRegexp matches docker errors worth retrying: network failures,
+// registries overloaded or unavailable, and rate limits
+var transientStderrRegexp = regexp.MustCompile(`(?i)(timeout|timed out|connection reset|connection refused|broken pipe|unexpected EOF|TLS handshake|bad gateway|service unavailable|gateway time-?out|toomanyrequests|too many requests|status (500|502|503|504))`)
+
+// runRetried runs the docker command created by newCmd until it succeeds, or fails
+// with an error which is not transient, see retry.Default
+func runRetried(op string, newCmd func() *exec.Cmd) (stdout, stderr string, err error) {
+	err = retry.Default.Do(op, func() error {
+		var stdoutB, stderrB bytes.Buffer
+		cmd := newCmd()
+		cmd.Stdout = &stdoutB
+		cmd.Stderr = &stderrB
+		runErr := cmd.Run()
+		stdout, stderr = stdoutB.String(), stderrB.String()
+		if runErr != nil && transientStderrRegexp.MatchString(stderr) {
+			return retry.Transient(runErr)
+		}
+		return runErr
+	})
+	if e, ok := err.(*retry.Error); ok {
+		// callers get the error of the command
+		err = e.Err
+	}
+	return
+}
+
 // PullImage pulls image from a registry server
 func PullImage(registry, repo, tag string) (stdout, stderr string, err error) {
-	var stdoutB, stderrB bytes.Buffer
 	var image string
 	registry = strings.Trim(registry, "/")
 	if registry == "" {
@@ -21,18 +48,13 @@ func PullImage(registry, repo, tag string) (stdout, stderr string, err error) {
 	} else {
 		image = fmt.Sprintf("%s/%s:%s", registry, repo, tag)
 	}
-	cmd := exec.Command("/usr/bin/docker", "pull", image)
-	cmd.Stdout = &stdoutB
-	cmd.Stderr = &stderrB
-	err = cmd.Run()
-	stdout = stdoutB.String()
-	stderr = stderrB.String()
-	return
+	return runRetried("docker pull "+image, func() *exec.Cmd {
+		return exec.Command("/usr/bin/docker", "pull", image)
+	})
 }
 
 // PushImage pushes image to a registry server
 func PushImage(registry, repo, tag string) (stdout, stderr string, err error) {
-	var stdoutB, stderrB bytes.Buffer
 	var image string
 	registry = strings.Trim(registry, "/")
 	if registry == "" {
@@ -40,13 +62,9 @@ func PushImage(registry, repo, tag string) (stdout, stderr string, err error) {
 	} else {
 		image = fmt.Sprintf("%s/%s:%s", registry, repo, tag)
 	}
-	cmd := exec.Command("/usr/bin/docker", "push", image)
-	cmd.Stdout = &stdoutB
-	cmd.Stderr = &stderrB
-	err = cmd.Run()
-	stdout = stdoutB.String()
-	stderr = stderrB.String()
-	return
+	return runRetried("docker push "+image, func() *exec.Cmd {
+		return exec.Command("/usr/bin/docker", "push", image)
+	})
 }
 
 // DeleteImage deletes image from a registry server
@@ -80,6 +98,20 @@ func MakeTag(from, to string) (stdout, stderr string, err error) {
 	return
 }
 
+// Login logs in to a registry server, the password is passed through stdin,
+// so that it never shows in the process list
+func Login(registry, username, password string) (stdout, stderr string, err error) {
+	args := []string{"login", "--username", username, "--password-stdin"}
+	if registry = strings.Trim(registry, "/"); registry != "" {
+		args = append(args, registry)
+	}
+	return runRetried("docker login "+registry, func() *exec.Cmd {
+		cmd := exec.Command("/usr/bin/docker", args...)
+		cmd.Stdin = strings.NewReader(password)
+		return cmd
+	})
+}
+
 // ListLocalImageAndTags lists all images and tags in local disk
 func ListLocalImageAndTags() (map[string][]string, error) {
 	var stdoutB, stderrB bytes.Buffer
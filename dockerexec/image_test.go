@@ -78,3 +78,23 @@ func TestMakeTag(t *testing.T) {
 		}
 	}
 }
+
+func TestTransientStderr(t *testing.T) {
+	testCases := []struct {
+		stderr    string
+		transient bool
+	}{
+		{"Error response from daemon: Get https://registry-1.docker.io/v2/: net/http: TLS handshake timeout", true},
+		{"received unexpected HTTP status: 502 Bad Gateway", true},
+		{"toomanyrequests: You have reached your pull rate limit", true},
+		{"read tcp 10.0.0.1:443: read: connection reset by peer", true},
+		{"Error response from daemon: manifest for not-found:not-found not found", false},
+		{"unauthorized: authentication required", false},
+		{"denied: requested access to the resource is denied", false},
+	}
+	for _, tc := range testCases {
+		if transientStderrRegexp.MatchString(tc.stderr) != tc.transient {
+			t.Errorf("stderr %q should be transient:%v\n", tc.stderr, tc.transient)
+		}
+	}
+}
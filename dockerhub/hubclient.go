@@ -1,25 +1,41 @@
 package dockerhub
 
 import (
-	"crypto/tls"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
+
+	"github.com/oscarzhao/image-sync/retry"
 )
 
 const (
 	DockerHubURL     = "https://hub.docker.com"
 	DockerHubVersion = "v2"
+
+	// maxPageSize is the largest page_size accepted by hub.docker.com
+	maxPageSize = 100
 )
 
-// DockerHubClient represents the data structure of registry servers
-type DockerHubClient struct{}
+// httpClient sends requests to hub.docker.com
+var httpClient = &http.Client{Timeout: 20 * time.Second}
+
+// DockerHubClient represents the data structure of registry servers,
+// requests are authenticated if Username and Password are set
+type DockerHubClient struct {
+	Username string
+	Password string
+
+	mu    sync.Mutex
+	token string
+}
 
 // DockerImage represents an image summery information
 type DockerImage struct {
@@ -40,6 +56,23 @@ type DockerImageList struct {
 	Results  []DockerImage `json:"results"`
 }
 
+// DockerRepository represents a repo of a namespace returned by hub.docker.com
+type DockerRepository struct {
+	User        string `json:"user"`
+	Name        string `json:"name"`
+	Namespace   string `json:"namespace"`
+	IsPrivate   bool   `json:"is_private"`
+	LastUpdated string `json:"last_updated"`
+}
+
+// DockerRepositoryList represents the repos of a namespace returned by hub.docker.com
+type DockerRepositoryList struct {
+	Previous string             `json:"previous"`
+	Next     string             `json:"next"`
+	Count    int                `json:"count"`
+	Results  []DockerRepository `json:"results"`
+}
+
 // DockerTag represents docker tag information returned by hub.docker.com
 type DockerTag struct {
 	Name        string      `json:"name"`
@@ -64,23 +97,118 @@ type DockerTagList struct {
 
 // SendGetRequest sends a request to certain url (basic auth)
 func SendGetRequest(url string) (bytes []byte, statusCode int, err error) {
-	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}, Timeout: 20 * time.Second}
+	return sendGetRequest(url, "")
+}
+
+// sendGetRequest sends a request to certain url, with the jwt token if it is not empty.
+// Network errors and retryable status codes are retried by retry.Default, statusCode
+// is 0 if no response is received.
+func sendGetRequest(url, token string) (bytes []byte, statusCode int, err error) {
 	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, 400, err
 	}
+	if token != "" {
+		request.Header.Set("Authorization", "JWT "+token)
+	}
 
-	resp, err := httpClient.Do(request)
+	err = retry.Default.Do("GET "+url, func() error {
+		APILimit.Wait()
+		resp, err := httpClient.Do(request)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		APILimit.Observe(resp)
+
+		statusCode = resp.StatusCode
+		if bytes, err = ioutil.ReadAll(resp.Body); err != nil {
+			return err
+		}
+		if retry.RetryableStatus(resp.StatusCode) {
+			return retry.StatusError(resp, fmt.Errorf("GET %s returns status %d", url, resp.StatusCode))
+		}
+		return nil
+	})
+	if err != nil && statusCode != 0 && retry.RetryableStatus(statusCode) {
+		// the response of the last attempt is returned to the caller
+		return bytes, statusCode, nil
+	}
+	return bytes, statusCode, err
+}
+
+// login gets a jwt token of hub.docker.com, an empty token is returned if c has no credential
+func (c *DockerHubClient) login() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" || c.Username == "" || c.Password == "" {
+		return c.token, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"username": c.Username, "password": c.Password})
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf("%s/%s/users/login/", DockerHubURL, DockerHubVersion)
+	var result struct {
+		Token string `json:"token"`
+	}
+	err = retry.Default.Do("login to "+DockerHubURL, func() error {
+		resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return retry.StatusError(resp, fmt.Errorf("login to %s as %s fails, status:%d", DockerHubURL, c.Username, resp.StatusCode))
+		}
+		return json.NewDecoder(resp.Body).Decode(&result)
+	})
 	if err != nil {
-		return nil, resp.StatusCode, err
+		return "", err
 	}
-	defer resp.Body.Close()
+	c.token = result.Token
+	return c.token, nil
+}
 
-	bytes, err = ioutil.ReadAll(resp.Body)
+// get sends a request to url, authenticated if c has a credential
+func (c *DockerHubClient) get(url string) ([]byte, int, error) {
+	token, err := c.login()
 	if err != nil {
-		return nil, resp.StatusCode, err
+		return nil, 0, err
+	}
+	return sendGetRequest(url, token)
+}
+
+// ListReposByUser returns all repos of a user or an organization, including
+// private repos if c is authenticated
+func (c *DockerHubClient) ListReposByUser(user string) ([]DockerRepository, error) {
+	var repos []DockerRepository
+	pageSize := maxPageSize
+	page := 1
+	for {
+		var repoList DockerRepositoryList
+		url := fmt.Sprintf("%s/%s/repositories/%s/?page=%d&page_size=%d", DockerHubURL, DockerHubVersion, user, page, pageSize)
+		bytes, statusCode, err := c.get(url)
+		if err != nil {
+			return nil, err
+		}
+		if statusCode == 404 {
+			break
+		}
+		if statusCode >= 400 {
+			return repos, fmt.Errorf("list repos of %s fails, status:%d, response:%s", user, statusCode, bytes)
+		}
+		if err := json.Unmarshal(bytes, &repoList); err != nil {
+			return nil, err
+		}
+		repos = append(repos, repoList.Results...)
+		if repoList.Next == "" {
+			break
+		}
+		page++
 	}
-	return bytes, resp.StatusCode, nil
+	return repos, nil
 }
 
 // SearchReposByUser returns a list of images in registry
@@ -94,7 +222,7 @@ func (c *DockerHubClient) SearchReposByUser(repoName string) ([]DockerImage, err
 	}
 	var images []DockerImage
 
-	pageSize := 20
+	pageSize := maxPageSize
 	page := 1
 	for {
 		var imageList DockerImageList
@@ -107,8 +235,7 @@ func (c *DockerHubClient) SearchReposByUser(repoName string) ([]DockerImage, err
 			break
 		}
 		if statusCode >= 400 {
-			glog.Errorf("url: %s, statusCode: %d, resp:%s\n", url, statusCode, bytes)
-			return images, err
+			return nil, fmt.Errorf("search repos of %s fails, status:%d, response:%s", repoName, statusCode, bytes)
 		}
 		err = json.Unmarshal(bytes, &imageList)
 		if err != nil {
@@ -146,11 +273,11 @@ func (c *DockerHubClient) QueryImageTags(repoName string) ([]DockerTag, error) {
 
 	var tags []DockerTag
 	var tagList DockerTagList
-	pageSize := 20
+	pageSize := maxPageSize
 	page := 1
 	for {
 		url := fmt.Sprintf("%s/%s/repositories/%s/tags/?page=%d&page_size=%d", DockerHubURL, DockerHubVersion, repoName, page, pageSize)
-		bytes, statusCode, err := SendGetRequest(url)
+		bytes, statusCode, err := c.get(url)
 		if err != nil {
 			glog.Errorf("fails to fetch tags, url:%s, error:%s\n", url, err)
 			return nil, err
@@ -159,7 +286,8 @@ func (c *DockerHubClient) QueryImageTags(repoName string) ([]DockerTag, error) {
 			break
 		}
 		if statusCode >= 400 {
-			return tags, err
+			// a partial list would make tags still upstream look gone
+			return nil, fmt.Errorf("list tags of %s fails, status:%d, response:%s", repoName, statusCode, bytes)
 		}
 		err = json.Unmarshal(bytes, &tagList)
 		if err != nil {
@@ -2,17 +2,27 @@ package dockerhub
 
 import (
 	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"testing"
+
+	"github.com/oscarzhao/image-sync/retry"
 )
 
 var (
 	c = &DockerHubClient{}
 )
 
-func init() {
+// TestMain parses flags once the testing flags are defined, parsing them in
+// init fails on -test.* flags and exits before any test runs
+func TestMain(m *testing.M) {
 	flag.Set("alsologtostderr", "true")
 	flag.Set("v", "7")
 	flag.Parse()
+	os.Exit(m.Run())
 }
 
 func TestSearchReposByUser(t *testing.T) {
@@ -38,3 +48,43 @@ func TestListTags(t *testing.T) {
 
 	t.Logf("list %s's images success, count: %v\n", repo, len(tags))
 }
+
+// hostTransport sends requests to hub.docker.com to the test server at url
+type hostTransport struct {
+	url *url.URL
+}
+
+func (t hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme, req.URL.Host = t.url.Scheme, t.url.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestQueryImageTagsFailedPage(t *testing.T) {
+	testCases := []struct {
+		name   string
+		status int
+	}{
+		{"retries exhausted", http.StatusServiceUnavailable},
+		{"forbidden", http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("page") == "1" {
+				fmt.Fprint(w, `{"next":"page2","results":[{"name":"latest"}]}`)
+				return
+			}
+			w.WriteHeader(tc.status)
+		}))
+		u, _ := url.Parse(server.URL)
+		client, policy := httpClient, retry.Default
+		httpClient = &http.Client{Transport: hostTransport{u}}
+		retry.Default.MaxAttempts = 1
+
+		tags, err := (&DockerHubClient{}).QueryImageTags("library/ubuntu")
+		httpClient, retry.Default = client, policy
+		server.Close()
+		if err == nil || tags != nil {
+			t.Errorf("%s: a failed page should fail the listing, got %v, error:%v\n", tc.name, tags, err)
+		}
+	}
+}
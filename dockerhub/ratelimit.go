@@ -0,0 +1,180 @@
+package dockerhub
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+var (
+	// APILimit tracks the budget of hub.docker.com api requests
+	APILimit = NewRateLimit("docker hub api")
+	// PullLimit tracks the budget of image pulls from registry-1.docker.io
+	PullLimit = NewRateLimit("docker hub pull")
+)
+
+// RateLimit tracks the request budget docker hub reports in RateLimit-* (or
+// X-RateLimit-*) headers and 429 responses. Requests slow down once the
+// budget runs low, and pause until the window resets once it is exhausted.
+// It is safe for concurrent use.
+type RateLimit struct {
+	Name string
+	// Reserve is the budget left for other clients sharing the account or the ip
+	Reserve int
+	// MaxWait caps a pause, requests are sent anyway after it
+	MaxWait time.Duration
+
+	mu        sync.Mutex
+	known     bool
+	limit     int
+	remaining int
+	reset     time.Time
+	waits     int
+	waited    time.Duration
+
+	// sleep pauses requests, replaced in tests
+	sleep func(time.Duration)
+}
+
+// NewRateLimit creates a rate limit with an unknown budget
+func NewRateLimit(name string) *RateLimit {
+	return &RateLimit{Name: name, Reserve: 1, MaxWait: 30 * time.Minute}
+}
+
+// Wait blocks until a request may be sent within the budget, and counts the request
+func (l *RateLimit) Wait() {
+	l.mu.Lock()
+	if !l.known {
+		l.mu.Unlock()
+		return
+	}
+	until := time.Until(l.reset)
+	if until <= 0 {
+		// the window has reset, the next response tells the new budget
+		l.known = false
+		l.mu.Unlock()
+		return
+	}
+
+	var d time.Duration
+	left := l.remaining - l.Reserve
+	switch {
+	case left <= 0:
+		d = until
+	case left <= l.limit/10:
+		// spread the rest of the budget over the window
+		d = until / time.Duration(left+1)
+	}
+	if d > l.MaxWait {
+		d = l.MaxWait
+	}
+	l.remaining--
+	if d <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	l.waits++
+	l.waited += d
+	remaining, limit := l.remaining+1, l.limit
+	sleep := l.sleep
+	l.mu.Unlock()
+
+	glog.Warningf("%s rate limit runs low (%d of %d left, reserve %d), wait %s\n", l.Name, remaining, limit, l.Reserve, d)
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	sleep(d)
+}
+
+// Observe updates the budget from the headers of resp
+func (l *RateLimit) Observe(resp *http.Response) {
+	h := resp.Header
+	limit, window, okLimit := parseRateLimit(firstHeader(h, "RateLimit-Limit", "X-RateLimit-Limit"))
+	remaining, remainingWindow, okRemaining := parseRateLimit(firstHeader(h, "RateLimit-Remaining", "X-RateLimit-Remaining"))
+	if window == 0 {
+		window = remainingWindow
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		remaining, okRemaining = 0, true
+	}
+	if !okRemaining {
+		return
+	}
+
+	reset := time.Now().Add(window)
+	if v := firstHeader(h, "X-RateLimit-Reset"); v != "" {
+		if unix, err := strconv.ParseInt(v, 10, 64); err == nil {
+			reset = time.Unix(unix, 0)
+		}
+	} else if v := firstHeader(h, "RateLimit-Reset", "Retry-After"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			reset = time.Now().Add(time.Duration(seconds) * time.Second)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if okLimit {
+		l.limit = limit
+	}
+	l.known, l.remaining, l.reset = true, remaining, reset
+	glog.V(6).Infof("%s rate limit, remaining:%d, limit:%d, reset:%s\n", l.Name, remaining, l.limit, reset)
+}
+
+// Waits returns the number and the total duration of pauses made
+func (l *RateLimit) Waits() (int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waits, l.waited
+}
+
+// parseRateLimit parses "100;w=21600" into the count and the window
+func parseRateLimit(v string) (n int, window time.Duration, ok bool) {
+	if v == "" {
+		return 0, 0, false
+	}
+	parts := strings.Split(v, ";")
+	n, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, false
+	}
+	for _, p := range parts[1:] {
+		if p = strings.TrimSpace(p); strings.HasPrefix(p, "w=") {
+			if seconds, err := strconv.Atoi(p[2:]); err == nil {
+				window = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return n, window, true
+}
+
+func firstHeader(h http.Header, names ...string) string {
+	for _, name := range names {
+		if v := h.Get(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// RateLimitTransport waits for the budget of Limit before sending image pulls
+// (manifest GET requests), and observes the budget in all responses
+type RateLimitTransport struct {
+	Transport http.RoundTripper
+	Limit     *RateLimit
+}
+
+func (t *RateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == "GET" && strings.Contains(req.URL.Path, "/manifests/") {
+		t.Limit.Wait()
+	}
+	resp, err := t.Transport.RoundTrip(req)
+	if err == nil {
+		t.Limit.Observe(resp)
+	}
+	return resp, err
+}
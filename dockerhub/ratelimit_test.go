@@ -0,0 +1,74 @@
+package dockerhub
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	testCases := []struct {
+		name    string
+		header  http.Header
+		status  int
+		waits   int
+		minWait time.Duration
+	}{
+		{"unknown budget", http.Header{}, http.StatusOK, 0, 0},
+		{"plenty", http.Header{"Ratelimit-Limit": {"100;w=21600"}, "Ratelimit-Remaining": {"76;w=21600"}}, http.StatusOK, 0, 0},
+		{"runs low", http.Header{"Ratelimit-Limit": {"100;w=21600"}, "Ratelimit-Remaining": {"5;w=21600"}}, http.StatusOK, 1, time.Hour},
+		{"exhausted", http.Header{"Ratelimit-Limit": {"100;w=21600"}, "Ratelimit-Remaining": {"1;w=21600"}}, http.StatusOK, 1, 5 * time.Hour},
+		{"api reset", http.Header{"X-Ratelimit-Limit": {"180"}, "X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset": {strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)}}, http.StatusOK, 1, 50 * time.Second},
+		{"too many requests", http.Header{"Retry-After": {"60"}}, http.StatusTooManyRequests, 1, 50 * time.Second},
+	}
+	for _, tc := range testCases {
+		var slept []time.Duration
+		l := NewRateLimit(tc.name)
+		l.MaxWait = 24 * time.Hour
+		l.sleep = func(d time.Duration) { slept = append(slept, d) }
+		l.Observe(&http.Response{StatusCode: tc.status, Header: tc.header})
+		l.Wait()
+
+		waits, waited := l.Waits()
+		if waits != tc.waits || len(slept) != tc.waits {
+			t.Errorf("%s: expected %d waits, got %d, slept %v\n", tc.name, tc.waits, waits, slept)
+			continue
+		}
+		if waited < tc.minWait {
+			t.Errorf("%s: expected a wait of at least %s, got %s\n", tc.name, tc.minWait, waited)
+		}
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	testCases := []struct {
+		value  string
+		n      int
+		window time.Duration
+		ok     bool
+	}{
+		{"100;w=21600", 100, 6 * time.Hour, true},
+		{"180", 180, 0, true},
+		{"", 0, 0, false},
+		{"many", 0, 0, false},
+	}
+	for _, tc := range testCases {
+		n, window, ok := parseRateLimit(tc.value)
+		if n != tc.n || window != tc.window || ok != tc.ok {
+			t.Errorf("rate limit %q should be %d, %s (ok:%v), got %d, %s (ok:%v)\n", tc.value, tc.n, tc.window, tc.ok, n, window, ok)
+		}
+	}
+}
+
+func TestRateLimitMaxWait(t *testing.T) {
+	var slept time.Duration
+	l := NewRateLimit("max wait")
+	l.MaxWait = time.Minute
+	l.sleep = func(d time.Duration) { slept += d }
+	l.Observe(&http.Response{StatusCode: http.StatusOK, Header: http.Header{"Ratelimit-Remaining": {"0;w=21600"}}})
+	l.Wait()
+	if slept != time.Minute {
+		t.Errorf("wait should be capped at %s, got %s\n", l.MaxWait, slept)
+	}
+}
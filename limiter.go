@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// registryLimiter limits concurrent operations against each registry host,
+// it is shared by all stages and jobs
+type registryLimiter struct {
+	limit int
+
+	mu   sync.Mutex
+	sems map[string]chan struct{}
+}
+
+// newRegistryLimiter creates a limiter, limit <= 0 means unlimited
+func newRegistryLimiter(limit int) *registryLimiter {
+	return &registryLimiter{limit: limit, sems: make(map[string]chan struct{})}
+}
+
+func (l *registryLimiter) sem(host string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sem, ok := l.sems[host]
+	if !ok {
+		sem = make(chan struct{}, l.limit)
+		l.sems[host] = sem
+	}
+	return sem
+}
+
+// acquire blocks until an operation against all hosts is allowed, and returns
+// the function releasing them. Hosts are acquired in order, so that
+// operations acquiring the same hosts never deadlock.
+func (l *registryLimiter) acquire(hosts ...string) (release func()) {
+	if l == nil || l.limit <= 0 {
+		return func() {}
+	}
+	hosts = uniqueHosts(hosts)
+	for _, host := range hosts {
+		l.sem(host) <- struct{}{}
+	}
+	return func() {
+		for _, host := range hosts {
+			<-l.sem(host)
+		}
+	}
+}
+
+func uniqueHosts(hosts []string) []string {
+	set := make(map[string]bool)
+	var res []string
+	for _, host := range hosts {
+		if host == "" {
+			host = "index.docker.io"
+		}
+		if !set[host] {
+			set[host] = true
+			res = append(res, host)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegistryLimiter(t *testing.T) {
+	l := newRegistryLimiter(2)
+	var running, maxRunning int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// operations acquire the same hosts in different order
+			hosts := []string{"gcr.io", ""}
+			if i%2 == 0 {
+				hosts = []string{"index.docker.io", "gcr.io"}
+			}
+			release := l.acquire(hosts...)
+			defer release()
+
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}(i)
+	}
+	wg.Wait()
+
+	if maxRunning > 2 {
+		t.Errorf("at most 2 operations should run concurrently, got %d\n", maxRunning)
+	}
+}
+
+func TestRunStage(t *testing.T) {
+	tasks := make(chan *task)
+	go func() {
+		for i := 0; i < 100; i++ {
+			tasks <- &task{src: Image{repo: "busybox", tag: string(rune('a' + i%26))}}
+		}
+		close(tasks)
+	}()
+
+	var processed int32
+	out := runStage(4, tasks, func(t *task) bool {
+		atomic.AddInt32(&processed, 1)
+		return t.src.tag != "a"
+	})
+	count := 0
+	for range out {
+		count++
+	}
+	if processed != 100 {
+		t.Errorf("all images should be processed, got %d\n", processed)
+	}
+	if count != 96 {
+		t.Errorf("images filtered out should not be sent, got %d\n", count)
+	}
+}
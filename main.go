@@ -1,30 +1,117 @@
 package main
 
 import (
-	// "errors"
+	"context"
+	"errors"
 	"flag"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/golang/glog"
 
-	"github.com/oscarzhao/image-sync/dockerexec"
+	"github.com/oscarzhao/image-sync/config"
+	"github.com/oscarzhao/image-sync/dockerhub"
+	"github.com/oscarzhao/image-sync/metrics"
+	"github.com/oscarzhao/image-sync/plan"
 	"github.com/oscarzhao/image-sync/registry"
+	"github.com/oscarzhao/image-sync/report"
+	"github.com/oscarzhao/image-sync/retry"
+	"github.com/oscarzhao/image-sync/state"
 )
 
 var (
-	srcClient *registry.Client
-	dstClient *registry.Client
-
 	// registry configs
 	srcRegistry        string
 	srcRegistryVersion string
-	srcRepoPassword    string
 	dstRegistry        string
 	dstRegistryVersion string
-	dstRepoPassword    string
+
+	// credentials, default to environment variables, or ~/.docker/config.json if all empty
+	srcUsername      string
+	srcRepoPassword  string
+	srcIdentityToken string
+	dstUsername      string
+	dstRepoPassword  string
+	dstIdentityToken string
 
 	srcRepoOwner string
 	dstRepoOwner string
+
+	// srcArchive and dstArchive are the archives images are imported from and
+	// exported to instead of the registries, such as oci:/mnt/images
+	srcArchive string
+	dstArchive string
+	// baseline makes dstArchive a delta of a previous archive
+	baseline string
+
+	// configFile declares sync jobs, flags above are ignored if it is set
+	configFile string
+
+	// copyMode is either "registry" or "docker"
+	copyMode string
+	// skip images already synchronized to the destination registry
+	skipSynced bool
+	// concurrency holds the number of workers of pipeline stages
+	concurrency config.Concurrency
+	// tagFilter selects tags to synchronize when no config file is given
+	tagFilter  config.TagFilter
+	tagInclude string
+	tagExclude string
+	// platforms selects images of multi-arch tags, comma separated
+	platforms string
+	// pageSize is the hint of entries per page when listing repos and tags of registry v2 servers
+	pageSize int
+	// chunkSize is the size of blob upload requests in MB
+	chunkSize int
+	// uploadSessionsFile keeps unfinished blob uploads, so that the next run resumes them
+	uploadSessionsFile string
+	uploadSessions     registry.UploadSessions
+
+	// hubRateLimitMaxWait and hubRateLimitReserve tune the docker hub rate limits
+	hubRateLimitMaxWait time.Duration
+	hubRateLimitReserve int
+
+	// stateFile keeps the progress of the run, with resume set the images
+	// finished by the previous run are not synchronized again
+	stateFile string
+	resume    bool
+	syncState *state.State
+
+	// reportFile and junitReportFile are paths the outcome of each image is
+	// written to, in serve mode they hold the last run of any job
+	reportFile      string
+	junitReportFile string
+	reportMu        sync.Mutex
+
+	// dryRun prints the plan of the run instead of synchronizing, and writes
+	// it in json to planFile if it is set ("-" for stdout)
+	dryRun   bool
+	planFile string
+
+	// prune deletes destination tags gone upstream for the job built from
+	// flags, jobs delete pruneMaxDeletions tags per run at most unless their
+	// config sets it
+	prune             bool
+	pruneMaxDeletions int
+	pruneProtect      string
+
+	// defaultSchedule is the schedule of jobs without one in serve mode, and
+	// running jobs are waited for shutdownTimeout at most once stopped
+	defaultSchedule string
+	shutdownTimeout time.Duration
+	// listenAddress serves metrics, and webhooks in serve mode, events of the
+	// same image within webhookWindow are merged
+	listenAddress string
+	webhookSecret string
+	webhookWindow time.Duration
+	// stop is closed once image-sync is asked to stop
+	stop = make(chan struct{})
 )
 
 type Image struct {
@@ -44,136 +131,364 @@ func init() {
 	flag.Set("alsologtostderr", "true")
 	flag.StringVar(&srcRegistry, "src-registry", "", "use docker hub as default, alternatives: gcr.io")
 	flag.StringVar(&srcRegistryVersion, "src-registry-version", "v2", "the registry api version (v1 or v2)")
-	flag.StringVar(&srcRepoPassword, "src-repo-password", "xxx", "repo password, currently not useful")
+	flag.StringVar(&srcUsername, "src-username", os.Getenv("SRC_REGISTRY_USERNAME"), "username of the source registry, default $SRC_REGISTRY_USERNAME")
+	flag.StringVar(&srcRepoPassword, "src-repo-password", os.Getenv("SRC_REGISTRY_PASSWORD"), "password of the source registry, default $SRC_REGISTRY_PASSWORD")
+	flag.StringVar(&srcIdentityToken, "src-identity-token", os.Getenv("SRC_REGISTRY_IDENTITY_TOKEN"), "identity token of the source registry, used instead of the password, default $SRC_REGISTRY_IDENTITY_TOKEN")
 
 	flag.StringVar(&dstRegistry, "dst-registry", "index.tenxcloud.com", "the registry to synchronize to")
 	flag.StringVar(&dstRegistryVersion, "dst-registry-version", "v2", "the registry api version (often v2)")
-	flag.StringVar(&dstRepoPassword, "dst-repo-password", "xxx", "repo password, use to list repos at dst registry")
+	flag.StringVar(&dstUsername, "dst-username", os.Getenv("DST_REGISTRY_USERNAME"), "username of the destination registry, default $DST_REGISTRY_USERNAME, or the destination repo owner if a password is given")
+	flag.StringVar(&dstRepoPassword, "dst-repo-password", os.Getenv("DST_REGISTRY_PASSWORD"), "password of the destination registry, default $DST_REGISTRY_PASSWORD")
+	flag.StringVar(&dstIdentityToken, "dst-identity-token", os.Getenv("DST_REGISTRY_IDENTITY_TOKEN"), "identity token of the destination registry, used instead of the password, default $DST_REGISTRY_IDENTITY_TOKEN")
 
 	flag.StringVar(&srcRepoOwner, "repo-owner", "", "repo owner, the user images are under for the source registry")
 	flag.StringVar(&dstRepoOwner, "dst-repo-owner", "docker_library", "repo owner, the user images are under for the target registry")
-	flag.Parse()
-
-	if srcRepoOwner == "" || srcRepoOwner == "library" {
-		srcRepoOwner = "library" // empty is library
-		dstRepoOwner = "docker_library"
-	}
-
-	srcClient, _ = registry.NewClient("https", srcRegistry, srcRegistryVersion, srcRepoOwner, srcRepoPassword)
-	dstClient, _ = registry.NewClient("https", dstRegistry, dstRegistryVersion, dstRepoOwner, dstRepoPassword)
+	flag.StringVar(&srcArchive, "src-archive", "", "import images from an archive instead of the source registry: oci:<dir> (an OCI image layout) or docker-archive:<file> (a tar of an OCI image layout or written by docker save)")
+	flag.StringVar(&dstArchive, "dst-archive", "", "export images to an archive instead of the destination registry: oci:<dir> (an OCI image layout) or docker-archive:<file> (a tar docker load reads)")
+	flag.StringVar(&baseline, "baseline", "", "make --dst-archive a delta of a previous archive (oci:<dir> or docker-archive:<file>) or of a bundle.json, leaving out the blobs it has")
+	flag.StringVar(&configFile, "config", "", "a yaml file declaring sync jobs, registry and repo flags are ignored if it is set")
+	flag.StringVar(&tagInclude, "tag-include", "", "regular expression, only matching tags are synchronized")
+	flag.StringVar(&tagExclude, "tag-exclude", "", "regular expression, matching tags are not synchronized")
+	flag.StringVar(&tagFilter.Semver, "tag-semver", "", "semver constraint of tags to synchronize, e.g. \">=1.8 <2.0\"")
+	flag.IntVar(&tagFilter.Latest, "tag-latest", 0, "only synchronize the newest N tags by semver, 0 means all")
+	flag.StringVar(&tagFilter.UpdatedAfter, "tag-updated-after", "", "only synchronize tags updated after the date (2006-01-02), docker hub only")
+	flag.BoolVar(&skipSynced, "skip", false, "skip tags which exist at the destination registry with the same content")
+	flag.StringVar(&copyMode, "copy-mode", "registry", "how images are copied: registry (stream layers through registry v2 api) or docker (pull, tag and push with docker daemon)")
+	flag.IntVar(&concurrency.Copy, "copy-workers", 1, "number of images copied concurrently in registry copy mode")
+	flag.IntVar(&concurrency.Check, "check-workers", 1, "number of images compared with the destination concurrently, used with --skip")
+	flag.IntVar(&concurrency.Pull, "pull-workers", 1, "number of images pulled concurrently in docker copy mode")
+	flag.IntVar(&concurrency.Tag, "tag-workers", 1, "number of images tagged concurrently in docker copy mode")
+	flag.IntVar(&concurrency.Push, "push-workers", 1, "number of images pushed concurrently in docker copy mode")
+	flag.IntVar(&concurrency.PerRegistry, "max-per-registry", 0, "max concurrent operations against each registry host, 0 means unlimited")
+	flag.StringVar(&platforms, "platforms", "", "comma separated platforms of multi-arch images to copy, such as linux/amd64,linux/arm64, all if empty")
+	flag.IntVar(&pageSize, "page-size", 0, "entries per page when listing repos and tags of registry v2 servers, 0 lets the registry decide")
+	flag.IntVar(&chunkSize, "chunk-size", registry.DefaultChunkSize>>20, "size of blob upload requests in MB in registry copy mode")
+	flag.StringVar(&uploadSessionsFile, "upload-sessions", "", "keep unfinished blob uploads in the file, so that the next run resumes them")
+	flag.IntVar(&retry.Default.MaxAttempts, "retry-attempts", retry.Default.MaxAttempts, "attempts of registry requests and docker commands failed with transient errors, 1 disables retries")
+	flag.DurationVar(&retry.Default.MaxDelay, "retry-max-delay", retry.Default.MaxDelay, "max delay between two attempts, the delay doubles after each failure")
+	flag.DurationVar(&hubRateLimitMaxWait, "hub-rate-limit-max-wait", 30*time.Minute, "max pause once the docker hub rate limit is exhausted, requests are sent anyway after it")
+	flag.IntVar(&hubRateLimitReserve, "hub-rate-limit-reserve", 1, "docker hub requests and pulls left for other clients sharing the account or the ip")
+	flag.StringVar(&stateFile, "state", "", "keep the progress of each image in the file, and unfinished blob uploads unless --upload-sessions is set")
+	flag.BoolVar(&resume, "resume", false, "resume the run recorded in --state, only failed or unfinished images are synchronized")
+	flag.StringVar(&reportFile, "report", "", "write the outcome of each image to the file in json")
+	flag.StringVar(&junitReportFile, "junit-report", "", "write the outcome of each image to the file in junit xml")
+	flag.BoolVar(&dryRun, "dry-run", false, "print what would be created, updated, skipped or pruned, and the bytes to transfer, without synchronizing")
+	flag.StringVar(&planFile, "plan", "", "write the plan of --dry-run to the file in json, - for stdout instead of the text")
+	flag.BoolVar(&prune, "prune", false, "delete destination tags which are gone upstream, tags the tag filters drop are kept")
+	flag.IntVar(&pruneMaxDeletions, "prune-max-deletions", 20, "max tags pruned per run of a job, nothing is pruned if more tags are gone upstream")
+	flag.StringVar(&pruneProtect, "prune-protect", "", "regular expression, matching tags are never pruned")
+	flag.StringVar(&defaultSchedule, "schedule", "", "cron expression of the runs of jobs without a schedule in serve mode, such as \"0 */6 * * *\" or @daily")
+	flag.StringVar(&listenAddress, "listen", "", "address of the http server of /metrics (and /webhook in serve mode), such as :8080, disabled if empty")
+	flag.StringVar(&webhookSecret, "webhook-secret", os.Getenv("WEBHOOK_SECRET"), "secret of webhooks, passed in the token query parameter or as a bearer token, default $WEBHOOK_SECRET")
+	flag.DurationVar(&webhookWindow, "webhook-window", 10*time.Second, "webhook events of the same image within the window are merged into one sync")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 10*time.Minute, "max time to wait for running jobs once serve mode is stopped")
 }
 
 func main() {
-	srcRepo2Tags := make(map[string][]string)
-	listTagFailedRepos := make([]string, 0, 4)
+	// image-sync serve [flags] runs jobs on their schedules until it is stopped
+	args := os.Args[1:]
+	serveMode := len(args) > 0 && args[0] == "serve"
+	if serveMode {
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
+	defer glog.Flush()
 
-	repoList, err := srcClient.ListRepositories(srcRepoOwner)
+	cfg, err := loadConfig()
 	if err != nil {
-		glog.Errorf("list repos (%s) failed, error: %s\n", srcRepoOwner, err)
-		return
+		glog.Errorf("invalid sync config, error:%s\n", err)
+		glog.Flush()
+		os.Exit(1)
+	}
+
+	if dryRun && serveMode {
+		glog.Errorf("--dry-run cannot be used with serve\n")
+		glog.Flush()
+		os.Exit(1)
+	}
+	if err := checkArchives(cfg, serveMode); err != nil {
+		glog.Errorf("invalid sync config, error:%s\n", err)
+		glog.Flush()
+		os.Exit(1)
+	}
+	if syncState, err = loadState(); err != nil {
+		glog.Errorf("load state fails, error:%s\n", err)
+		glog.Flush()
+		os.Exit(1)
+	}
+	if syncState != nil && uploadSessionsFile == "" {
+		uploadSessions = syncState
+	} else if uploadSessions, err = registry.NewUploadSessions(uploadSessionsFile); err != nil {
+		glog.Errorf("load upload sessions fails, error:%s\n", err)
+		glog.Flush()
+		os.Exit(1)
 	}
 
-	glog.V(4).Infof("repos got: %s\n", strings.Join(repoList, "\n"))
+	for _, limit := range []*dockerhub.RateLimit{dockerhub.APILimit, dockerhub.PullLimit} {
+		limit.MaxWait, limit.Reserve = hubRateLimitMaxWait, hubRateLimitReserve
+	}
 
-	// fetch all tags of all repos under srcRepoOwner
-	for _, repoName := range repoList {
-		tags, err := srcClient.ListTags(repoName)
+	cfg.Concurrency.SetDefaults(concurrency)
+	for i := range cfg.Jobs {
+		if cfg.Jobs[i].Prune.MaxDeletions == 0 {
+			cfg.Jobs[i].Prune.MaxDeletions = pruneMaxDeletions
+		}
+	}
+	limiter := newRegistryLimiter(cfg.Concurrency.PerRegistry)
+	stopOnSignal()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default)
+	server, err := listen(mux)
+	if err != nil {
+		glog.Errorf("listen on %s fails, error:%s\n", listenAddress, err)
+		glog.Flush()
+		os.Exit(1)
+	}
+	if serveMode {
+		d, err := newDaemon(cfg, limiter, mux)
 		if err != nil {
-			listTagFailedRepos = append(listTagFailedRepos, repoName)
-			glog.Errorf("list tag of repo (%s/%s) fails, error:%s\n", srcRegistry, repoName, err)
-			continue
+			glog.Errorf("serve fails, error:%s\n", err)
+			glog.Flush()
+			os.Exit(1)
 		}
-		srcRepo2Tags[repoName] = tags
+		d.run()
+		shutdown(server)
+		saveState()
+		glog.Infof("image-sync stopped\n")
+		return
 	}
 
-	glog.V(4).Infof("images found in source registry: %#v\n", srcRepo2Tags)
-	images2pull := listImagesToPull(srcRepo2Tags)
-	imagePulled := pullImages(images2pull)
-	images2push := makeTag(imagePulled, dstRegistry)
-	imagePushed := pushImages(images2push)
-
-	for image := range imagePushed {
-		if _, stderr, err := dockerexec.DeleteImage(image.registry, image.repo, image.tag); err != nil {
-			glog.Errorf("image %s pushed, but delete fails, stderror:%s, error:%s\n", image, stderr, err)
+	r := report.New()
+	p := plan.New()
+	for _, job := range cfg.Jobs {
+		if stopped() {
+			break
+		}
+		s, err := newSyncer(cfg, job, limiter, r)
+		if err != nil {
+			glog.Errorf("job %s, create registry clients fails, error:%s\n", job.Name, err)
+			r.Add(report.Entry{Job: job.Name, Status: report.StatusListFailed, Source: job.Source.Namespace, Error: err.Error()})
+			countImage(job, report.StatusListFailed, 0)
+			continue
+		}
+		glog.V(2).Infof("job %s starts\n", job.Name)
+		if dryRun {
+			s.planImages(p)
 		} else {
-			glog.V(2).Infof("image %s pushed and deleted\n", image)
+			s.run()
+		}
+	}
+	shutdown(server)
+	if dryRun {
+		writePlan(p)
+		if r.Failed() || stopped() {
+			glog.Flush()
+			os.Exit(1)
 		}
+		return
+	}
+
+	for _, limit := range []*dockerhub.RateLimit{dockerhub.APILimit, dockerhub.PullLimit} {
+		waits, waited := limit.Waits()
+		r.AddRateLimitWaits(limit.Name, waits, waited)
 	}
-	if len(listTagFailedRepos) > 0 {
-		glog.Errorf("the following repos, list tag operation fails:\n%s\n", strings.Join(listTagFailedRepos, ", "))
+	archivesWritten := closeArchives()
+	r.Finish()
+	glog.Infof("sync finished, summary: %v\n", r.Summary)
+	for service, w := range r.RateLimits {
+		glog.Infof("%s rate limit, %d waits, %.0fs in total\n", service, w.Waits, w.WaitSeconds)
+	}
+	saveState()
+	writeReports(r)
+	if stopped() {
+		glog.Errorf("sync is interrupted, images not synchronized yet are left to the next run\n")
+	}
+	if r.Failed() || stopped() || !archivesWritten {
+		glog.Flush()
+		os.Exit(1)
 	}
 }
 
-func listImagesToPull(repo2tags map[string][]string) <-chan Image {
-	images2pull := make(chan Image)
+// listen serves handler on listenAddress, nil is returned if it is empty
+func listen(handler http.Handler) (*http.Server, error) {
+	if listenAddress == "" {
+		return nil, nil
+	}
+	ln, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: handler}
 	go func() {
-		for repo, tags := range repo2tags {
-			for _, tag := range tags {
-				images2pull <- Image{registry: srcRegistry, repo: repo, tag: tag}
-			}
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			glog.Errorf("serve http on %s fails, error:%s\n", listenAddress, err)
 		}
-		close(images2pull)
 	}()
-	return images2pull
+	glog.Infof("serve metrics on %s/metrics\n", ln.Addr())
+	return server, nil
 }
 
-func pullImages(images <-chan Image) <-chan Image {
-	success := make(chan Image)
+// shutdown stops server, requests being served are waited for 5 seconds at most
+func shutdown(server *http.Server) {
+	if server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+}
+
+// stopOnSignal closes stop at the first SIGINT or SIGTERM, so that no more
+// images are synchronized and those being synchronized are finished. A second
+// signal exits at once.
+func stopOnSignal() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
-		for image := range images {
-			if _, stderr, err := dockerexec.PullImage(image.registry, image.repo, image.tag); err != nil {
-				glog.Errorf("dockerexec.PullImage (%v) failed, stderr:%s, err:%s\n", image, stderr, err)
-			} else {
-				success <- image
-			}
-		}
-		close(success)
+		sig := <-signals
+		glog.Warningf("got %s, finish images being synchronized and exit, send it again to exit at once\n", sig)
+		close(stop)
+		sig = <-signals
+		glog.Errorf("got %s again, exit\n", sig)
+		glog.Flush()
+		os.Exit(1)
 	}()
-	return success
 }
 
-func pushImages(images <-chan Image) <-chan Image {
-	success := make(chan Image)
-	go func() {
-		for image := range images {
-			if _, stderr, err := dockerexec.PushImage(image.registry, image.repo, image.tag); err != nil {
-				glog.Errorf("dockerexec.PushImage %v failed, stderr:%s, err:%s, mark and delete it\n", image, stderr, err)
-				go func(registry, repo, tag string) {
-					if _, stderr, err := dockerexec.DeleteImage(registry, repo, tag); err != nil {
-						glog.Errorf("delete image %s/%s:%s fails, stderror:%s, error:%s\n", registry, repo, tag, stderr, err)
-					}
-				}(image.registry, image.repo, image.tag)
-			} else {
-				success <- image
-			}
+// stopped returns true once image-sync is asked to stop
+func stopped() bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// saveState writes the state file if it is set
+func saveState() {
+	if syncState == nil {
+		return
+	}
+	if err := syncState.Save(); err != nil {
+		glog.Errorf("save state to %s fails, error:%s\n", stateFile, err)
+	}
+}
+
+// writeReports writes r to the report files which are set
+func writeReports(r *report.Report) {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	if reportFile != "" {
+		if err := report.WriteFile(reportFile, r.WriteJSON); err != nil {
+			glog.Errorf("write report to %s fails, error:%s\n", reportFile, err)
 		}
-		close(success)
-	}()
-	return success
+	}
+	if junitReportFile != "" {
+		if err := report.WriteFile(junitReportFile, r.WriteJUnit); err != nil {
+			glog.Errorf("write junit report to %s fails, error:%s\n", junitReportFile, err)
+		}
+	}
 }
 
-func makeTag(images <-chan Image, dstRegistry string) <-chan Image {
-	success := make(chan Image)
-	go func() {
-		for image := range images {
-			// check if create tag success
-			dstRepo := image.repo
-			if image.registry == "" {
-				dstRepo = dstRepoOwner + "/" + dstRepo
-			}
-			dstImg := Image{dstRegistry, dstRepo, image.tag}
-			if _, stderr, err := dockerexec.MakeTag(image.String(), dstImg.String()); err == nil {
-				success <- dstImg
-			} else {
-				glog.Errorf("create tag from %s to %s fails, stderr:%s, error:%s\n", image, dstImg, stderr, err)
-			}
-			// delete old one
-			if _, stderr, err := dockerexec.DeleteImage(image.registry, image.repo, image.tag); err != nil {
-				glog.Errorf("delete image %s fails, stderror:%s, error:%s\n", image, stderr, err)
-			}
+// loadConfig loads sync jobs from the config file, or creates a job from flags
+func loadConfig() (*config.Config, error) {
+	if configFile != "" {
+		return config.Load(configFile)
+	}
+
+	if srcRepoOwner == "" || srcRepoOwner == "library" {
+		srcRepoOwner = "library" // empty is library
+		dstRepoOwner = "docker_library"
+	}
+	dstNamespace := dstRepoOwner
+	if srcRegistry != "" && !flagSet("dst-repo-owner") {
+		// keep the namespace when synchronizing from a registry other than docker hub
+		dstNamespace = srcRepoOwner
+	}
+
+	if tagInclude != "" {
+		tagFilter.Include = []string{tagInclude}
+	}
+	if tagExclude != "" {
+		tagFilter.Exclude = []string{tagExclude}
+	}
+	prunePolicy := config.PrunePolicy{Enabled: prune}
+	if pruneProtect != "" {
+		prunePolicy.Protect = []string{pruneProtect}
+	}
+
+	if dstUsername == "" && dstRepoPassword != "" {
+		// the destination repo owner used to be the username
+		dstUsername = dstRepoOwner
+	}
+
+	cfg := &config.Config{
+		Credentials: map[string]config.Credential{
+			"source":      {Username: srcUsername, Password: srcRepoPassword, IdentityToken: srcIdentityToken},
+			"destination": {Username: dstUsername, Password: dstRepoPassword, IdentityToken: dstIdentityToken},
+		},
+		Jobs: []config.Job{{
+			Name: "default",
+			Source: config.Endpoint{
+				Registry:    srcRegistry,
+				Version:     srcRegistryVersion,
+				Namespace:   srcRepoOwner,
+				Credentials: "source",
+				PageSize:    pageSize,
+				Archive:     srcArchive,
+			},
+			Destination: config.Endpoint{
+				Registry:    dstRegistry,
+				Version:     dstRegistryVersion,
+				Namespace:   dstNamespace,
+				Credentials: "destination",
+				PageSize:    pageSize,
+				Archive:     dstArchive,
+				Baseline:    baseline,
+			},
+			Tags:      tagFilter,
+			Platforms: splitList(platforms),
+			Schedule:  defaultSchedule,
+			Prune:     prunePolicy,
+		}},
+	}
+	return cfg, cfg.Validate()
+}
+
+// loadState loads the state of the previous run if resume is set, or starts
+// a new one, nil is returned if no state file is given or in a dry run
+func loadState() (*state.State, error) {
+	if stateFile == "" && resume {
+		return nil, errors.New("--resume requires --state")
+	}
+	if stateFile == "" || dryRun {
+		return nil, nil
+	}
+	if resume {
+		return state.Load(stateFile)
+	}
+	return state.New(stateFile), nil
+}
+
+// splitList splits a comma separated list, empty items are dropped
+func splitList(s string) []string {
+	var res []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
 		}
-		close(success)
-	}()
+	}
+	return res
+}
 
-	return success
+// flagSet returns true if flag name is set in command line
+func flagSet(name string) bool {
+	found := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
 }
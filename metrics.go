@@ -0,0 +1,111 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/oscarzhao/image-sync/config"
+	"github.com/oscarzhao/image-sync/dockerhub"
+	"github.com/oscarzhao/image-sync/metrics"
+	"github.com/oscarzhao/image-sync/report"
+)
+
+var (
+	imagesTotal = metrics.NewCounter("image_sync_images_total",
+		"Images synchronized, by job, stage, registry and result (copied, skipped or failed).",
+		"job", "stage", "registry", "result")
+	bytesTotal = metrics.NewCounter("image_sync_bytes_total",
+		"Bytes of blobs transferred to the destination registry.",
+		"job", "registry")
+	stageDuration = metrics.NewHistogram("image_sync_stage_duration_seconds",
+		"Time an image spends in a pipeline stage, waits for the registry limiter included.",
+		nil, "job", "stage")
+	lastSuccess = metrics.NewGauge("image_sync_last_success_timestamp_seconds",
+		"Unix time the last run of a job in which no image failed finished.",
+		"job")
+)
+
+func init() {
+	limits := []*dockerhub.RateLimit{dockerhub.APILimit, dockerhub.PullLimit}
+	metrics.NewCounterFunc("image_sync_rate_limit_waits_total",
+		"Pauses made to stay within the docker hub rate limits.",
+		"service", func() map[string]float64 {
+			values := make(map[string]float64)
+			for _, l := range limits {
+				waits, _ := l.Waits()
+				values[l.Name] = float64(waits)
+			}
+			return values
+		})
+	metrics.NewCounterFunc("image_sync_rate_limit_wait_seconds_total",
+		"Time paused to stay within the docker hub rate limits.",
+		"service", func() map[string]float64 {
+			values := make(map[string]float64)
+			for _, l := range limits {
+				_, waited := l.Waits()
+				values[l.Name] = waited.Seconds()
+			}
+			return values
+		})
+}
+
+// stages maps statuses to the stage an image ends in
+var stages = map[report.Status]string{
+	report.StatusCopied:       "copy",
+	report.StatusSkipped:      "check",
+	report.StatusListFailed:   "list",
+	report.StatusPullFailed:   "pull",
+	report.StatusTagFailed:    "tag",
+	report.StatusPushFailed:   "push",
+	report.StatusDeleteFailed: "delete",
+	report.StatusPruned:       "prune",
+	report.StatusPruneFailed:  "prune",
+}
+
+// countImage counts an image of job ending with status, bytes transferred
+// are counted for copied images
+func countImage(job config.Job, status report.Status, bytes int64) {
+	stage := stages[status]
+	// listing and pulling talk to the source registry, the others to the destination
+	host := job.Destination.Registry
+	if job.Destination.Archive != "" {
+		host = job.Destination.Archive
+	}
+	if stage == "list" || stage == "pull" {
+		host = job.Source.Registry
+		if job.Source.Archive != "" {
+			host = job.Source.Archive
+		}
+	}
+	if host == "" {
+		host = "index.docker.io"
+	}
+
+	result := string(status)
+	if status.Failed() {
+		result = "failed"
+	}
+	imagesTotal.Inc(job.Name, stage, host, result)
+	if bytes > 0 {
+		bytesTotal.Add(float64(bytes), job.Name, host)
+	}
+}
+
+// count counts an image of the job, and the failures of the run
+func (s *syncer) count(status report.Status, bytes int64) {
+	if status.Failed() {
+		atomic.AddInt32(&s.failures, 1)
+	}
+	countImage(s.job, status, bytes)
+}
+
+// timed observes the time fn takes in the latency of stage
+func (s *syncer) timed(stage string, fn func(*task) bool) func(*task) bool {
+	return func(t *task) bool {
+		start := time.Now()
+		defer func() {
+			stageDuration.Observe(time.Since(start).Seconds(), s.job.Name, stage)
+		}()
+		return fn(t)
+	}
+}
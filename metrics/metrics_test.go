@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	images := NewCounter("test_images_total", "Images synchronized.", "job", "status")
+	images.Inc("library", "copied")
+	images.Add(2, "library", "copied")
+	images.Inc(`a"b`, "failed")
+	last := NewGauge("test_last_success_timestamp_seconds", "Last success.")
+	last.Set(1.5e9)
+	latency := NewHistogram("test_duration_seconds", "Stage latency.", []float64{1, 10}, "stage")
+	latency.Observe(0.5, "copy")
+	latency.Observe(5, "copy")
+	latency.Observe(50, "copy")
+	NewCounterFunc("test_waits_total", "Waits.", "service", func() map[string]float64 {
+		return map[string]float64{"hub": 3}
+	})
+
+	var buf bytes.Buffer
+	for _, c := range []collector{images, last, latency} {
+		c.write(&buf)
+	}
+	expected := `# HELP test_images_total Images synchronized.
+# TYPE test_images_total counter
+test_images_total{job="a\"b",status="failed"} 1
+test_images_total{job="library",status="copied"} 3
+# HELP test_last_success_timestamp_seconds Last success.
+# TYPE test_last_success_timestamp_seconds gauge
+test_last_success_timestamp_seconds 1.5e+09
+# HELP test_duration_seconds Stage latency.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{stage="copy",le="1"} 1
+test_duration_seconds_bucket{stage="copy",le="10"} 2
+test_duration_seconds_bucket{stage="copy",le="+Inf"} 3
+test_duration_seconds_sum{stage="copy"} 55.5
+test_duration_seconds_count{stage="copy"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("expected metrics:\n%s\ngot:\n%s\n", expected, buf.String())
+	}
+
+	rec := httptest.NewRecorder()
+	Default.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "test_waits_total{service=\"hub\"} 3\n") {
+		t.Errorf("metrics served should contain the collected counter, got:\n%s\n", rec.Body.String())
+	}
+	if images.Value("library", "copied") != 3 {
+		t.Errorf("value should be 3, got %v\n", images.Value("library", "copied"))
+	}
+}
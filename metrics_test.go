@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/oscarzhao/image-sync/config"
+	"github.com/oscarzhao/image-sync/report"
+)
+
+func TestCountImage(t *testing.T) {
+	job := config.Job{Name: "count", Destination: config.Endpoint{Registry: "index.tenxcloud.com"}}
+	countImage(job, report.StatusCopied, 100)
+	countImage(job, report.StatusSkipped, 0)
+	countImage(job, report.StatusPullFailed, 0)
+	countImage(job, report.StatusPushFailed, 0)
+
+	testCases := []struct {
+		stage, registry, result string
+	}{
+		{"copy", "index.tenxcloud.com", "copied"},
+		{"check", "index.tenxcloud.com", "skipped"},
+		{"pull", "index.docker.io", "failed"},
+		{"push", "index.tenxcloud.com", "failed"},
+	}
+	for _, tc := range testCases {
+		if n := imagesTotal.Value(job.Name, tc.stage, tc.registry, tc.result); n != 1 {
+			t.Errorf("images of stage %s at %s %s should be 1, got %v\n", tc.stage, tc.registry, tc.result, n)
+		}
+	}
+	if n := bytesTotal.Value(job.Name, "index.tenxcloud.com"); n != 100 {
+		t.Errorf("bytes transferred should be 100, got %v\n", n)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+
+	"github.com/golang/glog"
+
+	"github.com/oscarzhao/image-sync/plan"
+	"github.com/oscarzhao/image-sync/registry"
+	"github.com/oscarzhao/image-sync/report"
+)
+
+// planImages adds the action each selected image of the job would take, and
+// the tags prune and retention would delete to p, nothing is written to the
+// destination
+func (s *syncer) planImages(p *plan.Plan) {
+	srcRepo2Tags, _, ok := s.listImages()
+	if !ok {
+		return
+	}
+	srcRepo2Tags = s.retainedImages(srcRepo2Tags)
+	tasks := s.listImagesToPull(srcRepo2Tags)
+	for range runStage(s.concurrency.Check, tasks, s.timed("plan", func(t *task) bool {
+		p.Add(s.planImage(t))
+		return false
+	})) {
+	}
+	var pruned []deletion
+	if s.job.Prune.Enabled && !stopped() {
+		pruned = s.planPrune(p)
+	}
+	if !stopped() {
+		s.planRetention(p, srcRepo2Tags, pruned)
+	}
+}
+
+// planImage compares the image of t at the source and the destination, and
+// estimates the bytes a copy would transfer
+func (s *syncer) planImage(t *task) plan.Entry {
+	image, dstImg := t.src, t.dst
+	e := plan.Entry{Job: s.job.Name, Source: image.String(), Destination: dstImg.String()}
+	release := s.limiter.acquire(image.registry, dstImg.registry)
+	defer release()
+
+	fail := func(err error) plan.Entry {
+		glog.Errorf("plan %s fails, error:%s\n", image, err)
+		e.Action, e.Error = plan.ActionError, err.Error()
+		return e
+	}
+	srcDigest, err := s.srcClient.ManifestDigest(image.repo, image.tag)
+	if err != nil {
+		return fail(err)
+	}
+	e.SourceDigest = srcDigest
+
+	dstDigest, err := s.dstClient.ManifestDigest(dstImg.repo, image.tag)
+	switch {
+	case registry.IsNotFound(err):
+		e.Action = plan.ActionCreate
+	case err != nil:
+		return fail(err)
+	default:
+		e.DestinationDigest = dstDigest
+		if srcDigest != "" && srcDigest == dstDigest {
+			e.Action = plan.ActionSkip
+			return e
+		}
+		if skipSynced {
+			// images whose digests differ may have the same content, such as schema1 ones
+			same, err := registry.SameImage(s.srcClient, s.dstClient, image.repo, dstImg.repo, image.tag, s.copyOptions)
+			if err != nil {
+				return fail(err)
+			}
+			if same {
+				e.Action = plan.ActionSkip
+				return e
+			}
+		}
+		e.Action = plan.ActionUpdate
+	}
+
+	if e.Bytes, err = registry.EstimateCopy(s.srcClient, s.dstClient, image.repo, dstImg.repo, image.tag, s.copyOptions); err != nil {
+		return fail(err)
+	}
+	return e
+}
+
+// writePlan prints p for humans, or writes it in json to --plan
+func writePlan(p *plan.Plan) {
+	if planFile == "-" {
+		if err := p.WriteJSON(os.Stdout); err != nil {
+			glog.Errorf("write plan fails, error:%s\n", err)
+		}
+		return
+	}
+	if err := p.WriteText(os.Stdout); err != nil {
+		glog.Errorf("write plan fails, error:%s\n", err)
+	}
+	if planFile != "" {
+		if err := report.WriteFile(planFile, p.WriteJSON); err != nil {
+			glog.Errorf("write plan to %s fails, error:%s\n", planFile, err)
+		}
+	}
+}
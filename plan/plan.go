@@ -0,0 +1,131 @@
+// Package plan collects what a sync would do, without doing it
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Action is what a sync would do to an image
+type Action string
+
+const (
+	// ActionCreate copies an image missing at the destination
+	ActionCreate Action = "create"
+	// ActionUpdate copies an image whose digest changed upstream
+	ActionUpdate Action = "update"
+	// ActionSkip leaves an image the destination has already
+	ActionSkip Action = "skip"
+	// ActionPrune deletes a destination tag missing upstream
+	ActionPrune Action = "prune"
+	// ActionError marks an image which cannot be inspected
+	ActionError Action = "error"
+)
+
+// Entry is the action planned for an image
+type Entry struct {
+	Job         string `json:"job"`
+	Action      Action `json:"action"`
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination"`
+	// SourceDigest and DestinationDigest are the manifest digests, empty if the image is missing
+	SourceDigest      string `json:"sourceDigest,omitempty"`
+	DestinationDigest string `json:"destinationDigest,omitempty"`
+	// Bytes estimates the bytes to transfer, blobs the destination has are not counted
+	Bytes int64  `json:"bytes"`
+	Error string `json:"error,omitempty"`
+}
+
+// Plan collects entries, it is safe for concurrent use
+type Plan struct {
+	mu      sync.Mutex
+	Summary map[Action]int `json:"summary"`
+	Bytes   int64          `json:"bytes"`
+	Entries []Entry        `json:"entries"`
+}
+
+// New creates an empty plan
+func New() *Plan {
+	return &Plan{Summary: make(map[Action]int)}
+}
+
+// Add appends an entry to p
+func (p *Plan) Add(e Entry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Entries = append(p.Entries, e)
+	p.Summary[e.Action]++
+	p.Bytes += e.Bytes
+}
+
+// sort orders entries by job and destination, so that plans are comparable
+func (p *Plan) sort() {
+	sort.SliceStable(p.Entries, func(i, j int) bool {
+		a, b := p.Entries[i], p.Entries[j]
+		if a.Job != b.Job {
+			return a.Job < b.Job
+		}
+		return a.Destination < b.Destination
+	})
+}
+
+// WriteJSON writes p in json
+func (p *Plan) WriteJSON(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sort()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// WriteText writes p for humans, an action per line grouped by job, followed by the summary
+func (p *Plan) WriteText(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sort()
+	job := ""
+	for i, e := range p.Entries {
+		if i == 0 || e.Job != job {
+			job = e.Job
+			fmt.Fprintf(w, "job %s:\n", job)
+		}
+		line := fmt.Sprintf("  %-7s %s", e.Action, e.Destination)
+		switch e.Action {
+		case ActionCreate:
+			line += fmt.Sprintf(" <- %s (%s, %s)", e.Source, e.SourceDigest, FormatBytes(e.Bytes))
+		case ActionUpdate:
+			line += fmt.Sprintf(" <- %s (%s -> %s, %s)", e.Source, e.DestinationDigest, e.SourceDigest, FormatBytes(e.Bytes))
+		case ActionPrune:
+			if e.DestinationDigest != "" {
+				line += fmt.Sprintf(" (%s)", e.DestinationDigest)
+			}
+		case ActionError:
+			if e.Source != "" {
+				line += " <- " + e.Source
+			}
+			line += ", error:" + e.Error
+		}
+		fmt.Fprintln(w, line)
+	}
+	_, err := fmt.Fprintf(w, "plan: %d to create, %d to update, %d to skip, %d to prune, %d errors, about %s to transfer\n",
+		p.Summary[ActionCreate], p.Summary[ActionUpdate], p.Summary[ActionSkip], p.Summary[ActionPrune], p.Summary[ActionError], FormatBytes(p.Bytes))
+	return err
+}
+
+// FormatBytes formats n in binary units, such as 1.5 MiB
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,55 @@
+package plan
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func newTestPlan() *Plan {
+	p := New()
+	p.Add(Entry{Job: "hub", Action: ActionUpdate, Source: "library/busybox:latest", Destination: "docker_library/busybox:latest", SourceDigest: "sha256:new", DestinationDigest: "sha256:old", Bytes: 2048})
+	p.Add(Entry{Job: "hub", Action: ActionCreate, Source: "library/alpine:3.4", Destination: "docker_library/alpine:3.4", SourceDigest: "sha256:abc", Bytes: 3 << 20})
+	p.Add(Entry{Job: "gcr", Action: ActionSkip, Source: "gcr.io/google_containers/pause:3.0", Destination: "google_containers/pause:3.0"})
+	return p
+}
+
+func TestWriteText(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newTestPlan().WriteText(&buf); err != nil {
+		t.Fatalf("write text should succeed, error:%s\n", err)
+	}
+	expected := `job gcr:
+  skip    google_containers/pause:3.0
+job hub:
+  create  docker_library/alpine:3.4 <- library/alpine:3.4 (sha256:abc, 3.0 MiB)
+  update  docker_library/busybox:latest <- library/busybox:latest (sha256:old -> sha256:new, 2.0 KiB)
+plan: 1 to create, 1 to update, 1 to skip, 0 to prune, 0 errors, about 3.0 MiB to transfer
+`
+	if buf.String() != expected {
+		t.Errorf("expected plan:\n%s\ngot:\n%s\n", expected, buf.String())
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newTestPlan().WriteJSON(&buf); err != nil {
+		t.Fatalf("write json should succeed, error:%s\n", err)
+	}
+	var decoded Plan
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid json plan, error:%s\n", err)
+	}
+	if len(decoded.Entries) != 3 || decoded.Summary[ActionCreate] != 1 || decoded.Bytes != 3<<20+2048 {
+		t.Errorf("unexpected plan: %s\n", buf.String())
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	testCases := map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 5 << 30: "5.0 GiB"}
+	for n, expected := range testCases {
+		if s := FormatBytes(n); s != expected {
+			t.Errorf("%d bytes should be %s, got %s\n", n, expected, s)
+		}
+	}
+}
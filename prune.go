@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/oscarzhao/image-sync/config"
+	"github.com/oscarzhao/image-sync/plan"
+	"github.com/oscarzhao/image-sync/registry"
+	"github.com/oscarzhao/image-sync/report"
+)
+
+// deletion is a destination tag to delete, digest is the manifest it refers to
+type deletion struct {
+	repo   string
+	tag    string
+	digest string
+}
+
+// prune deletes destination tags of the job which are gone upstream. Nothing
+// is deleted if more tags than the max deletions of the job would be.
+func (s *syncer) prune() {
+	if s.upstream == nil {
+		glog.Warningf("job %s, the source is not listed by this run, prune is skipped\n", s.job.Name)
+		return
+	}
+	fail := func(destination string, err error) {
+		glog.Errorf("job %s, prune %s fails, error:%s\n", s.job.Name, destination, err)
+		s.recordPrune(destination, "", report.StatusPruneFailed, err)
+	}
+	deletions := s.pruneDeletions(fail)
+	if err := s.checkMaxDeletions(deletions); err != nil {
+		fail(s.job.Destination.Namespace, err)
+		return
+	}
+	s.deleteTags(deletions)
+}
+
+// planPrune adds the destination tags prune would delete to p, and returns them
+func (s *syncer) planPrune(p *plan.Plan) []deletion {
+	fail := func(destination string, err error) {
+		glog.Errorf("job %s, plan prune of %s fails, error:%s\n", s.job.Name, destination, err)
+		p.Add(plan.Entry{Job: s.job.Name, Action: plan.ActionError, Destination: destination, Error: err.Error()})
+	}
+	deletions := s.pruneDeletions(fail)
+	if err := s.checkMaxDeletions(deletions); err != nil {
+		fail(s.job.Destination.Namespace, err)
+		return nil
+	}
+	for _, d := range deletions {
+		dst := Image{s.job.Destination.Registry, d.repo, d.tag}
+		p.Add(plan.Entry{Job: s.job.Name, Action: plan.ActionPrune, Destination: dst.String(), DestinationDigest: d.digest})
+	}
+	return deletions
+}
+
+func (s *syncer) checkMaxDeletions(deletions []deletion) error {
+	if max := s.job.Prune.MaxDeletions; len(deletions) > max {
+		return fmt.Errorf("%d tags to prune exceed the max deletions %d, nothing is pruned", len(deletions), max)
+	}
+	return nil
+}
+
+// pruneDeletions returns the destination tags of repos listed upstream by
+// this run which are gone upstream. Repos gone upstream, and repos whose tag
+// listing did not finish, are not in s.upstream and are left alone. fail is
+// called for destination repos which cannot be inspected.
+func (s *syncer) pruneDeletions(fail func(destination string, err error)) []deletion {
+	repos := make([]string, 0, len(s.upstream))
+	for repo := range s.upstream {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+
+	var deletions []deletion
+	for _, repo := range repos {
+		dstRepo := s.dstImage(Image{repo: repo}).repo
+		release := s.limiter.acquire(s.job.Destination.Registry)
+		dstTags, err := s.dstClient.ListTags(dstRepo)
+		release()
+		if registry.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			fail(dstRepo, err)
+			continue
+		}
+		orphans := orphanTags(dstTags, s.upstream[repo], s.job.TagFilter(s.relativeRepo(repo)), &s.job.Prune)
+		if len(orphans) == 0 {
+			continue
+		}
+		digests, err := s.tagDigests(dstRepo, dstTags)
+		if err != nil {
+			fail(dstRepo, err)
+			continue
+		}
+		kept, shared := resolveDeletions(dstRepo, digests, orphans)
+		for _, tag := range shared {
+			glog.Warningf("job %s, %s:%s is gone upstream, but a kept tag refers to the same manifest, it is not pruned\n", s.job.Name, dstRepo, tag)
+		}
+		deletions = append(deletions, kept...)
+	}
+	return deletions
+}
+
+// orphanTags returns tags of dstTags missing upstream, which filter covers and
+// policy does not protect
+func orphanTags(dstTags []string, upstream map[string]time.Time, filter *config.TagFilter, policy *config.PrunePolicy) []string {
+	var orphans []string
+	for _, tag := range dstTags {
+		if _, ok := upstream[tag]; !ok && filter.Covers(tag) && !policy.Protected(tag) {
+			orphans = append(orphans, tag)
+		}
+	}
+	sort.Strings(orphans)
+	return orphans
+}
+
+// resolveDeletions returns the deletions of doomed tags of repo, digests maps
+// every tag of repo to its manifest digest. Deleting a manifest deletes all
+// tags referring to it, so doomed tags sharing the manifest of a kept tag are
+// not deleted, they are returned in shared.
+func resolveDeletions(repo string, digests map[string]string, doomed []string) (deletions []deletion, shared []string) {
+	isDoomed := make(map[string]bool, len(doomed))
+	for _, tag := range doomed {
+		isDoomed[tag] = true
+	}
+	kept := make(map[string]bool)
+	for tag, dgst := range digests {
+		if !isDoomed[tag] {
+			kept[dgst] = true
+		}
+	}
+	for _, tag := range doomed {
+		if dgst := digests[tag]; kept[dgst] {
+			shared = append(shared, tag)
+		} else {
+			deletions = append(deletions, deletion{repo: repo, tag: tag, digest: dgst})
+		}
+	}
+	return deletions, shared
+}
+
+// tagDigests returns the manifest digests of tags of repo in the destination
+func (s *syncer) tagDigests(repo string, tags []string) (map[string]string, error) {
+	digests := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		release := s.limiter.acquire(s.job.Destination.Registry)
+		dgst, err := s.dstClient.ManifestDigest(repo, tag)
+		release()
+		if err != nil {
+			return nil, fmt.Errorf("get digest of %s:%s fails, error:%s", repo, tag, err)
+		}
+		if dgst == "" {
+			return nil, fmt.Errorf("registry reports no digest of %s:%s", repo, tag)
+		}
+		digests[tag] = dgst
+	}
+	return digests, nil
+}
+
+// deleteTags deletes the manifests of deletions at the destination, once for
+// all tags referring to the same manifest
+func (s *syncer) deleteTags(deletions []deletion) {
+	errs := make(map[string]error)
+	for _, d := range deletions {
+		if stopped() {
+			glog.Warningf("job %s is stopped, tags left are not deleted\n", s.job.Name)
+			return
+		}
+		key := d.repo + "@" + d.digest
+		err, ok := errs[key]
+		if !ok {
+			release := s.limiter.acquire(s.job.Destination.Registry)
+			err = s.dstClient.DeleteManifest(d.repo, d.digest)
+			release()
+			errs[key] = err
+		}
+		dst := Image{s.job.Destination.Registry, d.repo, d.tag}
+		if err != nil {
+			glog.Errorf("delete %s (%s) fails, error:%s\n", dst, d.digest, err)
+			s.recordPrune(dst.String(), d.digest, report.StatusPruneFailed, err)
+			continue
+		}
+		glog.Infof("image %s (%s) is deleted\n", dst, d.digest)
+		s.recordPrune(dst.String(), d.digest, report.StatusPruned, nil)
+	}
+}
+
+// recordPrune adds the outcome of deleting destination to the report
+func (s *syncer) recordPrune(destination, digest string, status report.Status, err error) {
+	e := report.Entry{Job: s.job.Name, Status: status, Destination: destination, Digest: digest}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	s.report.Add(e)
+	s.count(status, 0)
+}
+
+// upstreamTags maps names of tags to their update time, so that tags gone
+// upstream are found even if the tag filter drops tags still upstream
+func upstreamTags(tags []config.Tag) map[string]time.Time {
+	names := make(map[string]time.Time, len(tags))
+	for _, t := range tags {
+		names[t.Name] = t.Updated
+	}
+	return names
+}
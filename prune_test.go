@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/oscarzhao/image-sync/config"
+)
+
+func TestOrphanTags(t *testing.T) {
+	filter := config.TagFilter{Filter: config.Filter{Exclude: []string{".*-rc\\d*"}}}
+	policy := config.PrunePolicy{Protect: []string{"latest", "stable-.*"}}
+	if err := filter.Compile(); err != nil {
+		t.Fatalf("compile filter fails, error:%s\n", err)
+	}
+	if err := policy.Compile(); err != nil {
+		t.Fatalf("compile policy fails, error:%s\n", err)
+	}
+	upstream := map[string]time.Time{"1.1": {}, "1.2": {}, "1.3-rc1": {}}
+	dstTags := []string{"latest", "1.2", "1.0", "1.1", "0.9", "1.0-rc1", "stable-1", "edge"}
+
+	expected := []string{"0.9", "1.0", "edge"}
+	if orphans := orphanTags(dstTags, upstream, &filter, &policy); !reflect.DeepEqual(orphans, expected) {
+		t.Errorf("expected orphan tags %v, got %v\n", expected, orphans)
+	}
+}
+
+func TestResolveDeletions(t *testing.T) {
+	digests := map[string]string{
+		"latest": "sha256:a",
+		"1.1":    "sha256:a",
+		"1.0":    "sha256:b",
+		"1.0.0":  "sha256:b",
+		"0.9":    "sha256:c",
+	}
+	deletions, shared := resolveDeletions("docker_library/busybox", digests, []string{"0.9", "1.0", "1.0.0", "1.1"})
+	expected := []deletion{
+		{"docker_library/busybox", "0.9", "sha256:c"},
+		{"docker_library/busybox", "1.0", "sha256:b"},
+		{"docker_library/busybox", "1.0.0", "sha256:b"},
+	}
+	if !reflect.DeepEqual(deletions, expected) {
+		t.Errorf("expected deletions %v, got %v\n", expected, deletions)
+	}
+	if !reflect.DeepEqual(shared, []string{"1.1"}) {
+		t.Errorf("1.1 shares the manifest of latest, it should be kept, got %v\n", shared)
+	}
+}
@@ -0,0 +1,109 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Credential authenticates against a registry. IdentityToken is the oauth2
+// refresh token saved by docker login, it is used instead of the password if set.
+type Credential struct {
+	Username      string
+	Password      string
+	IdentityToken string
+}
+
+// Empty returns true if c carries no secret
+func (c Credential) Empty() bool {
+	return c.Password == "" && c.IdentityToken == ""
+}
+
+// dockerConfig is the part of ~/.docker/config.json holding credentials
+type dockerConfig struct {
+	Auths map[string]dockerConfigAuth `json:"auths"`
+}
+
+type dockerConfigAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// DockerConfigCredential returns the credential of registry saved by docker login,
+// in $DOCKER_CONFIG/config.json or ~/.docker/config.json. An empty credential
+// is returned if the file or the registry is not found.
+func DockerConfigCredential(registry string) (Credential, error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			return Credential{}, nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	return loadDockerConfigCredential(filepath.Join(dir, "config.json"), registry)
+}
+
+func loadDockerConfigCredential(path, registry string) (Credential, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Credential{}, nil
+	}
+	if err != nil {
+		return Credential{}, err
+	}
+	var cfg dockerConfig
+	if err := json.Unmarshal(content, &cfg); err != nil {
+		return Credential{}, fmt.Errorf("invalid docker config %s, error:%s", path, err)
+	}
+
+	host := normalizeRegistryHost(registry)
+	for key, auth := range cfg.Auths {
+		if normalizeRegistryHost(key) != host {
+			continue
+		}
+		cred := Credential{Username: auth.Username, Password: auth.Password, IdentityToken: auth.IdentityToken}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return Credential{}, fmt.Errorf("invalid auth of %s in docker config %s, error:%s", key, path, err)
+			}
+			arr := strings.SplitN(string(decoded), ":", 2)
+			if len(arr) != 2 {
+				return Credential{}, fmt.Errorf("invalid auth of %s in docker config %s", key, path)
+			}
+			cred.Username, cred.Password = arr[0], arr[1]
+		}
+		return cred, nil
+	}
+	return Credential{}, nil
+}
+
+// SameRegistry returns true if a and b are names of the same registry,
+// such as docker.io and index.docker.io
+func SameRegistry(a, b string) bool {
+	return normalizeRegistryHost(a) == normalizeRegistryHost(b)
+}
+
+// normalizeRegistryHost returns the host of a registry url or docker config key,
+// all names of docker hub are mapped to index.docker.io
+func normalizeRegistryHost(registry string) string {
+	host := registry
+	if idx := strings.Index(host, "://"); idx >= 0 {
+		host = host[idx+3:]
+	}
+	if idx := strings.Index(host, "/"); idx >= 0 {
+		host = host[:idx]
+	}
+	switch host {
+	case "", "docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "index.docker.io"
+	}
+	return host
+}
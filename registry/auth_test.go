@@ -0,0 +1,93 @@
+package registry
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testDockerConfig = `{
+  "auths": {
+    "https://index.docker.io/v1/": {"auth": "b3NjYXJ6aGFvOnNlY3JldA=="},
+    "index.tenxcloud.com": {"auth": "dGVueDpwYXNzOndvcmQ="},
+    "https://gcr.io": {"identitytoken": "refresh-token"}
+  }
+}`
+
+func TestDockerConfigCredential(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-config")
+	if err != nil {
+		t.Fatalf("create temp dir fails, error:%s\n", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(testDockerConfig), 0600); err != nil {
+		t.Fatalf("write docker config fails, error:%s\n", err)
+	}
+	os.Setenv("DOCKER_CONFIG", dir)
+	defer os.Unsetenv("DOCKER_CONFIG")
+
+	testCases := []struct {
+		registry string
+		expected Credential
+	}{
+		{"", Credential{Username: "oscarzhao", Password: "secret"}},
+		{"index.docker.io", Credential{Username: "oscarzhao", Password: "secret"}},
+		{"index.tenxcloud.com", Credential{Username: "tenx", Password: "pass:word"}},
+		{"gcr.io", Credential{IdentityToken: "refresh-token"}},
+		{"quay.io", Credential{}},
+	}
+	for _, tc := range testCases {
+		cred, err := DockerConfigCredential(tc.registry)
+		if err != nil {
+			t.Errorf("credential of %q should be found, error:%s\n", tc.registry, err)
+			continue
+		}
+		if cred != tc.expected {
+			t.Errorf("credential of %q should be %#v, got %#v\n", tc.registry, tc.expected, cred)
+		}
+	}
+
+	os.Setenv("DOCKER_CONFIG", filepath.Join(dir, "notfound"))
+	if cred, err := DockerConfigCredential("index.tenxcloud.com"); err != nil || !cred.Empty() {
+		t.Errorf("missing docker config should give an empty credential, got %#v, error:%v\n", cred, err)
+	}
+}
+
+func TestIdentityToken(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/token":
+			req.ParseForm()
+			if req.Method != "POST" || req.PostForm.Get("grant_type") != "refresh_token" ||
+				req.PostForm.Get("refresh_token") != "refresh-token" || req.PostForm.Get("scope") != "repository:private/app:pull" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"access-token"}`)
+		case "/v2/private/app/tags/list":
+			if req.Header.Get("Authorization") != "Bearer access-token" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:private/app:pull"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"name":"private/app","tags":["v1"]}`)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	reg, err := newV2Registry(server.URL, Credential{Username: "oscarzhao", IdentityToken: "refresh-token"}, true)
+	if err != nil {
+		t.Fatalf("create registry client fails, error:%s\n", err)
+	}
+	tags, err := reg.Tags("private/app")
+	if err != nil || len(tags) != 1 || tags[0] != "v1" {
+		t.Errorf("tags of private repo should be listed with the identity token, got %v, error:%v\n", tags, err)
+	}
+}
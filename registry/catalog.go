@@ -0,0 +1,61 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// defaultCatalogPageSize is the number of repos requested per page of /v2/_catalog
+// if the client has no page size
+const defaultCatalogPageSize = 100
+
+type catalogResponse struct {
+	Repositories []string `json:"repositories"`
+}
+
+// ListCatalog lists all repos of a registry v2 server through /v2/_catalog,
+// following the Link header until the last page. Repos listed are returned
+// along with a *PartialListError if a page fails.
+func (c *Client) ListCatalog() ([]string, error) {
+	if c.RegClientV2 == nil {
+		return nil, fmt.Errorf("registry %s does not support catalog api", c.registry)
+	}
+
+	var repos []string
+	pageSize := c.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultCatalogPageSize
+	}
+	err := getPages(c.RegClientV2, pageURL(c.RegClientV2.URL+"/v2/_catalog", pageSize), func(d *json.Decoder) error {
+		var page catalogResponse
+		if err := d.Decode(&page); err != nil {
+			return err
+		}
+		repos = append(repos, page.Repositories...)
+		return nil
+	})
+	if err != nil {
+		return repos, err
+	}
+	glog.V(6).Infof("ListCatalog succeeds, registry:%s, repos:%d\n", c.registry, len(repos))
+	return repos, nil
+}
+
+// listRepositoriesV2 lists repos under the namespace owner, all repos if owner is empty
+func (c *Client) listRepositoriesV2(owner string) ([]string, error) {
+	repos, err := c.ListCatalog()
+	owner = strings.Trim(owner, "/")
+	if owner == "" {
+		return repos, err
+	}
+	var res []string
+	for _, repo := range repos {
+		if strings.HasPrefix(repo, owner+"/") {
+			res = append(res, repo)
+		}
+	}
+	return res, err
+}
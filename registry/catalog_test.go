@@ -0,0 +1,43 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestListRepositoriesV2(t *testing.T) {
+	r := newFakeRegistry(t)
+	for _, repo := range []string{"google_containers/pause", "google_containers/etcd", "library/busybox", "google_containers/kube-proxy", "google_containers_mirror/pause"} {
+		pushSchema1Image(t, r, repo, "latest", "layer-a")
+	}
+	c := r.client(t)
+	c.PageSize = 2
+
+	all, err := c.ListCatalog()
+	if err != nil {
+		t.Fatalf("list catalog should succeed, error:%s\n", err)
+	}
+	if len(all) != 5 {
+		t.Errorf("all repos should be listed across pages, got %v\n", all)
+	}
+
+	testCases := []struct {
+		owner    string
+		expected []string
+	}{
+		{"google_containers", []string{"google_containers/etcd", "google_containers/kube-proxy", "google_containers/pause"}},
+		{"library", []string{"library/busybox"}},
+		{"notfound", nil},
+		{"", all},
+	}
+	for _, tc := range testCases {
+		repos, err := c.ListRepositories(tc.owner)
+		if err != nil {
+			t.Errorf("list repos of %q should succeed, error:%s\n", tc.owner, err)
+			continue
+		}
+		if !reflect.DeepEqual(repos, tc.expected) {
+			t.Errorf("repos of %q should be %v, got %v\n", tc.owner, tc.expected, repos)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/libtrust"
+	"github.com/golang/glog"
+
+	registryV2 "github.com/oscarzhao/docker-registry-client/registry"
+)
+
+// hubRegistryURL is the registry v2 endpoint serving docker hub images
+const hubRegistryURL = "https://registry-1.docker.io"
+
+var (
+	signingKey     libtrust.PrivateKey
+	signingKeyErr  error
+	signingKeyOnce sync.Once
+)
+
+// CopyResult describes a copied image
+type CopyResult struct {
+	// Digest is the manifest digest at the destination
+	Digest string
+	// Bytes is the size of layers transferred, layers existing at the destination are not counted
+	Bytes int64
+}
+
+// CopyOptions tunes CopyImage, nil means the defaults
+type CopyOptions struct {
+	// Platforms selects the images of a manifest list or an OCI index, such as
+	// linux/arm64 or linux/arm/v7, all platforms are copied if it is empty
+	Platforms []string
+	// ChunkSize is the size of PATCH requests of blob uploads, DefaultChunkSize if 0
+	ChunkSize int64
+	// Sessions keeps the locations of unfinished blob uploads to resume them
+	// in a later run, uploads are resumed within a run only if it is nil
+	Sessions UploadSessions
+	// BlobRepos maps blobs an archive leaves out to the destination repos
+	// holding them, ImportImage mounts them from there
+	BlobRepos map[string]string
+}
+
+// CopyError is returned by CopyImage, Op is "pull" if reading from the source
+// fails, and "push" if writing to the destination fails
+type CopyError struct {
+	Op  string
+	Err error
+}
+
+func (e *CopyError) Error() string {
+	return e.Op + " fails, " + e.Err.Error()
+}
+
+func pullError(format string, args ...interface{}) error {
+	return &CopyError{Op: "pull", Err: fmt.Errorf(format, args...)}
+}
+
+func pushError(format string, args ...interface{}) error {
+	return &CopyError{Op: "push", Err: fmt.Errorf(format, args...)}
+}
+
+// CopyImage copies srcRepo:tag in src to dstRepo:tag in dst through registry v2 api.
+// Layers are streamed from src to dst directly, so neither a docker daemon
+// nor local disk is needed. Docker schema2 and OCI manifests keep their digests,
+// manifest lists and OCI indexes are copied with the images of all platforms,
+// or the platforms in opts.
+func CopyImage(src, dst *Client, srcRepo, dstRepo, tag string, opts *CopyOptions) (*CopyResult, error) {
+	if src.RegClientV2 == nil || dst.RegClientV2 == nil {
+		return nil, errors.New("copy image requires registry v2 api at both source and destination")
+	}
+	srcRepo = src.repoPath(srcRepo)
+	dstRepo = dst.repoPath(dstRepo)
+
+	m, err := getManifest(src.RegClientV2, srcRepo, tag, acceptManifestTypes)
+	if err != nil {
+		return nil, pullError("get manifest of %s:%s fails, error:%s", srcRepo, tag, err)
+	}
+	switch {
+	case m.IsList():
+		return copyManifestList(src, dst, srcRepo, dstRepo, tag, m, opts)
+	case m.IsSchema1():
+		return copySchema1(src, dst, srcRepo, dstRepo, tag, m, opts)
+	}
+
+	// docker schema2 and OCI manifests have no name or tag, they are put byte for byte
+	result := &CopyResult{Digest: m.Digest}
+	if result.Bytes, err = copyManifestBlobs(src, dst, srcRepo, dstRepo, m, make(map[digest.Digest]bool), opts); err != nil {
+		return nil, err
+	}
+	if err := putManifest(dst.RegClientV2, dstRepo, tag, m); err != nil {
+		return nil, pushError("put manifest of %s:%s fails, error:%s", dstRepo, tag, err)
+	}
+	glog.V(4).Infof("image %s:%s copied to %s:%s, media type:%s, bytes:%d\n", srcRepo, tag, dstRepo, tag, m.MediaType, result.Bytes)
+	return result, nil
+}
+
+// copySchema1 copies a schema1 image, the manifest is signed again if the repo name changes
+func copySchema1(src, dst *Client, srcRepo, dstRepo, tag string, m *Manifest, opts *CopyOptions) (*CopyResult, error) {
+	signedManifest, err := m.Schema1()
+	if err != nil {
+		return nil, pullError("invalid manifest of %s:%s, error:%s", srcRepo, tag, err)
+	}
+
+	result := &CopyResult{}
+	copied := make(map[digest.Digest]bool)
+	if result.Bytes, err = copyManifestBlobs(src, dst, srcRepo, dstRepo, m, copied, opts); err != nil {
+		return nil, err
+	}
+
+	// schema1 manifests carry repo name and tag, they must be signed again once changed
+	if signedManifest.Name != dstRepo || signedManifest.Tag != tag {
+		if signedManifest, err = resignManifest(signedManifest, dstRepo, tag); err != nil {
+			return nil, pushError("sign manifest of %s:%s fails, error:%s", dstRepo, tag, err)
+		}
+	}
+	if err := dst.RegClientV2.PutManifest(dstRepo, tag, signedManifest); err != nil {
+		return nil, pushError("put manifest of %s:%s fails, error:%s", dstRepo, tag, err)
+	}
+	if payload, err := signedManifest.Payload(); err == nil {
+		if dgst, err := digest.FromBytes(payload); err == nil {
+			result.Digest = dgst.String()
+		}
+	}
+	glog.V(4).Infof("image %s:%s copied to %s:%s, layers:%d, bytes:%d\n", srcRepo, tag, dstRepo, tag, len(copied), result.Bytes)
+	return result, nil
+}
+
+// copyManifestBlobs copies the blobs referenced by image manifest m, blobs in
+// copied are skipped, and blobs copied are added to it
+func copyManifestBlobs(src, dst *Client, srcRepo, dstRepo string, m *Manifest, copied map[digest.Digest]bool, opts *CopyOptions) (int64, error) {
+	blobs, err := m.Blobs()
+	if err != nil {
+		return 0, pullError("invalid manifest %s of %s, error:%s", m.Digest, srcRepo, err)
+	}
+	var total int64
+	for _, blob := range blobs {
+		dgst := digest.Digest(blob)
+		if copied[dgst] {
+			continue
+		}
+		n, err := copyBlob(src.RegClientV2, dst.RegClientV2, srcRepo, dstRepo, dgst, opts)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+		copied[dgst] = true
+	}
+	return total, nil
+}
+
+// copyBlob streams a blob from src to dst unless dst has it already,
+// and returns the number of bytes transferred. Blobs are mounted across repos
+// if src and dst are the same registry, nothing is transferred then.
+func copyBlob(src, dst *registryV2.Registry, srcRepo, dstRepo string, dgst digest.Digest, opts *CopyOptions) (int64, error) {
+	exists, err := dst.HasLayer(dstRepo, dgst)
+	if err != nil {
+		return 0, pushError("check layer %s fails, error:%s", dgst, err)
+	}
+	if exists {
+		glog.V(6).Infof("layer %s exists in %s, skip it\n", dgst, dstRepo)
+		return 0, nil
+	}
+
+	var location *url.URL
+	if src.URL == dst.URL && srcRepo != dstRepo {
+		mounted, loc, err := mountBlob(dst, dstRepo, srcRepo, dgst)
+		switch {
+		case err != nil:
+			glog.Warningf("mount layer %s from %s to %s fails, upload it instead, error:%s\n", dgst, srcRepo, dstRepo, err)
+		case mounted:
+			glog.V(4).Infof("layer %s mounted from %s to %s\n", dgst, srcRepo, dstRepo)
+			return 0, nil
+		default:
+			// the refused mount has started an upload already
+			glog.V(4).Infof("mount of layer %s from %s to %s is refused, upload it\n", dgst, srcRepo, dstRepo)
+			location = loc
+		}
+	}
+	return uploadBlob(src, dst, srcRepo, dstRepo, dgst, location, opts)
+}
+
+func resignManifest(sm *manifest.SignedManifest, name, tag string) (*manifest.SignedManifest, error) {
+	signingKeyOnce.Do(func() {
+		signingKey, signingKeyErr = libtrust.GenerateECP256PrivateKey()
+	})
+	if signingKeyErr != nil {
+		return nil, signingKeyErr
+	}
+
+	m := sm.Manifest
+	m.Name = name
+	m.Tag = tag
+	return manifest.Sign(&m, signingKey)
+}
+
+// repoPath returns the full repository name used by registry v2 api,
+// official images on docker hub live under library/
+func (c *Client) repoPath(repo string) string {
+	repo = strings.Trim(repo, "/")
+	if c.isHub && !strings.Contains(repo, "/") {
+		return "library/" + repo
+	}
+	return repo
+}
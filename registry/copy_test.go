@@ -0,0 +1,101 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/libtrust"
+)
+
+// pushSchema1Image stores a signed schema1 image with the given layers in r
+func pushSchema1Image(t *testing.T, r *fakeRegistry, repo, tag string, layers ...string) {
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatalf("generate key fails, error:%s\n", err)
+	}
+	m := manifest.Manifest{
+		Versioned: manifest.Versioned{SchemaVersion: 1},
+		Name:      repo,
+		Tag:       tag,
+	}
+	for _, layer := range layers {
+		dgst := r.addBlob(repo, []byte(layer))
+		m.FSLayers = append(m.FSLayers, manifest.FSLayer{BlobSum: digest.Digest(dgst)})
+		m.History = append(m.History, manifest.History{V1Compatibility: "{}"})
+	}
+	sm, err := manifest.Sign(&m, key)
+	if err != nil {
+		t.Fatalf("sign manifest fails, error:%s\n", err)
+	}
+	r.addManifest(repo, tag, manifest.ManifestMediaType, sm.Raw)
+}
+
+func TestCopyImage(t *testing.T) {
+	src := newFakeRegistry(t)
+	dst := newFakeRegistry(t)
+	pushSchema1Image(t, src, "library/busybox", "latest", "layer-a", "layer-b", "layer-a")
+
+	srcClient := src.client(t)
+	dstClient := dst.client(t)
+	result, err := CopyImage(srcClient, dstClient, "library/busybox", "docker_library/busybox", "latest", nil)
+	if err != nil {
+		t.Fatalf("copy image should succeed, error:%s\n", err)
+	}
+	if result.Bytes != int64(len("layer-a")+len("layer-b")) {
+		t.Errorf("bytes transferred should be %d, got %d\n", len("layer-a")+len("layer-b"), result.Bytes)
+	}
+
+	for _, layer := range []string{"layer-a", "layer-b"} {
+		if !dst.hasBlob(fakeDigest([]byte(layer))) {
+			t.Errorf("layer %s should be copied to destination\n", layer)
+		}
+	}
+	if dst.blobUploads != 2 {
+		t.Errorf("duplicated layers should be uploaded once, uploads:%d\n", dst.blobUploads)
+	}
+
+	m, ok := dst.manifest("docker_library/busybox", "latest")
+	if !ok {
+		t.Fatalf("manifest should be put to destination\n")
+	}
+	var sm manifest.SignedManifest
+	if err := sm.UnmarshalJSON(m.body); err != nil {
+		t.Fatalf("invalid manifest at destination, error:%s\n", err)
+	}
+	if digest, _ := dstClient.ManifestDigest("docker_library/busybox", "latest"); digest != result.Digest {
+		t.Errorf("digest should be %s, got %s\n", digest, result.Digest)
+	}
+	if sm.Name != "docker_library/busybox" {
+		t.Errorf("manifest name should be docker_library/busybox, is %s\n", sm.Name)
+	}
+	if _, err := manifest.Verify(&sm); err != nil {
+		t.Errorf("manifest signature should be valid, error:%s\n", err)
+	}
+
+	// layers exist at destination now, copy again uploads nothing
+	result, err = CopyImage(srcClient, dstClient, "library/busybox", "docker_library/busybox", "latest", nil)
+	if err != nil {
+		t.Fatalf("copy image again should succeed, error:%s\n", err)
+	}
+	if result.Bytes != 0 {
+		t.Errorf("no bytes should be transferred, got %d\n", result.Bytes)
+	}
+	if dst.blobUploads != 2 {
+		t.Errorf("existing layers should not be uploaded again, uploads:%d\n", dst.blobUploads)
+	}
+}
+
+func TestCopyImageNotFound(t *testing.T) {
+	src := newFakeRegistry(t)
+	dst := newFakeRegistry(t)
+
+	notFound := apiErrors.Value(src.host(), "404")
+	_, err := CopyImage(src.client(t), dst.client(t), "library/notfound", "docker_library/notfound", "latest", nil)
+	if copyErr, ok := err.(*CopyError); !ok || copyErr.Op != "pull" {
+		t.Errorf("copy image not found should fail to pull, error:%v\n", err)
+	}
+	if n := apiErrors.Value(src.host(), "404") - notFound; n < 1 {
+		t.Errorf("404 of the source registry should be counted, got %v\n", n)
+	}
+}
@@ -0,0 +1,71 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/distribution/digest"
+)
+
+// imageConfig holds the fields of an image config read by ImageCreated
+type imageConfig struct {
+	Created time.Time `json:"created"`
+}
+
+// ImageCreated returns the time image repo:reference was created, read from
+// its image config, or the config of the first image of a manifest list.
+// It is zero if the image config does not tell.
+func (c *Client) ImageCreated(repo, reference string) (time.Time, error) {
+	if c.RegClientV2 == nil {
+		return time.Time{}, errors.New("image created time requires registry v2 api")
+	}
+	repo = c.repoPath(repo)
+	m, err := getManifest(c.RegClientV2, repo, reference, acceptManifestTypes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if m.IsList() {
+		l, err := m.List()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid manifest list %s of %s, error:%s", m.Digest, repo, err)
+		}
+		if len(l.Manifests) == 0 {
+			return time.Time{}, nil
+		}
+		if m, err = getManifest(c.RegClientV2, repo, l.Manifests[0].Digest, acceptImageManifestTypes); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	var config imageConfig
+	if m.IsSchema1() {
+		sm, err := m.Schema1()
+		if err != nil {
+			return time.Time{}, err
+		}
+		// the first history entry is the config of the image
+		if len(sm.History) == 0 {
+			return time.Time{}, nil
+		}
+		if err := json.Unmarshal([]byte(sm.History[0].V1Compatibility), &config); err != nil {
+			return time.Time{}, fmt.Errorf("invalid history of %s, error:%s", m.Digest, err)
+		}
+		return config.Created, nil
+	}
+
+	image, err := m.Image()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid manifest %s of %s, error:%s", m.Digest, repo, err)
+	}
+	body, err := openBlob(c.RegClientV2, repo, digest.Digest(image.Config.Digest), 0)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(&config); err != nil {
+		return time.Time{}, fmt.Errorf("invalid image config %s, error:%s", image.Config.Digest, err)
+	}
+	return config.Created, nil
+}
@@ -0,0 +1,31 @@
+package registry
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestImageCreated(t *testing.T) {
+	r := newFakeRegistry(t)
+	c := r.client(t)
+	created := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+
+	config := r.addBlob("library/busybox", []byte(`{"architecture":"amd64","created":"2024-03-15T10:00:00Z"}`))
+	body := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"digest":%q},"layers":[]}`, MediaTypeManifest, config)
+	dgst := r.addManifest("library/busybox", "1.36", MediaTypeManifest, []byte(body))
+	if got, err := c.ImageCreated("library/busybox", "1.36"); err != nil || !got.Equal(created) {
+		t.Errorf("image should be created at %s, got %s, error:%v\n", created, got, err)
+	}
+
+	list := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"manifests":[{"digest":%q}]}`, MediaTypeManifestList, dgst)
+	r.addManifest("library/busybox", "latest", MediaTypeManifestList, []byte(list))
+	if got, err := c.ImageCreated("library/busybox", "latest"); err != nil || !got.Equal(created) {
+		t.Errorf("manifest list should take the created time of its first image, got %s, error:%v\n", got, err)
+	}
+
+	pushSchema1Image(t, r, "library/busybox", "1.0", "layer-a")
+	if got, err := c.ImageCreated("library/busybox", "1.0"); err != nil || !got.IsZero() {
+		t.Errorf("schema1 image without created time should be zero, got %s, error:%v\n", got, err)
+	}
+}
@@ -0,0 +1,160 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/docker/distribution/digest"
+
+	registryV2 "github.com/oscarzhao/docker-registry-client/registry"
+)
+
+// EstimateCopy returns the bytes CopyImage would transfer to copy srcRepo:tag
+// in src to dstRepo:tag in dst, blobs dst has already are not counted. Blobs
+// mounted within one registry are counted, since the blob is uploaded if the
+// mount is refused. Nothing is written to dst.
+func EstimateCopy(src, dst *Client, srcRepo, dstRepo, tag string, opts *CopyOptions) (int64, error) {
+	if src.RegClientV2 == nil || dst.RegClientV2 == nil {
+		return 0, errors.New("estimate copy requires registry v2 api at both source and destination")
+	}
+	srcRepo = src.repoPath(srcRepo)
+	dstRepo = dst.repoPath(dstRepo)
+
+	m, err := getManifest(src.RegClientV2, srcRepo, tag, acceptManifestTypes)
+	if err != nil {
+		return 0, fmt.Errorf("get manifest of %s:%s fails, error:%s", srcRepo, tag, err)
+	}
+	manifests := []*Manifest{m}
+	if m.IsList() {
+		if manifests, err = platformManifests(src, srcRepo, m, opts); err != nil {
+			return 0, err
+		}
+	}
+
+	// sizes of blobs by digest, -1 if the manifest does not tell
+	sizes := make(map[digest.Digest]int64)
+	var order []digest.Digest
+	for _, m := range manifests {
+		blobs, err := manifestBlobSizes(m)
+		if err != nil {
+			return 0, fmt.Errorf("invalid manifest %s of %s, error:%s", m.Digest, srcRepo, err)
+		}
+		for _, b := range blobs {
+			if _, ok := sizes[b.dgst]; !ok {
+				order = append(order, b.dgst)
+			}
+			sizes[b.dgst] = b.size
+		}
+	}
+
+	var total int64
+	for _, dgst := range order {
+		exists, err := dst.RegClientV2.HasLayer(dstRepo, dgst)
+		if err != nil {
+			return 0, fmt.Errorf("check layer %s fails, error:%s", dgst, err)
+		}
+		if exists {
+			continue
+		}
+		size := sizes[dgst]
+		if size < 0 {
+			if size, err = blobSize(src.RegClientV2, srcRepo, dgst); err != nil {
+				return 0, fmt.Errorf("get size of layer %s fails, error:%s", dgst, err)
+			}
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// platformManifests returns the image manifests of the platforms selected by
+// opts referenced by list
+func platformManifests(src *Client, srcRepo string, list *Manifest, opts *CopyOptions) ([]*Manifest, error) {
+	l, err := list.List()
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest list %s of %s, error:%s", list.Digest, srcRepo, err)
+	}
+	platforms, err := opts.platforms()
+	if err != nil {
+		return nil, err
+	}
+	var manifests []*Manifest
+	for _, desc := range l.Manifests {
+		if !platformSelected(desc.Platform, platforms) {
+			continue
+		}
+		m, err := getManifest(src.RegClientV2, srcRepo, desc.Digest, acceptImageManifestTypes)
+		if err != nil {
+			return nil, fmt.Errorf("get manifest %s@%s fails, error:%s", srcRepo, desc.Digest, err)
+		}
+		manifests = append(manifests, m)
+	}
+	if len(manifests) == 0 {
+		return nil, fmt.Errorf("no platform of %s@%s matches %v", srcRepo, list.Digest, platforms)
+	}
+	return manifests, nil
+}
+
+type blobSizeInfo struct {
+	dgst digest.Digest
+	size int64
+}
+
+// manifestBlobSizes returns the blobs referenced by image manifest m with
+// their sizes, sizes of schema1 layers are -1 since the manifest has none
+func manifestBlobSizes(m *Manifest) ([]blobSizeInfo, error) {
+	if m.IsSchema1() {
+		blobs, err := m.Blobs()
+		if err != nil {
+			return nil, err
+		}
+		res := make([]blobSizeInfo, 0, len(blobs))
+		for _, b := range blobs {
+			res = append(res, blobSizeInfo{digest.Digest(b), -1})
+		}
+		return res, nil
+	}
+
+	image, err := m.Image()
+	if err != nil {
+		return nil, err
+	}
+	res := []blobSizeInfo{{digest.Digest(image.Config.Digest), image.Config.Size}}
+	for _, layer := range image.Layers {
+		if len(layer.URLs) == 0 {
+			res = append(res, blobSizeInfo{digest.Digest(layer.Digest), layer.Size})
+		}
+	}
+	return res, nil
+}
+
+// blobSize returns the size of blob dgst of repo, reported by a HEAD request
+func blobSize(reg *registryV2.Registry, repo string, dgst digest.Digest) (int64, error) {
+	req, err := http.NewRequest("HEAD", reg.URL+fmt.Sprintf("/v2/%s/blobs/%s", repo, dgst), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := reg.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+// platforms parses the platforms selected by o
+func (o *CopyOptions) platforms() ([]Platform, error) {
+	if o == nil {
+		return nil, nil
+	}
+	var platforms []Platform
+	for _, s := range o.Platforms {
+		p, err := ParsePlatform(s)
+		if err != nil {
+			return nil, err
+		}
+		platforms = append(platforms, p)
+	}
+	return platforms, nil
+}
@@ -0,0 +1,42 @@
+package registry
+
+import (
+	"testing"
+)
+
+func TestEstimateCopy(t *testing.T) {
+	src := newFakeRegistry(t)
+	amd64 := pushSchema2Image(t, src, "library/alpine", "linux/amd64", "base", "amd64-layer")
+	arm64 := pushSchema2Image(t, src, "library/alpine", "linux/arm64", "base", "arm64-layer")
+	pushManifestList(t, src, "library/alpine", "3.4", amd64, arm64)
+	pushSchema1Image(t, src, "library/busybox", "latest", "layer-a", "layer-b", "layer-a")
+
+	dst := newFakeRegistry(t)
+	dst.addBlob("docker_library/alpine", []byte("base"))
+	testCases := []struct {
+		name      string
+		dst       *fakeRegistry
+		repo      string
+		tag       string
+		platforms []string
+		expected  int
+	}{
+		{"manifest list", dst, "alpine", "3.4", nil, len("amd64-layer") + len("arm64-layer")},
+		{"platform selected", dst, "alpine", "3.4", []string{"linux/arm64"}, len("arm64-layer")},
+		{"schema1 sizes from the source", dst, "busybox", "latest", nil, len("layer-a") + len("layer-b")},
+		{"mount may be refused in the same registry", src, "alpine", "3.4", nil, len("base") + len("amd64-layer") + len("arm64-layer")},
+	}
+	for _, tc := range testCases {
+		n, err := EstimateCopy(src.client(t), tc.dst.client(t), "library/"+tc.repo, "docker_library/"+tc.repo, tc.tag, &CopyOptions{Platforms: tc.platforms})
+		if err != nil {
+			t.Errorf("%s: estimate should succeed, error:%s\n", tc.name, err)
+			continue
+		}
+		if n != int64(tc.expected) {
+			t.Errorf("%s: expected %d bytes, got %d\n", tc.name, tc.expected, n)
+		}
+	}
+	if dst.blobUploads != 0 || dst.uploadStarts != 0 {
+		t.Errorf("estimate should not write to the destination, uploads:%d\n", dst.blobUploads)
+	}
+}
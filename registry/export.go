@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/golang/glog"
+)
+
+// ImageWriter stores images outside registries, such as an OCI image layout,
+// implementations must be safe for concurrent use
+type ImageWriter interface {
+	// HasBlob returns true if blob dgst is stored already
+	HasBlob(dgst string) bool
+	// WriteBlob stores blob dgst of size bytes read from r, the content is verified
+	WriteBlob(dgst string, size int64, r io.Reader) error
+	// Tag names m as ref, m and images are stored already, images are m or
+	// the image manifests m lists
+	Tag(ref string, m *Manifest, images []*Manifest) error
+}
+
+// ExportImage writes image repo:tag of src with its blobs to w, named ref.
+// Blobs w has already are not read. Manifest lists and OCI indexes are written
+// with the images of all platforms, or the platforms in opts. Schema1 images
+// cannot be exported.
+func ExportImage(src *Client, repo, tag, ref string, w ImageWriter, opts *CopyOptions) (*CopyResult, error) {
+	if src.RegClientV2 == nil {
+		return nil, errors.New("export image requires registry v2 api at the source")
+	}
+	repo = src.repoPath(repo)
+
+	m, err := getManifest(src.RegClientV2, repo, tag, acceptManifestTypes)
+	if err != nil {
+		return nil, pullError("get manifest of %s:%s fails, error:%s", repo, tag, err)
+	}
+	images := []*Manifest{m}
+	if m.IsList() {
+		descs, err := selectPlatforms(m, opts)
+		if err != nil {
+			return nil, pullError("select platforms of %s:%s fails, error:%s", repo, tag, err)
+		}
+		images = images[:0]
+		for _, desc := range descs {
+			image, err := getManifest(src.RegClientV2, repo, desc.Digest, acceptImageManifestTypes)
+			if err != nil {
+				return nil, pullError("get manifest %s@%s fails, error:%s", repo, desc.Digest, err)
+			}
+			images = append(images, image)
+		}
+	}
+
+	result := &CopyResult{Digest: m.Digest}
+	for _, image := range images {
+		if image.IsSchema1() {
+			return nil, pullError("%s:%s is a schema1 image, which cannot be exported", repo, tag)
+		}
+		n, err := exportManifest(src, repo, image, w)
+		if err != nil {
+			return nil, err
+		}
+		result.Bytes += n
+	}
+	if m.IsList() {
+		if err := writeManifest(w, m); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Tag(ref, m, images); err != nil {
+		return nil, pushError("tag %s as %s fails, error:%s", m.Digest, ref, err)
+	}
+	glog.V(4).Infof("image %s:%s exported as %s, media type:%s, bytes:%d\n", repo, tag, ref, m.MediaType, result.Bytes)
+	return result, nil
+}
+
+// exportManifest writes image manifest m of repo and its blobs to w, and
+// returns the bytes of blobs written
+func exportManifest(src *Client, repo string, m *Manifest, w ImageWriter) (int64, error) {
+	blobs, err := manifestBlobSizes(m)
+	if err != nil {
+		return 0, pullError("invalid manifest %s of %s, error:%s", m.Digest, repo, err)
+	}
+	var total int64
+	for _, b := range blobs {
+		if w.HasBlob(b.dgst.String()) {
+			continue
+		}
+		body, err := openBlob(src.RegClientV2, repo, b.dgst, 0)
+		if err != nil {
+			return 0, pullError("get layer %s of %s fails, error:%s", b.dgst, repo, err)
+		}
+		err = w.WriteBlob(b.dgst.String(), b.size, body)
+		body.Close()
+		if err != nil {
+			return 0, pushError("write layer %s fails, error:%s", b.dgst, err)
+		}
+		total += b.size
+	}
+	return total, writeManifest(w, m)
+}
+
+// writeManifest writes m to w as a blob
+func writeManifest(w ImageWriter, m *Manifest) error {
+	if w.HasBlob(m.Digest) {
+		return nil
+	}
+	if err := w.WriteBlob(m.Digest, int64(len(m.Raw)), bytes.NewReader(m.Raw)); err != nil {
+		return pushError("write manifest %s fails, error:%s", m.Digest, err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package registry
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+// memoryWriter is an ImageWriter keeping images in memory
+type memoryWriter struct {
+	mu     sync.Mutex
+	blobs  map[string][]byte
+	tags   map[string]string
+	images map[string]int
+	writes int
+}
+
+func newMemoryWriter() *memoryWriter {
+	return &memoryWriter{blobs: make(map[string][]byte), tags: make(map[string]string), images: make(map[string]int)}
+}
+
+func (w *memoryWriter) HasBlob(dgst string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.blobs[dgst]
+	return ok
+}
+
+func (w *memoryWriter) WriteBlob(dgst string, size int64, r io.Reader) error {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.blobs[dgst] = content
+	w.writes++
+	return nil
+}
+
+func (w *memoryWriter) Tag(ref string, m *Manifest, images []*Manifest) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.tags[ref] = m.Digest
+	w.images[ref] = len(images)
+	return nil
+}
+
+func TestExportImage(t *testing.T) {
+	src := newFakeRegistry(t)
+	srcClient := src.client(t)
+	amd64 := pushSchema2Image(t, src, "library/busybox", "linux/amd64", "layer-a", "layer-b")
+	arm64 := pushSchema2Image(t, src, "library/busybox", "linux/arm64", "layer-a", "layer-c")
+	list := pushManifestList(t, src, "library/busybox", "latest", amd64, arm64)
+
+	w := newMemoryWriter()
+	result, err := ExportImage(srcClient, "library/busybox", "latest", "docker_library/busybox:latest", w, nil)
+	if err != nil {
+		t.Fatalf("export image should succeed, error:%s\n", err)
+	}
+	if result.Digest != list || w.tags["docker_library/busybox:latest"] != list || w.images["docker_library/busybox:latest"] != 2 {
+		t.Errorf("manifest list %s should be tagged with 2 images, got %v, %v\n", list, w.tags, w.images)
+	}
+	// 2 configs, 3 layers, 2 manifests and the list
+	if len(w.blobs) != 8 {
+		t.Errorf("shared layers should be written once, expected 8 blobs, got %d\n", len(w.blobs))
+	}
+	if !bytes.Equal(w.blobs[list], mustManifest(t, src, "library/busybox", "latest")) {
+		t.Errorf("manifest list should be written byte for byte\n")
+	}
+	// sizes of blobs are taken from the manifests, the fake configs have none
+	if result.Bytes != int64(len("layer-a")+len("layer-b")+len("layer-c")) {
+		t.Errorf("bytes exported is wrong, got %d\n", result.Bytes)
+	}
+
+	// blobs written already are not written again
+	writes := w.writes
+	if _, err := ExportImage(srcClient, "library/busybox", "latest", "docker_library/busybox:1.36", w, &CopyOptions{Platforms: []string{"linux/arm64"}}); err != nil {
+		t.Fatalf("export image should succeed, error:%s\n", err)
+	}
+	if w.writes != writes+1 || w.images["docker_library/busybox:1.36"] != 1 {
+		t.Errorf("only the list of the selected platform should be written, writes:%d, images:%d\n", w.writes-writes, w.images["docker_library/busybox:1.36"])
+	}
+
+	pushSchema1Image(t, src, "library/alpine", "3.4", "layer-a")
+	if _, err := ExportImage(srcClient, "library/alpine", "3.4", "docker_library/alpine:3.4", w, nil); err == nil {
+		t.Errorf("schema1 image should not be exported\n")
+	}
+}
+
+func mustManifest(t *testing.T, r *fakeRegistry, repo, ref string) []byte {
+	m, ok := r.manifest(repo, ref)
+	if !ok {
+		t.Fatalf("manifest %s:%s not found\n", repo, ref)
+	}
+	return m.body
+}
@@ -0,0 +1,442 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/libtrust"
+
+	"github.com/oscarzhao/image-sync/retry"
+)
+
+func init() {
+	// failures injected by the fake registry are retried at once
+	retry.Default.BaseDelay = 0
+}
+
+var (
+	fakeBlobPathRegexp     = regexp.MustCompile(`^/v2/(.+)/blobs/(sha256:[0-9a-f]+)$`)
+	fakeUploadPathRegexp   = regexp.MustCompile(`^/v2/(.+)/blobs/uploads/(\w*)$`)
+	fakeManifestPathRegexp = regexp.MustCompile(`^/v2/(.+)/manifests/(.+)$`)
+	fakeTagsPathRegexp     = regexp.MustCompile(`^/v2/(.+)/tags/list$`)
+)
+
+type fakeManifest struct {
+	mediaType string
+	body      []byte
+}
+
+// fakeRegistry is an in-memory registry v2 server, good enough for tests
+type fakeRegistry struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	blobs     map[string][]byte            // digest -> content
+	links     map[string]bool              // repo@digest -> blob is in repo
+	manifests map[string]map[string]string // repo -> tag -> digest
+	contents  map[string]fakeManifest      // digest -> manifest
+	uploads   map[string]*bytes.Buffer
+	nextID    int
+
+	// counters of requests
+	blobUploads  int
+	blobMounts   int
+	blobPatches  int
+	uploadStarts int
+
+	// failPatches makes the next PATCH requests fail with status 500 after
+	// storing half of the chunk, shortPatches makes them succeed after storing
+	// half of the chunk, truncateBlobs makes the next blob downloads end after
+	// half of the content
+	failPatches   int
+	shortPatches  int
+	truncateBlobs int
+
+	// noMount makes the registry refuse cross repo mounts
+	noMount bool
+
+	// failAfter makes list requests fail with status 500 if the query parameter last equals it
+	failAfter string
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	r := &fakeRegistry{
+		blobs:     make(map[string][]byte),
+		links:     make(map[string]bool),
+		manifests: make(map[string]map[string]string),
+		contents:  make(map[string]fakeManifest),
+		uploads:   make(map[string]*bytes.Buffer),
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// host returns host:port of the fake registry
+func (r *fakeRegistry) host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+func (r *fakeRegistry) client(t *testing.T) *Client {
+	c, err := NewClient("http", r.host(), "v2", "", "")
+	if err != nil {
+		t.Fatalf("create client of fake registry fails, error:%s\n", err)
+	}
+	return c
+}
+
+func fakeDigest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// fakeManifestDigest returns the digest of a manifest, like docker distribution
+// schema1 manifests are identified by their payload without signatures
+func fakeManifestDigest(mediaType string, body []byte) string {
+	if strings.HasPrefix(mediaType, "application/vnd.docker.distribution.manifest.v1") {
+		if jsig, err := libtrust.ParsePrettySignature(body, "signatures"); err == nil {
+			if payload, err := jsig.Payload(); err == nil {
+				return fakeDigest(payload)
+			}
+		}
+	}
+	return fakeDigest(body)
+}
+
+func (r *fakeRegistry) addBlob(repo string, content []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dgst := fakeDigest(content)
+	r.blobs[dgst] = content
+	r.links[repo+"@"+dgst] = true
+	return dgst
+}
+
+func (r *fakeRegistry) hasBlob(dgst string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.blobs[dgst]
+	return ok
+}
+
+func (r *fakeRegistry) addManifest(repo, tag, mediaType string, body []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dgst := fakeManifestDigest(mediaType, body)
+	r.contents[dgst] = fakeManifest{mediaType: mediaType, body: body}
+	if tag != "" {
+		if r.manifests[repo] == nil {
+			r.manifests[repo] = make(map[string]string)
+		}
+		r.manifests[repo][tag] = dgst
+	}
+	return dgst
+}
+
+func (r *fakeRegistry) manifest(repo, ref string) (fakeManifest, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if dgst, ok := r.manifests[repo][ref]; ok {
+		ref = dgst
+	}
+	m, ok := r.contents[ref]
+	return m, ok
+}
+
+// deleteManifest untags all tags of repo referring to dgst, it returns
+// false if none does
+func (r *fakeRegistry) deleteManifest(repo, dgst string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	found := false
+	for tag, d := range r.manifests[repo] {
+		if d == dgst {
+			delete(r.manifests[repo], tag)
+			found = true
+		}
+	}
+	return found
+}
+
+func (r *fakeRegistry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	path := req.URL.Path
+	switch {
+	case path == "/v2/":
+		w.WriteHeader(http.StatusOK)
+	case path == "/v2/_catalog":
+		r.serveCatalog(w, req)
+	case fakeUploadPathRegexp.MatchString(path):
+		m := fakeUploadPathRegexp.FindStringSubmatch(path)
+		r.serveUpload(w, req, m[1], m[2])
+	case fakeBlobPathRegexp.MatchString(path):
+		m := fakeBlobPathRegexp.FindStringSubmatch(path)
+		r.serveBlob(w, req, m[1], m[2])
+	case fakeManifestPathRegexp.MatchString(path):
+		m := fakeManifestPathRegexp.FindStringSubmatch(path)
+		r.serveManifest(w, req, m[1], m[2])
+	case fakeTagsPathRegexp.MatchString(path):
+		m := fakeTagsPathRegexp.FindStringSubmatch(path)
+		r.serveTags(w, req, m[1])
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (r *fakeRegistry) serveBlob(w http.ResponseWriter, req *http.Request, repo, dgst string) {
+	r.mu.Lock()
+	content, ok := r.blobs[dgst]
+	ok = ok && r.links[repo+"@"+dgst]
+	truncate := req.Method == "GET" && r.truncateBlobs > 0
+	if truncate {
+		r.truncateBlobs--
+	}
+	r.mu.Unlock()
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Docker-Content-Digest", dgst)
+	if truncate {
+		// the connection is closed as the body is shorter than Content-Length
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		w.Write(content[:len(content)/2])
+		return
+	}
+	http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(content))
+}
+
+func (r *fakeRegistry) serveUpload(w http.ResponseWriter, req *http.Request, repo, id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch req.Method {
+	case "POST":
+		if dgst, from := req.URL.Query().Get("mount"), req.URL.Query().Get("from"); dgst != "" && !r.noMount && r.links[from+"@"+dgst] {
+			r.links[repo+"@"+dgst] = true
+			r.blobMounts++
+			w.Header().Set("Location", fmt.Sprintf("%s/v2/%s/blobs/%s", r.URL, repo, dgst))
+			w.Header().Set("Docker-Content-Digest", dgst)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		r.nextID++
+		r.uploadStarts++
+		id = fmt.Sprint("u", r.nextID)
+		r.uploads[id] = &bytes.Buffer{}
+		w.Header().Set("Location", fmt.Sprintf("%s/v2/%s/blobs/uploads/%s", r.URL, repo, id))
+		w.WriteHeader(http.StatusAccepted)
+	case "PUT":
+		buf, ok := r.uploads[id]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		buf.Write(body)
+		dgst := req.URL.Query().Get("digest")
+		if fakeDigest(buf.Bytes()) != dgst {
+			http.Error(w, "digest invalid", http.StatusBadRequest)
+			return
+		}
+		r.blobs[dgst] = buf.Bytes()
+		r.links[repo+"@"+dgst] = true
+		delete(r.uploads, id)
+		r.blobUploads++
+		w.Header().Set("Docker-Content-Digest", dgst)
+		w.WriteHeader(http.StatusCreated)
+	case "GET":
+		buf, ok := r.uploads[id]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Range", fakeRange(buf.Len()))
+		w.WriteHeader(http.StatusNoContent)
+	case "PATCH":
+		buf, ok := r.uploads[id]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		if start := strings.SplitN(req.Header.Get("Content-Range"), "-", 2)[0]; start != fmt.Sprint(buf.Len()) {
+			http.Error(w, "range invalid", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if r.failPatches > 0 {
+			r.failPatches--
+			buf.Write(body[:len(body)/2])
+			http.Error(w, "connection lost", http.StatusInternalServerError)
+			return
+		}
+		if r.shortPatches > 0 {
+			r.shortPatches--
+			body = body[:len(body)/2]
+		} else {
+			r.blobPatches++
+		}
+		buf.Write(body)
+		w.Header().Set("Location", fmt.Sprintf("%s/v2/%s/blobs/uploads/%s", r.URL, repo, id))
+		w.Header().Set("Range", fakeRange(buf.Len()))
+		w.WriteHeader(http.StatusAccepted)
+	case "DELETE":
+		if _, ok := r.uploads[id]; !ok {
+			http.NotFound(w, req)
+			return
+		}
+		delete(r.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *fakeRegistry) serveManifest(w http.ResponseWriter, req *http.Request, repo, ref string) {
+	switch req.Method {
+	case "GET", "HEAD":
+		m, ok := r.manifest(repo, ref)
+		if !ok || !fakeAccepts(req, m.mediaType) {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", fakeManifestDigest(m.mediaType, m.body))
+		w.Header().Set("Content-Length", fmt.Sprint(len(m.body)))
+		if req.Method == "GET" {
+			w.Write(m.body)
+		}
+	case "PUT":
+		body, _ := ioutil.ReadAll(req.Body)
+		tag := ref
+		if strings.HasPrefix(ref, "sha256:") {
+			tag = ""
+		}
+		dgst := r.addManifest(repo, tag, req.Header.Get("Content-Type"), body)
+		w.Header().Set("Docker-Content-Digest", dgst)
+		w.WriteHeader(http.StatusCreated)
+	case "DELETE":
+		if !r.deleteManifest(repo, ref) {
+			http.NotFound(w, req)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// fakeAccepts returns true if mediaType is in the Accept header of req, or no Accept
+// header is sent. Unlike docker distribution, no manifest is converted to schema1.
+func fakeAccepts(req *http.Request, mediaType string) bool {
+	accept := req.Header.Get("Accept")
+	if accept == "" {
+		return true
+	}
+	for _, t := range strings.Split(accept, ",") {
+		if strings.TrimSpace(t) == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *fakeRegistry) serveTags(w http.ResponseWriter, req *http.Request, repo string) {
+	r.mu.Lock()
+	var tags []string
+	for tag := range r.manifests[repo] {
+		tags = append(tags, tag)
+	}
+	r.mu.Unlock()
+	if tags == nil {
+		http.NotFound(w, req)
+		return
+	}
+	sort.Strings(tags)
+	if r.listFails(w, req) {
+		return
+	}
+
+	page, next := fakePage(tags, req)
+	if next != "" {
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?last=%s&n=%s>; rel="next"`, repo, next, req.URL.Query().Get("n")))
+	}
+	body, _ := json.Marshal(map[string]interface{}{"name": repo, "tags": page})
+	w.Write(body)
+}
+
+func (r *fakeRegistry) setFailAfter(last string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failAfter = last
+}
+
+// listFails writes an error if the list request should fail, see failAfter
+func (r *fakeRegistry) listFails(w http.ResponseWriter, req *http.Request) bool {
+	r.mu.Lock()
+	failAfter := r.failAfter
+	r.mu.Unlock()
+	if failAfter != "" && req.URL.Query().Get("last") == failAfter {
+		http.Error(w, "list fails", http.StatusInternalServerError)
+		return true
+	}
+	return false
+}
+
+// serveCatalog lists repos in lexical order, paginated by n and last like docker distribution
+func (r *fakeRegistry) serveCatalog(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	var repos []string
+	for repo := range r.manifests {
+		repos = append(repos, repo)
+	}
+	r.mu.Unlock()
+	sort.Strings(repos)
+	if r.listFails(w, req) {
+		return
+	}
+
+	page, next := fakePage(repos, req)
+	if next != "" {
+		w.Header().Set("Link", fmt.Sprintf(`</v2/_catalog?last=%s&n=%s>; rel="next"`, next, req.URL.Query().Get("n")))
+	}
+	body, _ := json.Marshal(map[string][]string{"repositories": page})
+	w.Write(body)
+}
+
+// fakePage returns the entries after the query parameter last, at most n of them,
+// and the last entry returned if more entries follow
+func fakePage(entries []string, req *http.Request) ([]string, string) {
+	if last := req.URL.Query().Get("last"); last != "" {
+		i := sort.SearchStrings(entries, last)
+		if i < len(entries) && entries[i] == last {
+			i++
+		}
+		entries = entries[i:]
+	}
+	n, err := strconv.Atoi(req.URL.Query().Get("n"))
+	if err != nil || n <= 0 || n >= len(entries) {
+		return entries, ""
+	}
+	return entries[:n], entries[n-1]
+}
+
+// fakeRange returns Range header of an upload session which has received n bytes
+func fakeRange(n int) string {
+	if n == 0 {
+		return "0-0"
+	}
+	return fmt.Sprintf("0-%d", n-1)
+}
@@ -0,0 +1,150 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/docker/distribution/digest"
+	"github.com/golang/glog"
+)
+
+// ImageReader reads images stored outside registries, such as an OCI image
+// layout, implementations must be safe for concurrent use
+type ImageReader interface {
+	// OpenBlob reads blob dgst from offset
+	OpenBlob(dgst string, offset int64) (io.ReadCloser, error)
+}
+
+// ImportImage puts image manifest, manifest list or OCI index m read from r to
+// repo:tag of dst with its blobs. Blobs dst has already are not uploaded, and
+// blobs in opts.BlobRepos are mounted.
+// Manifest lists are imported with the images of all platforms, or the
+// platforms in opts, the images must be in r.
+func ImportImage(r ImageReader, m *Manifest, dst *Client, repo, tag string, opts *CopyOptions) (*CopyResult, error) {
+	if dst.RegClientV2 == nil {
+		return nil, errors.New("import image requires registry v2 api at the destination")
+	}
+	repo = dst.repoPath(repo)
+
+	images := []*Manifest{m}
+	if m.IsList() {
+		// unselected platforms are removed from a copy, m is kept for other tags
+		m = &Manifest{MediaType: m.MediaType, Raw: m.Raw, Digest: m.Digest}
+		descs, err := selectPlatforms(m, opts)
+		if err != nil {
+			return nil, pullError("select platforms of %s fails, error:%s", m.Digest, err)
+		}
+		images = images[:0]
+		for _, desc := range descs {
+			image, err := ReadManifest(r, desc.Digest, desc.MediaType)
+			if err != nil {
+				return nil, pullError("read manifest %s fails, error:%s", desc.Digest, err)
+			}
+			images = append(images, image)
+		}
+	}
+
+	result := &CopyResult{Digest: m.Digest}
+	imported := make(map[digest.Digest]bool)
+	for _, image := range images {
+		if image.IsSchema1() {
+			return nil, pullError("manifest %s is schema1, which cannot be imported", image.Digest)
+		}
+		n, err := importManifestBlobs(r, image, dst, repo, imported, opts)
+		if err != nil {
+			return nil, err
+		}
+		result.Bytes += n
+		if m.IsList() {
+			if err := putManifest(dst.RegClientV2, repo, image.Digest, image); err != nil {
+				return nil, pushError("put manifest %s@%s fails, error:%s", repo, image.Digest, err)
+			}
+		}
+	}
+	if err := putManifest(dst.RegClientV2, repo, tag, m); err != nil {
+		return nil, pushError("put manifest of %s:%s fails, error:%s", repo, tag, err)
+	}
+	glog.V(4).Infof("image %s imported to %s:%s, media type:%s, bytes:%d\n", m.Digest, repo, tag, m.MediaType, result.Bytes)
+	return result, nil
+}
+
+// HasBlob returns true if repo has blob dgst
+func (c *Client) HasBlob(repo, dgst string) (bool, error) {
+	if c.RegClientV2 == nil {
+		return false, errors.New("check blob requires registry v2 api")
+	}
+	return c.RegClientV2.HasLayer(c.repoPath(repo), digest.Digest(dgst))
+}
+
+// ReadManifest reads manifest dgst from r, the media type is detected from
+// the content if it is empty
+func ReadManifest(r ImageReader, dgst, mediaType string) (*Manifest, error) {
+	body, err := r.OpenBlob(dgst, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	actual, err := digest.FromBytes(raw)
+	if err != nil {
+		return nil, err
+	}
+	if actual.String() != dgst {
+		return nil, fmt.Errorf("digest of manifest %s mismatches, got %s", dgst, actual)
+	}
+	if mediaType == "" {
+		mediaType = detectMediaType("", raw)
+	}
+	return &Manifest{MediaType: mediaType, Raw: raw, Digest: dgst}, nil
+}
+
+// importManifestBlobs uploads the blobs of image manifest m read from r to
+// repo of dst unless dst has them, blobs in imported are skipped, and blobs
+// uploaded are added to it
+func importManifestBlobs(r ImageReader, m *Manifest, dst *Client, repo string, imported map[digest.Digest]bool, opts *CopyOptions) (int64, error) {
+	blobs, err := manifestBlobSizes(m)
+	if err != nil {
+		return 0, pullError("invalid manifest %s, error:%s", m.Digest, err)
+	}
+	var total int64
+	for _, b := range blobs {
+		if imported[b.dgst] {
+			continue
+		}
+		exists, err := dst.RegClientV2.HasLayer(repo, b.dgst)
+		if err != nil {
+			return 0, pushError("check layer %s fails, error:%s", b.dgst, err)
+		}
+		if exists {
+			glog.V(6).Infof("layer %s exists in %s, skip it\n", b.dgst, repo)
+			imported[b.dgst] = true
+			continue
+		}
+		if from := opts.blobRepo(b.dgst); from != "" {
+			// a delta leaves out the blobs the destination has
+			mounted, _, err := mountBlob(dst.RegClientV2, repo, dst.repoPath(from), b.dgst)
+			if err != nil || !mounted {
+				return 0, pushError("layer %s is not in the archive, mount it from %s fails, mounted:%v, error:%v", b.dgst, from, mounted, err)
+			}
+			glog.V(4).Infof("layer %s mounted from %s to %s\n", b.dgst, from, repo)
+			imported[b.dgst] = true
+			continue
+		}
+		dgst := b.dgst.String()
+		open := func(offset int64) (io.ReadCloser, error) {
+			return r.OpenBlob(dgst, offset)
+		}
+		n, err := uploadBlobFrom(open, dst.RegClientV2, repo, b.dgst, nil, opts)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+		imported[b.dgst] = true
+	}
+	return total, nil
+}
@@ -0,0 +1,99 @@
+package registry
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// OpenBlob makes memoryWriter an ImageReader too
+func (w *memoryWriter) OpenBlob(dgst string, offset int64) (io.ReadCloser, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	content, ok := w.blobs[dgst]
+	if !ok {
+		return nil, errors.New("blob " + dgst + " not found")
+	}
+	return ioutil.NopCloser(bytes.NewReader(content[offset:])), nil
+}
+
+func TestImportImage(t *testing.T) {
+	src := newFakeRegistry(t)
+	amd64 := pushSchema2Image(t, src, "library/busybox", "linux/amd64", "layer-a", "layer-b")
+	arm64 := pushSchema2Image(t, src, "library/busybox", "linux/arm64", "layer-a", "layer-c")
+	list := pushManifestList(t, src, "library/busybox", "latest", amd64, arm64)
+	w := newMemoryWriter()
+	if _, err := ExportImage(src.client(t), "library/busybox", "latest", "docker_library/busybox:latest", w, nil); err != nil {
+		t.Fatalf("export image should succeed, error:%s\n", err)
+	}
+	m, err := ReadManifest(w, list, "")
+	if err != nil {
+		t.Fatalf("read manifest list fails, error:%s\n", err)
+	}
+	if !m.IsList() {
+		t.Errorf("media type of the list should be detected, got %s\n", m.MediaType)
+	}
+
+	dst := newFakeRegistry(t)
+	dst.addBlob("docker_library/busybox", []byte("layer-a"))
+	result, err := ImportImage(w, m, dst.client(t), "docker_library/busybox", "latest", nil)
+	if err != nil {
+		t.Fatalf("import image should succeed, error:%s\n", err)
+	}
+	if result.Digest != list {
+		t.Errorf("expected digest %s, got %s\n", list, result.Digest)
+	}
+	// 2 configs and 2 layers, layer-a exists already
+	if dst.blobUploads != 4 {
+		t.Errorf("blobs in the registry should not be uploaded, uploads:%d\n", dst.blobUploads)
+	}
+	if !bytes.Equal(mustManifest(t, dst, "docker_library/busybox", "latest"), mustManifest(t, src, "library/busybox", "latest")) {
+		t.Errorf("manifest list should be put byte for byte\n")
+	}
+	for _, image := range []string{amd64.Digest, arm64.Digest} {
+		if _, ok := dst.manifest("docker_library/busybox", image); !ok {
+			t.Errorf("image %s of the list is not imported\n", image)
+		}
+	}
+
+	if _, err := ReadManifest(w, amd64.Digest[:len(amd64.Digest)-1]+"0", ""); err == nil {
+		t.Errorf("missing manifest should not be read\n")
+	}
+}
+
+func TestImportImageBlobRepos(t *testing.T) {
+	src := newFakeRegistry(t)
+	alpine := pushSchema2Image(t, src, "library/alpine", "linux/amd64", "layer-a", "layer-c")
+	src.addManifest("library/alpine", "3.19", alpine.MediaType, mustManifest(t, src, "library/alpine", alpine.Digest))
+	w := newMemoryWriter()
+	if _, err := ExportImage(src.client(t), "library/alpine", "3.19", "docker_library/alpine:3.19", w, nil); err != nil {
+		t.Fatalf("export image should succeed, error:%s\n", err)
+	}
+	m, err := ReadManifest(w, alpine.Digest, "")
+	if err != nil {
+		t.Fatalf("read manifest fails, error:%s\n", err)
+	}
+	// a delta leaves out layer-a, which the destination has in another repo
+	layerA := fakeDigest([]byte("layer-a"))
+	delete(w.blobs, layerA)
+
+	dst := newFakeRegistry(t)
+	dst.addBlob("docker_library/busybox", []byte("layer-a"))
+	if _, err := ImportImage(w, m, dst.client(t), "docker_library/alpine", "3.19", nil); err == nil {
+		t.Errorf("import should fail without the repo holding the blob left out\n")
+	}
+	opts := &CopyOptions{BlobRepos: map[string]string{layerA: "docker_library/busybox"}}
+	if _, err := ImportImage(w, m, dst.client(t), "docker_library/alpine", "3.19", opts); err != nil {
+		t.Fatalf("import image should succeed, error:%s\n", err)
+	}
+	if dst.blobMounts != 1 {
+		t.Errorf("blob left out should be mounted, mounts:%d\n", dst.blobMounts)
+	}
+
+	opts.BlobRepos[layerA] = "docker_library/nginx"
+	if _, err := ImportImage(w, m, newFakeRegistry(t).client(t), "docker_library/alpine", "3.19", opts); err == nil {
+		t.Errorf("import should fail if the destination has not the blob left out\n")
+	}
+}
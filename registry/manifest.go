@@ -0,0 +1,367 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+
+	registryV2 "github.com/oscarzhao/docker-registry-client/registry"
+)
+
+const (
+	// MediaTypeManifestList is the media type of docker manifest lists
+	MediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	// MediaTypeImageIndex is the media type of OCI image indexes
+	MediaTypeImageIndex = "application/vnd.oci.image.index.v1+json"
+	// MediaTypeSignedManifest is the media type of signed schema1 manifests
+	MediaTypeSignedManifest = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	// MediaTypeManifest is the media type of docker schema2 manifests
+	MediaTypeManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	// MediaTypeImageManifest is the media type of OCI image manifests
+	MediaTypeImageManifest = "application/vnd.oci.image.manifest.v1+json"
+)
+
+// acceptManifestTypes are the media types accepted when getting a manifest by tag,
+// in the order of preference. Registries convert manifests of other types to schema1.
+var acceptManifestTypes = []string{
+	MediaTypeManifestList,
+	MediaTypeImageIndex,
+	MediaTypeManifest,
+	MediaTypeImageManifest,
+	MediaTypeSignedManifest,
+	manifest.ManifestMediaType,
+}
+
+// acceptImageManifestTypes are the media types accepted when getting a manifest
+// referenced by a manifest list
+var acceptImageManifestTypes = []string{
+	MediaTypeManifest,
+	MediaTypeImageManifest,
+	MediaTypeSignedManifest,
+	manifest.ManifestMediaType,
+}
+
+// Manifest is a manifest as stored in a registry, putting Raw byte for byte
+// keeps its digest
+type Manifest struct {
+	MediaType string
+	Raw       []byte
+	Digest    string
+}
+
+// IsList returns true if m is a docker manifest list or an OCI index
+func (m *Manifest) IsList() bool {
+	return m.MediaType == MediaTypeManifestList || m.MediaType == MediaTypeImageIndex
+}
+
+// IsSchema1 returns true if m is a schema1 manifest
+func (m *Manifest) IsSchema1() bool {
+	return m.MediaType == MediaTypeSignedManifest || m.MediaType == manifest.ManifestMediaType
+}
+
+// Image parses m as a docker schema2 or an OCI image manifest
+func (m *Manifest) Image() (*ImageManifest, error) {
+	if m.MediaType != MediaTypeManifest && m.MediaType != MediaTypeImageManifest {
+		return nil, fmt.Errorf("manifest %s is not an image manifest, media type:%s", m.Digest, m.MediaType)
+	}
+	var image ImageManifest
+	if err := json.Unmarshal(m.Raw, &image); err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// List parses m as a docker manifest list or an OCI index
+func (m *Manifest) List() (*ManifestList, error) {
+	if !m.IsList() {
+		return nil, fmt.Errorf("manifest %s is not a manifest list, media type:%s", m.Digest, m.MediaType)
+	}
+	var list ManifestList
+	if err := json.Unmarshal(m.Raw, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// Schema1 parses m as a signed schema1 manifest
+func (m *Manifest) Schema1() (*manifest.SignedManifest, error) {
+	if !m.IsSchema1() {
+		return nil, fmt.Errorf("manifest %s is not a schema1 manifest, media type:%s", m.Digest, m.MediaType)
+	}
+	sm := &manifest.SignedManifest{}
+	if err := sm.UnmarshalJSON(m.Raw); err != nil {
+		return nil, err
+	}
+	return sm, nil
+}
+
+// Blobs returns the digests of blobs referenced by an image manifest, in order,
+// foreign layers not stored in the registry are skipped
+func (m *Manifest) Blobs() ([]string, error) {
+	var res []string
+	if m.IsSchema1() {
+		sm, err := m.Schema1()
+		if err != nil {
+			return nil, err
+		}
+		for _, layer := range sm.FSLayers {
+			res = append(res, layer.BlobSum.String())
+		}
+		return res, nil
+	}
+
+	image, err := m.Image()
+	if err != nil {
+		return nil, err
+	}
+	res = append(res, image.Config.Digest)
+	for _, layer := range image.Layers {
+		if len(layer.URLs) == 0 {
+			res = append(res, layer.Digest)
+		}
+	}
+	return res, nil
+}
+
+// detectMediaType returns the media type of a manifest, registries serving
+// OCI manifests may reply with a generic content type
+func detectMediaType(contentType string, body []byte) string {
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = strings.TrimSpace(contentType[:i])
+	}
+	for _, mediaType := range acceptManifestTypes {
+		if contentType == mediaType {
+			return contentType
+		}
+	}
+
+	var doc struct {
+		SchemaVersion int               `json:"schemaVersion"`
+		MediaType     string            `json:"mediaType"`
+		Manifests     []json.RawMessage `json:"manifests"`
+		Config        json.RawMessage   `json:"config"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return contentType
+	}
+	switch {
+	case doc.MediaType != "":
+		return doc.MediaType
+	case doc.SchemaVersion == 1:
+		return MediaTypeSignedManifest
+	case doc.Manifests != nil:
+		return MediaTypeImageIndex
+	case doc.Config != nil:
+		return MediaTypeImageManifest
+	}
+	return contentType
+}
+
+// GetManifest gets manifest repo:reference of any supported media type
+func (c *Client) GetManifest(repo, reference string) (*Manifest, error) {
+	if c.RegClientV2 == nil {
+		return nil, errors.New("get manifest requires registry v2 api")
+	}
+	return getManifest(c.RegClientV2, c.repoPath(repo), reference, acceptManifestTypes)
+}
+
+// PutManifest puts m as repo:reference, reference is a tag or the digest of m
+func (c *Client) PutManifest(repo, reference string, m *Manifest) error {
+	if c.RegClientV2 == nil {
+		return errors.New("put manifest requires registry v2 api")
+	}
+	return putManifest(c.RegClientV2, c.repoPath(repo), reference, m)
+}
+
+// DeleteManifest deletes manifest repo@dgst, the registry untags all tags
+// referring to it. Registries refuse it unless deletion is enabled.
+func (c *Client) DeleteManifest(repo, dgst string) error {
+	if c.RegClientV2 == nil {
+		return errors.New("delete manifest requires registry v2 api")
+	}
+	repo = c.repoPath(repo)
+	req, err := http.NewRequest("DELETE", c.RegClientV2.URL+fmt.Sprintf("/v2/%s/manifests/%s", repo, dgst), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.RegClientV2.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// getManifest gets manifest repo:reference in one of the accepted media types
+func getManifest(reg *registryV2.Registry, repo, reference string, accept []string) (*Manifest, error) {
+	req, err := http.NewRequest("GET", reg.URL+fmt.Sprintf("/v2/%s/manifests/%s", repo, reference), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(accept, ", "))
+
+	resp, err := reg.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{
+		MediaType: detectMediaType(resp.Header.Get("Content-Type"), body),
+		Raw:       body,
+		Digest:    resp.Header.Get("Docker-Content-Digest"),
+	}
+	if m.Digest == "" {
+		dgst, err := digest.FromBytes(body)
+		if err != nil {
+			return nil, err
+		}
+		m.Digest = dgst.String()
+	}
+	return m, nil
+}
+
+// putManifest puts m as repo:reference, reference is a tag or the digest of m
+func putManifest(reg *registryV2.Registry, repo, reference string, m *Manifest) error {
+	req, err := http.NewRequest("PUT", reg.URL+fmt.Sprintf("/v2/%s/manifests/%s", repo, reference), bytes.NewReader(m.Raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", m.MediaType)
+
+	resp, err := reg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ManifestDigest returns the digest of manifest repo:reference, reported by
+// the registry in Docker-Content-Digest header
+func (c *Client) ManifestDigest(repo, reference string) (string, error) {
+	if c.RegClientV2 == nil {
+		return "", errors.New("manifest digest requires registry v2 api")
+	}
+	repo = c.repoPath(repo)
+	req, err := http.NewRequest("HEAD", c.RegClientV2.URL+fmt.Sprintf("/v2/%s/manifests/%s", repo, reference), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", strings.Join(acceptManifestTypes, ", "))
+
+	resp, err := c.RegClientV2.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Docker-Content-Digest"), nil
+}
+
+// ProbePullLimit asks docker hub for the pull budget with a HEAD request, which
+// does not count as a pull, so that dockerhub.PullLimit knows it before pulls
+// made by the docker daemon. It does nothing for other registries.
+func (c *Client) ProbePullLimit() error {
+	if !c.isHub {
+		return nil
+	}
+	_, err := c.ManifestDigest("ratelimitpreview/test", "latest")
+	return err
+}
+
+// SameImage returns true if srcRepo:tag in src and dstRepo:tag in dst have the
+// same content. Schema1 manifests are signed again when copied to another
+// repo, so their layers and history are compared if digests differ. A manifest
+// list copied with the platforms selected by opts is the same if it is the
+// list a copy would put now, so platforms added upstream are copied.
+func SameImage(src, dst *Client, srcRepo, dstRepo, tag string, opts *CopyOptions) (bool, error) {
+	srcDigest, err := src.ManifestDigest(srcRepo, tag)
+	if err != nil {
+		return false, err
+	}
+	dstDigest, err := dst.ManifestDigest(dstRepo, tag)
+	if err != nil {
+		if IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if srcDigest != "" && srcDigest == dstDigest {
+		return true, nil
+	}
+
+	srcManifest, err := src.GetManifest(srcRepo, tag)
+	if err != nil {
+		return false, err
+	}
+	dstManifest, err := dst.GetManifest(dstRepo, tag)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case srcManifest.IsSchema1() && dstManifest.IsSchema1():
+		a, err := srcManifest.Schema1()
+		if err != nil {
+			return false, err
+		}
+		b, err := dstManifest.Schema1()
+		if err != nil {
+			return false, err
+		}
+		return sameContent(&a.Manifest, &b.Manifest), nil
+	case srcManifest.IsList() && dstManifest.IsList():
+		// unselected platforms are removed from a copy, as copyManifestList does
+		selected := &Manifest{MediaType: srcManifest.MediaType, Raw: srcManifest.Raw, Digest: srcManifest.Digest}
+		if _, err := selectPlatforms(selected, opts); err != nil {
+			return false, err
+		}
+		return selected.Digest == dstManifest.Digest, nil
+	}
+	return false, nil
+}
+
+// sameContent compares layers and history of schema1 manifests, ignoring name, tag and signatures
+func sameContent(a, b *manifest.Manifest) bool {
+	if len(a.FSLayers) != len(b.FSLayers) || len(a.History) != len(b.History) {
+		return false
+	}
+	for i := range a.FSLayers {
+		if a.FSLayers[i].BlobSum != b.FSLayers[i].BlobSum {
+			return false
+		}
+	}
+	for i := range a.History {
+		if a.History[i].V1Compatibility != b.History[i].V1Compatibility {
+			return false
+		}
+	}
+	return true
+}
+
+// IsNotFound returns true if err is caused by a 404 response of registry v2 api
+func IsNotFound(err error) bool {
+	return statusCode(err) == http.StatusNotFound
+}
+
+// statusCode returns the status code of the response err is caused by, 0 if there is none
+func statusCode(err error) int {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if httpErr, ok := err.(*registryV2.HttpStatusError); ok {
+		return httpErr.Response.StatusCode
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"testing"
+)
+
+func TestSameImage(t *testing.T) {
+	src := newFakeRegistry(t)
+	dst := newFakeRegistry(t)
+	srcClient := src.client(t)
+	dstClient := dst.client(t)
+	pushSchema1Image(t, src, "library/busybox", "latest", "layer-a", "layer-b")
+
+	same, err := SameImage(srcClient, dstClient, "library/busybox", "docker_library/busybox", "latest", nil)
+	if err != nil || same {
+		t.Errorf("image not found at destination should differ, same:%v, error:%v\n", same, err)
+	}
+
+	if _, err := CopyImage(srcClient, dstClient, "library/busybox", "docker_library/busybox", "latest", nil); err != nil {
+		t.Fatalf("copy image should succeed, error:%s\n", err)
+	}
+	same, err = SameImage(srcClient, dstClient, "library/busybox", "docker_library/busybox", "latest", nil)
+	if err != nil || !same {
+		t.Errorf("copied image should be the same, same:%v, error:%v\n", same, err)
+	}
+
+	// the tag is updated at source
+	pushSchema1Image(t, src, "library/busybox", "latest", "layer-a", "layer-c")
+	same, err = SameImage(srcClient, dstClient, "library/busybox", "docker_library/busybox", "latest", nil)
+	if err != nil || same {
+		t.Errorf("updated image should differ, same:%v, error:%v\n", same, err)
+	}
+}
+
+func TestDeleteManifest(t *testing.T) {
+	r := newFakeRegistry(t)
+	c := r.client(t)
+	// latest and 1.0 refer to the same manifest
+	r.addManifest("library/busybox", "latest", MediaTypeImageManifest, []byte(`{"layers":["a"]}`))
+	r.addManifest("library/busybox", "1.0", MediaTypeImageManifest, []byte(`{"layers":["a"]}`))
+	r.addManifest("library/busybox", "1.1", MediaTypeImageManifest, []byte(`{"layers":["b"]}`))
+
+	dgst, err := c.ManifestDigest("library/busybox", "latest")
+	if err != nil {
+		t.Fatalf("get digest should succeed, error:%s\n", err)
+	}
+	if err := c.DeleteManifest("library/busybox", dgst); err != nil {
+		t.Fatalf("delete manifest should succeed, error:%s\n", err)
+	}
+	tags, err := c.ListTags("library/busybox")
+	if err != nil || len(tags) != 1 || tags[0] != "1.1" {
+		t.Errorf("tags referring to the deleted manifest should be gone, tags:%v, error:%v\n", tags, err)
+	}
+	if err := c.DeleteManifest("library/busybox", dgst); !IsNotFound(err) {
+		t.Errorf("delete manifest again should not be found, error:%v\n", err)
+	}
+}
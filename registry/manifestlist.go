@@ -0,0 +1,159 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution/digest"
+	"github.com/golang/glog"
+)
+
+// Platform is the platform an image of a manifest list runs on
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// ParsePlatform parses os/arch[/variant], such as linux/arm64 or linux/arm/v7
+func ParsePlatform(s string) (Platform, error) {
+	arr := strings.Split(s, "/")
+	if len(arr) < 2 || len(arr) > 3 || arr[0] == "" || arr[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q, should be os/arch[/variant]", s)
+	}
+	p := Platform{OS: arr[0], Architecture: arr[1]}
+	if len(arr) == 3 {
+		p.Variant = arr[2]
+	}
+	return p, nil
+}
+
+func (p Platform) String() string {
+	if p.Variant == "" {
+		return p.OS + "/" + p.Architecture
+	}
+	return p.OS + "/" + p.Architecture + "/" + p.Variant
+}
+
+// matches returns true if p is selected by want, the variant is ignored if want has none
+func (p Platform) matches(want Platform) bool {
+	return p.OS == want.OS && p.Architecture == want.Architecture &&
+		(want.Variant == "" || p.Variant == want.Variant)
+}
+
+// copyManifestList copies the manifests of the platforms selected by opts referenced by
+// list, then puts the list as dstRepo:tag. The list is kept byte for byte if
+// all platforms are copied, so its digest does not change.
+func copyManifestList(src, dst *Client, srcRepo, dstRepo, tag string, list *Manifest, opts *CopyOptions) (*CopyResult, error) {
+	l, err := list.List()
+	if err != nil {
+		return nil, pullError("invalid manifest list %s:%s, error:%s", srcRepo, tag, err)
+	}
+	total := len(l.Manifests)
+	selected, err := selectPlatforms(list, opts)
+	if err != nil {
+		return nil, pullError("select platforms of %s:%s fails, error:%s", srcRepo, tag, err)
+	}
+
+	result := &CopyResult{}
+	copied := make(map[digest.Digest]bool)
+	for _, desc := range selected {
+		n, err := copyPlatformManifest(src, dst, srcRepo, dstRepo, desc.Digest, copied, opts)
+		if err != nil {
+			return nil, err
+		}
+		result.Bytes += n
+	}
+	if err := putManifest(dst.RegClientV2, dstRepo, tag, list); err != nil {
+		return nil, pushError("put manifest list of %s:%s fails, error:%s", dstRepo, tag, err)
+	}
+	result.Digest = list.Digest
+	glog.V(4).Infof("manifest list %s:%s copied to %s:%s, platforms:%d/%d, bytes:%d\n", srcRepo, tag, dstRepo, tag, len(selected), total, result.Bytes)
+	return result, nil
+}
+
+// selectPlatforms returns the manifests of the platforms selected by opts
+// referenced by list. Unselected platforms are removed from list, which is
+// kept byte for byte if all platforms are selected.
+func selectPlatforms(list *Manifest, opts *CopyOptions) ([]Descriptor, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(list.Raw, &doc); err != nil {
+		return nil, err
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(doc["manifests"], &entries); err != nil {
+		return nil, err
+	}
+	platforms, err := opts.platforms()
+	if err != nil {
+		return nil, err
+	}
+
+	var descs []Descriptor
+	selected := make([]json.RawMessage, 0, len(entries))
+	for _, entry := range entries {
+		var desc Descriptor
+		if err := json.Unmarshal(entry, &desc); err != nil {
+			return nil, err
+		}
+		if !platformSelected(desc.Platform, platforms) {
+			glog.V(4).Infof("platform %v of %s is not selected, skip it\n", desc.Platform, list.Digest)
+			continue
+		}
+		descs = append(descs, desc)
+		selected = append(selected, entry)
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no platform matches %v", platforms)
+	}
+
+	if len(selected) < len(entries) {
+		body, err := json.Marshal(selected)
+		if err != nil {
+			return nil, err
+		}
+		doc["manifests"] = body
+		if list.Raw, err = json.MarshalIndent(doc, "", "   "); err != nil {
+			return nil, err
+		}
+		dgst, err := digest.FromBytes(list.Raw)
+		if err != nil {
+			return nil, err
+		}
+		list.Digest = dgst.String()
+	}
+	return descs, nil
+}
+
+// platformSelected returns true if p matches one of platforms, or platforms is empty
+func platformSelected(p *Platform, platforms []Platform) bool {
+	if len(platforms) == 0 {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	for _, want := range platforms {
+		if p.matches(want) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyPlatformManifest copies manifest srcRepo@dgst with its blobs, blobs in copied are skipped
+func copyPlatformManifest(src, dst *Client, srcRepo, dstRepo, dgst string, copied map[digest.Digest]bool, opts *CopyOptions) (int64, error) {
+	m, err := getManifest(src.RegClientV2, srcRepo, dgst, acceptImageManifestTypes)
+	if err != nil {
+		return 0, pullError("get manifest %s@%s fails, error:%s", srcRepo, dgst, err)
+	}
+	n, err := copyManifestBlobs(src, dst, srcRepo, dstRepo, m, copied, opts)
+	if err != nil {
+		return 0, err
+	}
+	if err := putManifest(dst.RegClientV2, dstRepo, dgst, m); err != nil {
+		return 0, pushError("put manifest %s@%s fails, error:%s", dstRepo, dgst, err)
+	}
+	return n, nil
+}
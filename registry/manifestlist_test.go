@@ -0,0 +1,173 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+// pushSchema2Image stores a schema2 image with the given layers in r by digest, and returns its descriptor
+func pushSchema2Image(t *testing.T, r *fakeRegistry, repo, platform string, layers ...string) Descriptor {
+	config := r.addBlob(repo, []byte(`{"architecture":"`+platform+`"}`))
+	m := map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     MediaTypeManifest,
+		"config":        Descriptor{MediaType: "application/vnd.docker.container.image.v1+json", Digest: config},
+	}
+	var descs []Descriptor
+	for _, layer := range layers {
+		descs = append(descs, Descriptor{MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip", Size: int64(len(layer)), Digest: r.addBlob(repo, []byte(layer))})
+	}
+	m["layers"] = descs
+	body, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("marshal manifest fails, error:%s\n", err)
+	}
+	p, err := ParsePlatform(platform)
+	if err != nil {
+		t.Fatalf("invalid platform, error:%s\n", err)
+	}
+	dgst := r.addManifest(repo, "", MediaTypeManifest, body)
+	return Descriptor{MediaType: MediaTypeManifest, Size: int64(len(body)), Digest: dgst, Platform: &p}
+}
+
+// pushManifestList stores a manifest list of the given images as repo:tag
+func pushManifestList(t *testing.T, r *fakeRegistry, repo, tag string, images ...Descriptor) string {
+	body := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"manifests":`, MediaTypeManifestList)
+	manifests, err := json.Marshal(images)
+	if err != nil {
+		t.Fatalf("marshal manifest list fails, error:%s\n", err)
+	}
+	return r.addManifest(repo, tag, MediaTypeManifestList, []byte(body+string(manifests)+"}"))
+}
+
+func TestCopyManifestList(t *testing.T) {
+	src := newFakeRegistry(t)
+	amd64 := pushSchema2Image(t, src, "library/alpine", "linux/amd64", "base", "amd64-layer")
+	arm64 := pushSchema2Image(t, src, "library/alpine", "linux/arm64/v8", "base", "arm64-layer")
+	listDigest := pushManifestList(t, src, "library/alpine", "3.4", amd64, arm64)
+
+	testCases := []struct {
+		platforms []string
+		expected  []Descriptor
+		missing   []string
+	}{
+		{nil, []Descriptor{amd64, arm64}, nil},
+		{[]string{"linux/arm64"}, []Descriptor{arm64}, []string{"amd64-layer"}},
+		{[]string{"linux/arm64/v8", "windows/amd64"}, []Descriptor{arm64}, []string{"amd64-layer"}},
+	}
+	for _, tc := range testCases {
+		dst := newFakeRegistry(t)
+		result, err := CopyImage(src.client(t), dst.client(t), "library/alpine", "docker_library/alpine", "3.4", &CopyOptions{Platforms: tc.platforms})
+		if err != nil {
+			t.Errorf("copy manifest list of %v should succeed, error:%s\n", tc.platforms, err)
+			continue
+		}
+
+		m, ok := dst.manifest("docker_library/alpine", "3.4")
+		if !ok {
+			t.Errorf("manifest list of %v should be put\n", tc.platforms)
+			continue
+		}
+		if m.mediaType != MediaTypeManifestList {
+			t.Errorf("media type should be kept, got %s\n", m.mediaType)
+		}
+		if len(tc.platforms) == 0 && result.Digest != listDigest {
+			t.Errorf("digest of the manifest list should be kept, expected %s, got %s\n", listDigest, result.Digest)
+		}
+		if result.Digest != fakeDigest(m.body) {
+			t.Errorf("digest should be the one put, expected %s, got %s\n", fakeDigest(m.body), result.Digest)
+		}
+
+		var list struct {
+			Manifests []Descriptor `json:"manifests"`
+		}
+		if err := json.Unmarshal(m.body, &list); err != nil || len(list.Manifests) != len(tc.expected) {
+			t.Errorf("manifest list of %v should have %d manifests, got %s\n", tc.platforms, len(tc.expected), m.body)
+			continue
+		}
+		for i, desc := range tc.expected {
+			if list.Manifests[i].Digest != desc.Digest {
+				t.Errorf("manifest #%d should be %s, got %s\n", i, desc.Digest, list.Manifests[i].Digest)
+			}
+			if _, ok := dst.manifest("docker_library/alpine", desc.Digest); !ok {
+				t.Errorf("manifest %s should be copied\n", desc.Digest)
+			}
+		}
+		if !dst.hasBlob(fakeDigest([]byte("arm64-layer"))) {
+			t.Errorf("layers of selected platforms should be copied\n")
+		}
+		for _, layer := range tc.missing {
+			if dst.hasBlob(fakeDigest([]byte(layer))) {
+				t.Errorf("layer %s of platforms not selected should not be copied\n", layer)
+			}
+		}
+		if dst.blobUploads != 1+len(tc.expected)*2 {
+			// one config and one layer for each platform, plus the shared base layer
+			t.Errorf("shared layers should be uploaded once, uploads:%d\n", dst.blobUploads)
+		}
+	}
+
+	dst := newFakeRegistry(t)
+	_, err := CopyImage(src.client(t), dst.client(t), "library/alpine", "docker_library/alpine", "3.4", &CopyOptions{Platforms: []string{"linux/s390x"}})
+	if copyErr, ok := err.(*CopyError); !ok || copyErr.Op != "pull" {
+		t.Errorf("copy without matching platforms should fail, error:%v\n", err)
+	}
+}
+
+func TestSameImageManifestList(t *testing.T) {
+	src := newFakeRegistry(t)
+	amd64 := pushSchema2Image(t, src, "library/alpine", "linux/amd64", "base", "amd64-layer")
+	arm64 := pushSchema2Image(t, src, "library/alpine", "linux/arm64/v8", "base", "arm64-layer")
+	both := []string{"linux/amd64", "linux/arm64"}
+
+	testCases := []struct {
+		name     string
+		copied   []Descriptor
+		copyWith []string
+		check    []string
+		same     bool
+	}{
+		{"unchanged", []Descriptor{amd64, arm64}, []string{"linux/amd64"}, []string{"linux/amd64"}, true},
+		{"platform added to the job", []Descriptor{amd64, arm64}, []string{"linux/amd64"}, both, false},
+		{"platform added upstream", []Descriptor{amd64}, both, both, false},
+		{"all platforms", []Descriptor{amd64, arm64}, nil, nil, true},
+	}
+	for _, tc := range testCases {
+		dst := newFakeRegistry(t)
+		pushManifestList(t, src, "library/alpine", "3.4", tc.copied...)
+		if _, err := CopyImage(src.client(t), dst.client(t), "library/alpine", "docker_library/alpine", "3.4", &CopyOptions{Platforms: tc.copyWith}); err != nil {
+			t.Errorf("%s: copy manifest list should succeed, error:%s\n", tc.name, err)
+			continue
+		}
+		pushManifestList(t, src, "library/alpine", "3.4", amd64, arm64)
+
+		same, err := SameImage(src.client(t), dst.client(t), "library/alpine", "docker_library/alpine", "3.4", &CopyOptions{Platforms: tc.check})
+		if err != nil || same != tc.same {
+			t.Errorf("%s: same should be %v, got %v, error:%v\n", tc.name, tc.same, same, err)
+		}
+	}
+}
+
+func TestParsePlatform(t *testing.T) {
+	testCases := []struct {
+		platform string
+		expected Platform
+		valid    bool
+	}{
+		{"linux/amd64", Platform{OS: "linux", Architecture: "amd64"}, true},
+		{"linux/arm/v7", Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, true},
+		{"linux", Platform{}, false},
+		{"linux/", Platform{}, false},
+		{"linux/arm/v7/x", Platform{}, false},
+	}
+	for _, tc := range testCases {
+		p, err := ParsePlatform(tc.platform)
+		if (err == nil) != tc.valid || p != tc.expected {
+			t.Errorf("platform %q should be %#v (valid:%v), got %#v, error:%v\n", tc.platform, tc.expected, tc.valid, p, err)
+		}
+		if tc.valid && p.String() != tc.platform {
+			t.Errorf("platform %q should be formatted back, got %s\n", tc.platform, p)
+		}
+	}
+}
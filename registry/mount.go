@@ -0,0 +1,41 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/docker/distribution/digest"
+	"github.com/golang/glog"
+
+	registryV2 "github.com/oscarzhao/docker-registry-client/registry"
+)
+
+// mountBlob asks reg to mount blob dgst of fromRepo into repo, so that it is
+// not transferred at all. It returns true if the blob is mounted. If the registry
+// refuses the mount, it starts a regular upload instead, and its location is returned.
+func mountBlob(reg *registryV2.Registry, repo, fromRepo string, dgst digest.Digest) (bool, *url.URL, error) {
+	q := url.Values{}
+	q.Set("mount", dgst.String())
+	q.Set("from", fromRepo)
+	mountURL := reg.URL + fmt.Sprintf("/v2/%s/blobs/uploads/?%s", repo, q.Encode())
+	glog.V(6).Infof("mount blob %s from %s to %s, url:%s\n", dgst, fromRepo, repo, mountURL)
+
+	resp, err := reg.Client.Post(mountURL, "application/octet-stream", nil)
+	if err != nil {
+		return false, nil, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return true, nil, nil
+	case http.StatusAccepted:
+		location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+		if err != nil {
+			return false, nil, err
+		}
+		return false, location, nil
+	}
+	return false, nil, fmt.Errorf("unexpected status %d of blob mount", resp.StatusCode)
+}
@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"testing"
+)
+
+func TestCopyImageMount(t *testing.T) {
+	testCases := []struct {
+		noMount bool
+		mounts  int
+		uploads int
+		bytes   int64
+	}{
+		{false, 3, 0, 0},
+		{true, 0, 3, int64(len("config") + len("layer-a") + len("layer-b"))},
+	}
+	for _, tc := range testCases {
+		r := newFakeRegistry(t)
+		r.noMount = tc.noMount
+		config := r.addBlob("library/busybox", []byte("config"))
+		body := `{"schemaVersion":2,"mediaType":"` + MediaTypeManifest + `","config":{"digest":"` + config + `"},"layers":[` +
+			`{"digest":"` + r.addBlob("library/busybox", []byte("layer-a")) + `"},` +
+			`{"digest":"` + r.addBlob("library/busybox", []byte("layer-b")) + `"}]}`
+		dgst := r.addManifest("library/busybox", "latest", MediaTypeManifest, []byte(body))
+
+		client := r.client(t)
+		result, err := CopyImage(client, client, "library/busybox", "docker_library/busybox", "latest", nil)
+		if err != nil {
+			t.Errorf("copy within a registry should succeed (noMount:%v), error:%s\n", tc.noMount, err)
+			continue
+		}
+		if result.Digest != dgst {
+			t.Errorf("digest should be kept, expected %s, got %s\n", dgst, result.Digest)
+		}
+		if r.blobMounts != tc.mounts || r.blobUploads != tc.uploads {
+			t.Errorf("noMount:%v, expected %d mounts and %d uploads, got %d and %d\n", tc.noMount, tc.mounts, tc.uploads, r.blobMounts, r.blobUploads)
+		}
+		if result.Bytes != tc.bytes {
+			t.Errorf("noMount:%v, expected %d bytes transferred, got %d\n", tc.noMount, tc.bytes, result.Bytes)
+		}
+		if len(r.uploads) != 0 {
+			t.Errorf("noMount:%v, upload sessions should be completed, got %d left\n", tc.noMount, len(r.uploads))
+		}
+		ok, err := SameImage(client, client, "library/busybox", "docker_library/busybox", "latest", nil)
+		if err != nil || !ok {
+			t.Errorf("noMount:%v, copied image should be the same, error:%v\n", tc.noMount, err)
+		}
+	}
+}
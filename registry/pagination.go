@@ -0,0 +1,71 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	registryV2 "github.com/oscarzhao/docker-registry-client/registry"
+)
+
+// linkNextRegexp matches the next page in a Link header, such as
+// </v2/_catalog?last=b&n=100>; rel="next"
+var linkNextRegexp = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+
+// nextLink returns the url of the next page in the Link header of resp,
+// resolved against the request url, "" if resp is the last page
+func nextLink(resp *http.Response) (string, error) {
+	m := linkNextRegexp.FindStringSubmatch(resp.Header.Get("Link"))
+	if m == nil {
+		return "", nil
+	}
+	next, err := url.Parse(m[1])
+	if err != nil {
+		return "", err
+	}
+	return resp.Request.URL.ResolveReference(next).String(), nil
+}
+
+// PartialListError is returned if listing fails after some pages are got,
+// the entries of those pages are returned along with it
+type PartialListError struct {
+	Pages int
+	Err   error
+}
+
+func (e *PartialListError) Error() string {
+	return fmt.Sprintf("list is incomplete, %d pages got before error:%s", e.Pages, e.Err)
+}
+
+// pageURL appends the page size hint n to rawURL if n is positive
+func pageURL(rawURL string, n int) string {
+	if n <= 0 {
+		return rawURL
+	}
+	return fmt.Sprintf("%s?n=%d", rawURL, n)
+}
+
+// getPages gets the json document at pageURL and the pages following it,
+// decodePage is called once per page. A *PartialListError is returned if
+// a page other than the first one fails.
+func getPages(reg *registryV2.Registry, pageURL string, decodePage func(*json.Decoder) error) error {
+	for pages := 0; pageURL != ""; pages++ {
+		resp, err := reg.Client.Get(pageURL)
+		if err == nil {
+			err = decodePage(json.NewDecoder(resp.Body))
+			if err == nil {
+				pageURL, err = nextLink(resp)
+			}
+			resp.Body.Close()
+		}
+		if err != nil {
+			if pages > 0 {
+				return &PartialListError{Pages: pages, Err: err}
+			}
+			return err
+		}
+	}
+	return nil
+}
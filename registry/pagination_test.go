@@ -0,0 +1,30 @@
+package registry
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestNextLink(t *testing.T) {
+	reqURL, _ := url.Parse("https://registry.example.com/v2/_catalog?n=2")
+	testCases := []struct {
+		link     string
+		expected string
+	}{
+		{"", ""},
+		{`</v2/_catalog?last=b&n=2>; rel="next"`, "https://registry.example.com/v2/_catalog?last=b&n=2"},
+		{`<https://other.example.com/v2/_catalog?last=b>; rel=next`, "https://other.example.com/v2/_catalog?last=b"},
+		{`</v2/_catalog?last=a>; rel="prev"`, ""},
+	}
+	for _, tc := range testCases {
+		resp := &http.Response{Header: http.Header{}, Request: &http.Request{URL: reqURL}}
+		if tc.link != "" {
+			resp.Header.Set("Link", tc.link)
+		}
+		next, err := nextLink(resp)
+		if err != nil || next != tc.expected {
+			t.Errorf("next link of %q should be %q, got %q, error:%v\n", tc.link, tc.expected, next, err)
+		}
+	}
+}
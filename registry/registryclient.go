@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 
@@ -13,6 +14,12 @@ import (
 	"github.com/oscarzhao/image-sync/dockerhub"
 )
 
+// TagInfo is a tag of a repo, LastUpdated is zero if the registry does not report it
+type TagInfo struct {
+	Name        string
+	LastUpdated time.Time
+}
+
 type Client struct {
 	isHub       bool
 	proto       string
@@ -20,21 +27,38 @@ type Client struct {
 	version     string
 	repoName    string
 	repoTag     string
+	credential  Credential
 	RegClient   *registryV1.Client
 	RegClientV2 *registryV2.Registry
 	HubClient   *dockerhub.DockerHubClient
+
+	// PageSize is the hint of entries per page of registry v2 list apis,
+	// 0 lets the registry decide
+	PageSize int
 }
 
 // NewClient creates a new registry client, default returns a docker hub client
 func NewClient(proto, registry, version, username, password string) (*Client, error) {
+	return NewClientWithAuth(proto, registry, version, Credential{Username: username, Password: password})
+}
+
+// NewClientWithAuth creates a new registry client authenticating with cred,
+// default returns a docker hub client
+func NewClientWithAuth(proto, registry, version string, cred Credential) (*Client, error) {
 	if registry == "" || version == "" || proto == "" || registry == "index.docker.io" {
 		glog.V(4).Infof("create a docker hub client, registry:%s\n", registry)
+		hubClientV2, err := newV2Registry(hubRegistryURL, cred, false)
+		if err != nil {
+			return nil, err
+		}
 		return &Client{
-			isHub:     true,
-			proto:     "https",
-			registry:  "index.docker.io",
-			version:   "v2",
-			HubClient: &dockerhub.DockerHubClient{},
+			isHub:       true,
+			proto:       "https",
+			registry:    "index.docker.io",
+			version:     "v2",
+			credential:  cred,
+			RegClientV2: hubClientV2,
+			HubClient:   &dockerhub.DockerHubClient{Username: cred.Username, Password: cred.Password},
 		}, nil
 	}
 	switch version {
@@ -52,7 +76,7 @@ func NewClient(proto, registry, version, username, password string) (*Client, er
 			RegClient: srcClient,
 		}, nil
 	case "v2":
-		srcClient, err := registryV2.New(fmt.Sprintf("%s://%s/", proto, registry), username, password)
+		srcClient, err := newV2Registry(fmt.Sprintf("%s://%s/", proto, registry), cred, true)
 		if err != nil {
 			return nil, err
 		}
@@ -62,6 +86,7 @@ func NewClient(proto, registry, version, username, password string) (*Client, er
 			proto:       proto,
 			registry:    registry,
 			version:     version,
+			credential:  cred,
 			RegClientV2: srcClient,
 		}, nil
 	}
@@ -77,6 +102,18 @@ func (c *Client) IsHub() bool {
 // ListRepositories list all repos according to a keyword
 func (c *Client) ListRepositories(pattern string) ([]string, error) {
 	if c.isHub {
+		// search finds public repos only, private repos are listed by namespace
+		if c.credential.Password != "" && pattern != "" && pattern != "library" {
+			repos, err := c.HubClient.ListReposByUser(pattern)
+			if err != nil {
+				return nil, err
+			}
+			var res []string
+			for _, repo := range repos {
+				res = append(res, repo.Namespace+"/"+repo.Name)
+			}
+			return res, nil
+		}
 		images, err := c.HubClient.SearchReposByUser(pattern)
 		if err != nil {
 			return nil, err
@@ -92,7 +129,7 @@ func (c *Client) ListRepositories(pattern string) ([]string, error) {
 	case "v1":
 		return c.ListRepositoriesV1(pattern)
 	case "v2":
-		return nil, errors.New("registry v2 does not support search repo")
+		return c.listRepositoriesV2(pattern)
 	default:
 		return nil, errors.New("invalid registry version")
 	}
@@ -184,11 +221,39 @@ func (c Client) ListTags(repo string) ([]string, error) {
 	}
 
 	// use v2 version api
-	tags, err := c.RegClientV2.Tags(repo)
+	tags, err := c.listTagsV2(repo)
 	if err != nil {
 		glog.Errorf("ListTags failed, error info: %s\n", err)
-		return nil, err
+		return tags, err
 	}
 	glog.V(6).Infof("ListTags v2 succeeds, repo: %s, results: %v\n", repo, tags)
 	return tags, nil
 }
+
+// ListTagInfos lists all tags of a repo, with their update time if available (docker hub only)
+func (c Client) ListTagInfos(repo string) ([]TagInfo, error) {
+	var res []TagInfo
+	if c.isHub {
+		tags, err := c.HubClient.QueryImageTags(repo)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tags {
+			updated, err := time.Parse(time.RFC3339Nano, t.LastUpdated)
+			if err != nil {
+				glog.V(6).Infof("invalid last_updated of tag %s:%s, %s\n", repo, t.Name, t.LastUpdated)
+			}
+			res = append(res, TagInfo{Name: t.Name, LastUpdated: updated})
+		}
+		return res, nil
+	}
+
+	tags, err := c.ListTags(repo)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tags {
+		res = append(res, TagInfo{Name: t})
+	}
+	return res, nil
+}
@@ -43,7 +43,6 @@ func TestListRepositories(t *testing.T) {
 
 	shouldFails := []imageInfo{
 		{"https", "index.tenxcloud.com", "v1", "docker_library/notfound", "latest", "", ""}, // registry version(v1) not match the actual version(v2)
-		{"https", "index.tenxcloud.com", "v2", "google_containers/pause", "latest", "", ""}, // registry v2 search is not supported
 	}
 	for _, tc := range shouldFails {
 		srcClient, err := NewClient(tc.proto, tc.registry, tc.version, tc.username, tc.password)
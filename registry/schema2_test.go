@@ -0,0 +1,72 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/docker/distribution/manifest"
+)
+
+func TestCopyImageSchema2(t *testing.T) {
+	for _, mediaType := range []string{MediaTypeManifest, MediaTypeImageManifest} {
+		src := newFakeRegistry(t)
+		dst := newFakeRegistry(t)
+		desc := pushSchema2Image(t, src, "library/redis", "linux/amd64", "layer-a", "layer-b")
+		m, _ := src.manifest("library/redis", desc.Digest)
+		if mediaType == MediaTypeImageManifest {
+			// the same structure with OCI media types
+			desc.Digest = src.addManifest("library/redis", "3.2", mediaType, m.body)
+		} else {
+			src.addManifest("library/redis", "3.2", mediaType, m.body)
+		}
+
+		srcClient, dstClient := src.client(t), dst.client(t)
+		result, err := CopyImage(srcClient, dstClient, "library/redis", "docker_library/redis", "3.2", nil)
+		if err != nil {
+			t.Errorf("copy %s image should succeed, error:%s\n", mediaType, err)
+			continue
+		}
+		if result.Digest != desc.Digest {
+			t.Errorf("digest of %s image should be kept, expected %s, got %s\n", mediaType, desc.Digest, result.Digest)
+		}
+		// config and 2 layers
+		if result.Bytes != int64(len(`{"architecture":"linux/amd64"}`)+len("layer-a")+len("layer-b")) {
+			t.Errorf("bytes of config and layers should be counted, got %d\n", result.Bytes)
+		}
+
+		copied, err := dstClient.GetManifest("docker_library/redis", "3.2")
+		if err != nil {
+			t.Fatalf("manifest should be put, error:%s\n", err)
+		}
+		if copied.MediaType != mediaType || copied.Digest != desc.Digest || string(copied.Raw) != string(m.body) {
+			t.Errorf("manifest should be kept byte for byte, got %s %s\n", copied.MediaType, copied.Digest)
+		}
+		image, err := copied.Image()
+		if err != nil || len(image.Layers) != 2 {
+			t.Errorf("manifest should be parsed as an image manifest, got %#v, error:%v\n", image, err)
+		}
+		if same, err := SameImage(srcClient, dstClient, "library/redis", "docker_library/redis", "3.2", nil); err != nil || !same {
+			t.Errorf("copied %s image should be the same, same:%v, error:%v\n", mediaType, same, err)
+		}
+	}
+}
+
+func TestDetectMediaType(t *testing.T) {
+	testCases := []struct {
+		contentType string
+		body        string
+		expected    string
+	}{
+		{MediaTypeManifest + "; charset=utf-8", `{}`, MediaTypeManifest},
+		{"application/json", `{"schemaVersion":2,"mediaType":"` + MediaTypeManifestList + `","manifests":[]}`, MediaTypeManifestList},
+		{"application/json", `{"schemaVersion":2,"manifests":[]}`, MediaTypeImageIndex},
+		{"application/json", `{"schemaVersion":2,"config":{},"layers":[]}`, MediaTypeImageManifest},
+		{"application/json", `{"schemaVersion":1,"fsLayers":[]}`, MediaTypeSignedManifest},
+		{manifest.ManifestMediaType, `{"schemaVersion":1}`, manifest.ManifestMediaType},
+		{"text/plain", `not json`, "text/plain"},
+	}
+	for _, tc := range testCases {
+		if mediaType := detectMediaType(tc.contentType, []byte(tc.body)); mediaType != tc.expected {
+			t.Errorf("media type of %s %s should be %s, got %s\n", tc.contentType, tc.body, tc.expected, mediaType)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type tagsResponse struct {
+	Tags []string `json:"tags"`
+}
+
+// listTagsV2 lists all tags of repo through /v2/<name>/tags/list, following
+// the Link header until the last page. Tags listed are returned along with
+// a *PartialListError if a page fails.
+func (c Client) listTagsV2(repo string) ([]string, error) {
+	var tags []string
+	tagsURL := fmt.Sprintf("%s/v2/%s/tags/list", c.RegClientV2.URL, repo)
+	err := getPages(c.RegClientV2, pageURL(tagsURL, c.PageSize), func(d *json.Decoder) error {
+		var page tagsResponse
+		if err := d.Decode(&page); err != nil {
+			return err
+		}
+		tags = append(tags, page.Tags...)
+		return nil
+	})
+	return tags, err
+}
@@ -0,0 +1,51 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestListTagsV2(t *testing.T) {
+	r := newFakeRegistry(t)
+	expected := []string{"1.0", "1.1", "1.2", "2.0", "latest"}
+	for _, tag := range expected {
+		pushSchema1Image(t, r, "google_containers/pause", tag, "layer-"+tag)
+	}
+
+	testCases := []struct {
+		pageSize int
+	}{
+		{0}, {1}, {2}, {5}, {100},
+	}
+	for _, tc := range testCases {
+		c := r.client(t)
+		c.PageSize = tc.pageSize
+		tags, err := c.ListTags("google_containers/pause")
+		if err != nil {
+			t.Errorf("list tags with page size %d should succeed, error:%s\n", tc.pageSize, err)
+			continue
+		}
+		if !reflect.DeepEqual(tags, expected) {
+			t.Errorf("tags with page size %d should be %v, got %v\n", tc.pageSize, expected, tags)
+		}
+	}
+
+	// the third page fails
+	r.setFailAfter("1.1")
+	c := r.client(t)
+	c.PageSize = 2
+	tags, err := c.ListTags("google_containers/pause")
+	partial, ok := err.(*PartialListError)
+	if !ok || partial.Pages != 1 {
+		t.Fatalf("failure of a later page should be a partial list error, got %v\n", err)
+	}
+	if !reflect.DeepEqual(tags, expected[:2]) {
+		t.Errorf("tags of pages got should be returned, got %v\n", tags)
+	}
+
+	// the first page fails
+	r.setFailAfter("")
+	if _, err := c.ListTags("google_containers/notfound"); err == nil || !IsNotFound(err) {
+		t.Errorf("tags of a missing repo should not be found, error:%v\n", err)
+	}
+}
@@ -0,0 +1,280 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+
+	registryV2 "github.com/oscarzhao/docker-registry-client/registry"
+
+	"github.com/oscarzhao/image-sync/dockerhub"
+	"github.com/oscarzhao/image-sync/metrics"
+	"github.com/oscarzhao/image-sync/retry"
+)
+
+var challengeParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// tokenTransport authenticates requests against a registry v2 token server.
+// Unlike the vendored TokenTransport, it caches tokens per repository, so that
+// requests with a streamed body (such as layer uploads) are sent with a valid
+// token up front and never have to be replayed.
+type tokenTransport struct {
+	Transport  http.RoundTripper
+	Credential Credential
+
+	mu     sync.Mutex
+	tokens map[string]string // host + repository -> bearer token
+}
+
+type bearerChallenge struct {
+	realm   string
+	service string
+	scope   string
+}
+
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.URL.Host + "/" + repositoryOf(req.URL.Path)
+	if token := t.cachedToken(key); token != "" {
+		req = cloneRequest(req)
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := parseBearerChallenge(resp.Header)
+	if challenge == nil {
+		return resp, nil
+	}
+	// the body is consumed, and there is no way to get it again
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	token, err := t.fetchToken(challenge)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	t.storeToken(key, token)
+
+	retry := cloneRequest(req)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", "Bearer "+token)
+	resp.Body.Close()
+	return t.Transport.RoundTrip(retry)
+}
+
+func (t *tokenTransport) cachedToken(key string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tokens[key]
+}
+
+func (t *tokenTransport) storeToken(key, token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tokens == nil {
+		t.tokens = make(map[string]string)
+	}
+	t.tokens[key] = token
+}
+
+func (t *tokenTransport) fetchToken(challenge *bearerChallenge) (string, error) {
+	authURL, err := url.Parse(challenge.realm)
+	if err != nil {
+		return "", err
+	}
+	q := authURL.Query()
+	if challenge.service != "" {
+		q.Set("service", challenge.service)
+	}
+	if challenge.scope != "" {
+		q.Set("scope", challenge.scope)
+	}
+	authURL.RawQuery = q.Encode()
+
+	req, err := t.tokenRequest(authURL, challenge)
+	if err != nil {
+		return "", err
+	}
+	glog.V(6).Infof("fetch registry token, realm:%s, service:%s, scope:%s\n", challenge.realm, challenge.service, challenge.scope)
+
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token server %s returns status %d", challenge.realm, resp.StatusCode)
+	}
+
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", err
+	}
+	if tr.Token != "" {
+		return tr.Token, nil
+	}
+	return tr.AccessToken, nil
+}
+
+// tokenRequest returns the request fetching a token from authURL, the identity token
+// is exchanged through the oauth2 refresh token grant, otherwise basic auth is used
+func (t *tokenTransport) tokenRequest(authURL *url.URL, challenge *bearerChallenge) (*http.Request, error) {
+	cred := t.Credential
+	if cred.IdentityToken == "" {
+		req, err := http.NewRequest("GET", authURL.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if cred.Username != "" || cred.Password != "" {
+			req.SetBasicAuth(cred.Username, cred.Password)
+		}
+		return req, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", cred.IdentityToken)
+	form.Set("client_id", "image-sync")
+	form.Set("service", challenge.service)
+	if challenge.scope != "" {
+		form.Set("scope", challenge.scope)
+	}
+	authURL.RawQuery = ""
+	req, err := http.NewRequest("POST", authURL.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// parseBearerChallenge returns the bearer challenge in WWW-Authenticate header,
+// nil if the registry asks for another scheme
+func parseBearerChallenge(header http.Header) *bearerChallenge {
+	for _, h := range header[http.CanonicalHeaderKey("WWW-Authenticate")] {
+		arr := strings.SplitN(strings.TrimSpace(h), " ", 2)
+		if len(arr) != 2 || !strings.EqualFold(arr[0], "bearer") {
+			continue
+		}
+		challenge := &bearerChallenge{}
+		for _, match := range challengeParamRegexp.FindAllStringSubmatch(arr[1], -1) {
+			switch strings.ToLower(match[1]) {
+			case "realm":
+				challenge.realm = match[2]
+			case "service":
+				challenge.service = match[2]
+			case "scope":
+				challenge.scope = match[2]
+			}
+		}
+		return challenge
+	}
+	return nil
+}
+
+// repositoryOf returns the repository name in a registry v2 api path,
+// for example, library/busybox for /v2/library/busybox/blobs/uploads/
+func repositoryOf(path string) string {
+	if !strings.HasPrefix(path, "/v2/") {
+		return ""
+	}
+	path = strings.TrimPrefix(path, "/v2/")
+	for _, sep := range []string{"/manifests/", "/blobs/", "/tags/"} {
+		if idx := strings.LastIndex(path, sep); idx >= 0 {
+			return path[:idx]
+		}
+	}
+	return ""
+}
+
+func cloneRequest(req *http.Request) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	return r
+}
+
+// apiErrors counts failed registry api requests
+var apiErrors = metrics.NewCounter("image_sync_registry_api_errors_total",
+	"Registry api requests failed after retries, by registry and status code, network errors have code \"error\". 404 includes existence checks.",
+	"registry", "code")
+
+// errorCountingTransport counts requests which fail or return an error
+// status in apiErrors
+type errorCountingTransport struct {
+	Transport http.RoundTripper
+	registry  string
+}
+
+func (t *errorCountingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	switch {
+	case err != nil:
+		apiErrors.Inc(t.registry, "error")
+	case resp.StatusCode >= 400:
+		apiErrors.Inc(t.registry, strconv.Itoa(resp.StatusCode))
+	}
+	return resp, err
+}
+
+// newV2Registry creates a registry v2 client which authenticates with
+// cred, the registry is pinged if ping is true
+func newV2Registry(registryURL string, cred Credential, ping bool) (*registryV2.Registry, error) {
+	registryURL = strings.TrimSuffix(registryURL, "/")
+	var base http.RoundTripper = http.DefaultTransport
+	if normalizeRegistryHost(registryURL) == "index.docker.io" {
+		// pulls from docker hub are rate limited
+		base = &dockerhub.RateLimitTransport{Transport: base, Limit: dockerhub.PullLimit}
+	}
+	basic := &registryV2.BasicTransport{
+		Transport: &tokenTransport{
+			// transient failures are retried beneath authentication, so that
+			// a retried request keeps its token
+			Transport:  &retry.Transport{Transport: base},
+			Credential: cred,
+		},
+		URL: registryURL,
+	}
+	// identity tokens are only accepted by token servers
+	if cred.IdentityToken == "" {
+		basic.Username, basic.Password = cred.Username, cred.Password
+	}
+	transport := &registryV2.ErrorTransport{
+		Transport: &errorCountingTransport{Transport: basic, registry: normalizeRegistryHost(registryURL)},
+	}
+	reg := &registryV2.Registry{
+		URL:    registryURL,
+		Client: &http.Client{Transport: transport},
+		Logf:   registryV2.Quiet,
+	}
+	if ping {
+		if err := reg.Ping(); err != nil {
+			return nil, err
+		}
+	}
+	return reg, nil
+}
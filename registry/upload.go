@@ -0,0 +1,407 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/distribution/digest"
+	"github.com/golang/glog"
+
+	registryV2 "github.com/oscarzhao/docker-registry-client/registry"
+)
+
+// DefaultChunkSize is the size of PATCH requests of blob uploads if CopyOptions sets none
+const DefaultChunkSize = 32 << 20
+
+// maxUploadResumes is the number of times an interrupted blob upload is resumed
+const maxUploadResumes = 3
+
+// UploadSessions keeps the locations of unfinished blob uploads, so that an
+// upload interrupted in a run is resumed by the next one
+type UploadSessions interface {
+	Get(key string) string
+	Put(key, location string)
+	Delete(key string)
+}
+
+// fileUploadSessions keeps upload locations in memory, and in a json file if path is set
+type fileUploadSessions struct {
+	path string
+
+	mu        sync.Mutex
+	locations map[string]string
+}
+
+// NewUploadSessions returns upload sessions saved to the json file at path,
+// sessions saved by a previous run are loaded. Sessions are kept in memory
+// only if path is empty.
+func NewUploadSessions(path string) (UploadSessions, error) {
+	s := &fileUploadSessions{path: path, locations: make(map[string]string)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.locations); err != nil {
+		return nil, fmt.Errorf("invalid upload sessions %s, error:%s", path, err)
+	}
+	return s, nil
+}
+
+func (s *fileUploadSessions) Get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.locations[key]
+}
+
+func (s *fileUploadSessions) Put(key, location string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locations[key] == location {
+		return
+	}
+	s.locations[key] = location
+	s.save()
+}
+
+func (s *fileUploadSessions) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.locations[key]; !ok {
+		return
+	}
+	delete(s.locations, key)
+	s.save()
+}
+
+// save writes the sessions to a temporary file renamed to path, so that a
+// crash never leaves a truncated file. Failures are logged only, uploads
+// can always be started again.
+func (s *fileUploadSessions) save() {
+	if s.path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(s.locations, "", "  ")
+	if err == nil {
+		tmp := s.path + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, s.path)
+		}
+	}
+	if err != nil {
+		glog.Warningf("save upload sessions to %s fails, error:%s\n", s.path, err)
+	}
+}
+
+// chunkSize returns the size of PATCH requests of blob uploads
+func (o *CopyOptions) chunkSize() int64 {
+	if o == nil || o.ChunkSize <= 0 {
+		return DefaultChunkSize
+	}
+	return o.ChunkSize
+}
+
+// sessions returns where upload locations are kept, nil if they are not
+func (o *CopyOptions) sessions() UploadSessions {
+	if o == nil {
+		return nil
+	}
+	return o.Sessions
+}
+
+// blobRepo returns the destination repo blob dgst left out of an archive is
+// mounted from, empty if it is not left out
+func (o *CopyOptions) blobRepo(dgst digest.Digest) string {
+	if o == nil {
+		return ""
+	}
+	return o.BlobRepos[dgst.String()]
+}
+
+// blobOpener reads a blob from offset
+type blobOpener func(offset int64) (io.ReadCloser, error)
+
+// blobUpload uploads a blob to dst in chunks
+type blobUpload struct {
+	open      blobOpener
+	dst       *registryV2.Registry
+	dstRepo   string
+	dgst      digest.Digest
+	chunkSize int64
+	sessions  UploadSessions
+	key       string
+
+	// location and offset are where the upload continues, offset is -1 if
+	// it has to be asked from the registry
+	location *url.URL
+	offset   int64
+}
+
+// uploadBlob uploads blob dgst of srcRepo in src to dstRepo in dst with chunked
+// PATCH requests. An interrupted upload is resumed from the offset reported by
+// the registry, and the rest of the blob is read from src with a ranged GET.
+// location is an upload started already, such as by a refused mount, or nil.
+// It returns the number of bytes transferred.
+func uploadBlob(src, dst *registryV2.Registry, srcRepo, dstRepo string, dgst digest.Digest, location *url.URL, opts *CopyOptions) (int64, error) {
+	open := func(offset int64) (io.ReadCloser, error) {
+		return openBlob(src, srcRepo, dgst, offset)
+	}
+	return uploadBlobFrom(open, dst, dstRepo, dgst, location, opts)
+}
+
+// uploadBlobFrom uploads blob dgst read by open to dstRepo in dst, the same
+// way as uploadBlob
+func uploadBlobFrom(open blobOpener, dst *registryV2.Registry, dstRepo string, dgst digest.Digest, location *url.URL, opts *CopyOptions) (int64, error) {
+	u := &blobUpload{
+		open:      open,
+		dst:       dst,
+		dstRepo:   dstRepo,
+		dgst:      dgst,
+		chunkSize: opts.chunkSize(),
+		sessions:  opts.sessions(),
+		key:       dst.URL + "/" + dstRepo + "@" + dgst.String(),
+		location:  location,
+	}
+	if u.location == nil && u.sessions != nil {
+		if saved := u.sessions.Get(u.key); saved != "" {
+			if loc, err := url.Parse(saved); err == nil {
+				glog.V(4).Infof("resume the upload of layer %s to %s, location:%s\n", dgst, dstRepo, saved)
+				u.location, u.offset = loc, -1
+			}
+		}
+	}
+
+	var total int64
+	for attempt := 0; ; attempt++ {
+		n, err := u.upload()
+		total += n
+		if err == nil {
+			if u.sessions != nil {
+				u.sessions.Delete(u.key)
+			}
+			return total, nil
+		}
+		if attempt >= maxUploadResumes {
+			return total, err
+		}
+		glog.Warningf("upload of layer %s to %s is interrupted, resume it, error:%s\n", dgst, dstRepo, err)
+		u.offset = -1
+	}
+}
+
+// upload sends the blob from the offset of the upload session to its end
+func (u *blobUpload) upload() (int64, error) {
+	if u.location != nil && u.offset < 0 {
+		offset, err := uploadStatus(u.dst, u.location)
+		if IsNotFound(err) {
+			// the session expired, start it over
+			glog.V(4).Infof("upload session of layer %s to %s is gone, start it over\n", u.dgst, u.dstRepo)
+			u.location = nil
+		} else if err != nil {
+			return 0, pushError("get upload status of layer %s fails, error:%s", u.dgst, err)
+		} else {
+			u.offset = offset
+		}
+	}
+	if u.location == nil {
+		location, err := startUpload(u.dst, u.dstRepo)
+		if err != nil {
+			return 0, pushError("start upload of layer %s fails, error:%s", u.dgst, err)
+		}
+		u.location, u.offset = location, 0
+	}
+	u.save()
+
+	reader, err := u.open(u.offset)
+	if err != nil {
+		return 0, pullError("download layer %s fails, error:%s", u.dgst, err)
+	}
+	defer reader.Close()
+
+	var sent int64
+	buf := make([]byte, u.chunkSize)
+	for {
+		n, eof, err := readChunk(reader, buf)
+		if err != nil {
+			return sent, pullError("download layer %s fails, error:%s", u.dgst, err)
+		}
+		if n > 0 {
+			if u.location, u.offset, err = patchChunk(u.dst, u.location, u.offset, buf[:n]); err != nil {
+				u.offset = -1
+				return sent, pushError("upload layer %s fails, error:%s", u.dgst, err)
+			}
+			sent += int64(n)
+			u.save()
+		}
+		if eof {
+			break
+		}
+	}
+
+	if err := finishUpload(u.dst, u.location, u.dgst); err != nil {
+		u.offset = -1
+		return sent, pushError("upload layer %s fails, error:%s", u.dgst, err)
+	}
+	return sent, nil
+}
+
+// save keeps the location of the upload, so that a later run can resume it
+func (u *blobUpload) save() {
+	if u.sessions != nil {
+		u.sessions.Put(u.key, u.location.String())
+	}
+}
+
+// readChunk fills buf from r, eof is true if r ends
+func readChunk(r io.Reader, buf []byte) (n int, eof bool, err error) {
+	for n < len(buf) {
+		m, err := r.Read(buf[n:])
+		n += m
+		if err == io.EOF {
+			return n, true, nil
+		}
+		if err != nil {
+			return n, false, err
+		}
+	}
+	return n, false, nil
+}
+
+// openBlob reads blob dgst of repo from offset, bytes before offset are
+// skipped if the registry ignores the range
+func openBlob(reg *registryV2.Registry, repo string, dgst digest.Digest, offset int64) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", reg.URL+fmt.Sprintf("/v2/%s/blobs/%s", repo, dgst), nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := reg.Client.Do(req)
+	if err != nil {
+		if offset > 0 && statusCode(err) == http.StatusRequestedRangeNotSatisfiable {
+			// the registry has received the whole blob already
+			return ioutil.NopCloser(bytes.NewReader(nil)), nil
+		}
+		return nil, err
+	}
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		glog.V(6).Infof("registry %s ignores the range of layer %s, skip %d bytes\n", reg.URL, dgst, offset)
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	return resp.Body, nil
+}
+
+// startUpload starts an upload session in repo, and returns its location
+func startUpload(reg *registryV2.Registry, repo string) (*url.URL, error) {
+	resp, err := reg.Client.Post(reg.URL+fmt.Sprintf("/v2/%s/blobs/uploads/", repo), "application/octet-stream", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp.Request.URL.Parse(resp.Header.Get("Location"))
+}
+
+// uploadStatus returns the number of bytes the upload session at location has received
+func uploadStatus(reg *registryV2.Registry, location *url.URL) (int64, error) {
+	resp, err := reg.Client.Get(location.String())
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	r := resp.Header.Get("Range")
+	if r == "0-0" {
+		// distribution reports an empty session so
+		return 0, nil
+	}
+	return rangeEnd(r)
+}
+
+// patchChunk sends chunk to the upload session at location from offset, and
+// returns the location and the offset to continue with. It fails if the
+// registry acknowledges part of chunk only, the source has been read past it,
+// so the upload is resumed from the offset the registry reports.
+func patchChunk(reg *registryV2.Registry, location *url.URL, offset int64, chunk []byte) (*url.URL, int64, error) {
+	req, err := http.NewRequest("PATCH", location.String(), bytes.NewReader(chunk))
+	if err != nil {
+		return location, offset, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(len(chunk))-1))
+	glog.V(6).Infof("upload chunk %d-%d to %s\n", offset, offset+int64(len(chunk))-1, location)
+
+	resp, err := reg.Client.Do(req)
+	if err != nil {
+		return location, offset, err
+	}
+	resp.Body.Close()
+
+	if l := resp.Header.Get("Location"); l != "" {
+		if location, err = resp.Request.URL.Parse(l); err != nil {
+			return location, offset, err
+		}
+	}
+	next := offset + int64(len(chunk))
+	if r := resp.Header.Get("Range"); r != "" {
+		received, err := rangeEnd(r)
+		if err != nil {
+			return location, offset, err
+		}
+		if received != next {
+			return location, offset, fmt.Errorf("registry acknowledges %d bytes, %d are sent", received, next)
+		}
+	}
+	return location, next, nil
+}
+
+// finishUpload completes the upload session at location as blob dgst
+func finishUpload(reg *registryV2.Registry, location *url.URL, dgst digest.Digest) error {
+	finishURL := *location
+	q := finishURL.Query()
+	q.Set("digest", dgst.String())
+	finishURL.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("PUT", finishURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := reg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// rangeEnd parses Range header "0-<last>" of upload responses, and returns
+// the number of bytes received
+func rangeEnd(r string) (int64, error) {
+	r = strings.TrimPrefix(r, "bytes=")
+	i := strings.Index(r, "-")
+	if i < 0 {
+		return 0, fmt.Errorf("invalid range %q", r)
+	}
+	last, err := strconv.ParseInt(r[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid range %q", r)
+	}
+	return last + 1, nil
+}
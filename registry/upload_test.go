@@ -0,0 +1,124 @@
+package registry
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/distribution/digest"
+)
+
+func TestUploadBlob(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	testCases := []struct {
+		name          string
+		chunkSize     int64
+		failPatches   int
+		shortPatches  int
+		truncateBlobs int
+		patches       int
+	}{
+		{"one chunk", 0, 0, 0, 0, 1},
+		{"chunks", 8, 0, 0, 0, 3},
+		{"patch fails", 8, 2, 0, 0, 2},
+		{"patch acknowledged short", 8, 0, 1, 0, 2},
+		{"download truncated", 8, 0, 0, 1, 3},
+		{"both", 4, 1, 0, 2, 5},
+	}
+	for _, tc := range testCases {
+		src := newFakeRegistry(t)
+		dst := newFakeRegistry(t)
+		dgst := src.addBlob("library/busybox", content)
+		dst.failPatches, dst.shortPatches, src.truncateBlobs = tc.failPatches, tc.shortPatches, tc.truncateBlobs
+
+		n, err := uploadBlob(src.client(t).RegClientV2, dst.client(t).RegClientV2, "library/busybox", "docker_library/busybox", digest.Digest(dgst), nil, &CopyOptions{ChunkSize: tc.chunkSize})
+		if err != nil {
+			t.Errorf("%s: upload should succeed, error:%s\n", tc.name, err)
+			continue
+		}
+		if !bytes.Equal(dst.blobs[dgst], content) {
+			t.Errorf("%s: blob should be uploaded, got %q\n", tc.name, dst.blobs[dgst])
+		}
+		if dst.blobPatches != tc.patches || dst.uploadStarts != 1 {
+			t.Errorf("%s: expected %d patches of one upload, got %d patches of %d uploads\n", tc.name, tc.patches, dst.blobPatches, dst.uploadStarts)
+		}
+		if tc.failPatches == 0 && tc.shortPatches == 0 && n != int64(len(content)) {
+			t.Errorf("%s: %d bytes should be transferred, got %d\n", tc.name, len(content), n)
+		}
+	}
+}
+
+func TestUploadBlobResume(t *testing.T) {
+	content := []byte("0123456789abcdefghij")
+	src := newFakeRegistry(t)
+	dst := newFakeRegistry(t)
+	dgst := digest.Digest(src.addBlob("library/busybox", content))
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	sessions, err := NewUploadSessions(path)
+	if err != nil {
+		t.Fatalf("create upload sessions fails, error:%s\n", err)
+	}
+
+	// every attempt of the run stores half a chunk before it fails
+	dst.failPatches = 1 + maxUploadResumes
+	srcReg, dstReg := src.client(t).RegClientV2, dst.client(t).RegClientV2
+	if _, err := uploadBlob(srcReg, dstReg, "library/busybox", "docker_library/busybox", dgst, nil, &CopyOptions{ChunkSize: 8, Sessions: sessions}); err == nil {
+		t.Fatalf("upload should fail\n")
+	}
+	dst.failPatches = 0
+	if _, err := ioutil.ReadFile(path); err != nil {
+		t.Fatalf("upload location should be saved, error:%s\n", err)
+	}
+
+	// the next run resumes the upload
+	sessions, err = NewUploadSessions(path)
+	if err != nil {
+		t.Fatalf("load upload sessions fails, error:%s\n", err)
+	}
+	n, err := uploadBlob(srcReg, dstReg, "library/busybox", "docker_library/busybox", dgst, nil, &CopyOptions{ChunkSize: 8, Sessions: sessions})
+	if err != nil {
+		t.Fatalf("resumed upload should succeed, error:%s\n", err)
+	}
+	if !bytes.Equal(dst.blobs[dgst.String()], content) {
+		t.Errorf("blob should be uploaded, got %q\n", dst.blobs[dgst.String()])
+	}
+	if dst.uploadStarts != 1 {
+		t.Errorf("upload should be resumed instead of started again, uploads:%d\n", dst.uploadStarts)
+	}
+	if n >= int64(len(content)) {
+		t.Errorf("only the rest of the blob should be transferred, got %d bytes\n", n)
+	}
+	if location := sessions.Get(dstReg.URL + "/docker_library/busybox@" + dgst.String()); location != "" {
+		t.Errorf("finished upload should be forgotten, got %s\n", location)
+	}
+
+	// an expired session is started over
+	sessions.Put(dstReg.URL+"/docker_library/busybox@"+dgst.String(), dstReg.URL+"/v2/docker_library/busybox/blobs/uploads/expired")
+	delete(dst.blobs, dgst.String())
+	if _, err := uploadBlob(srcReg, dstReg, "library/busybox", "docker_library/busybox", dgst, nil, &CopyOptions{Sessions: sessions}); err != nil {
+		t.Errorf("upload with an expired session should succeed, error:%s\n", err)
+	}
+	if dst.uploadStarts != 2 {
+		t.Errorf("expired upload should be started again, uploads:%d\n", dst.uploadStarts)
+	}
+}
+
+func TestRangeEnd(t *testing.T) {
+	testCases := []struct {
+		r        string
+		expected int64
+		valid    bool
+	}{
+		{"0-9", 10, true},
+		{"bytes=0-99", 100, true},
+		{"0", 0, false},
+		{"0-x", 0, false},
+	}
+	for _, tc := range testCases {
+		n, err := rangeEnd(tc.r)
+		if (err == nil) != tc.valid || n != tc.expected {
+			t.Errorf("range %q should be %d (valid:%v), got %d, error:%v\n", tc.r, tc.expected, tc.valid, n, err)
+		}
+	}
+}
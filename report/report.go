@@ -0,0 +1,214 @@
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status is the outcome of synchronizing an image
+type Status string
+
+const (
+	StatusCopied       Status = "copied"
+	StatusSkipped      Status = "skipped"
+	StatusListFailed   Status = "list-failed"
+	StatusPullFailed   Status = "pull-failed"
+	StatusTagFailed    Status = "tag-failed"
+	StatusPushFailed   Status = "push-failed"
+	StatusDeleteFailed Status = "delete-failed"
+	// StatusPruned and StatusPruneFailed are destination tags deleted since they are gone upstream
+	StatusPruned      Status = "pruned"
+	StatusPruneFailed Status = "prune-failed"
+)
+
+// Failed returns true if s is a failure
+func (s Status) Failed() bool {
+	return s != StatusCopied && s != StatusSkipped && s != StatusPruned
+}
+
+// Entry is the outcome of an image, or of a repo whose tags cannot be listed.
+// Source of pruned tags is empty.
+type Entry struct {
+	Job         string  `json:"job"`
+	Status      Status  `json:"status"`
+	Source      string  `json:"source"`
+	Destination string  `json:"destination,omitempty"`
+	Digest      string  `json:"digest,omitempty"`
+	Bytes       int64   `json:"bytes"`
+	Duration    float64 `json:"durationSeconds"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// RateLimitWaits are the pauses made to stay within the rate limit of a service
+type RateLimitWaits struct {
+	Waits       int     `json:"waits"`
+	WaitSeconds float64 `json:"waitSeconds"`
+}
+
+// Report collects entries of a run, it is safe for concurrent use
+type Report struct {
+	mu        sync.Mutex
+	StartTime time.Time      `json:"startTime"`
+	EndTime   time.Time      `json:"endTime"`
+	Summary   map[Status]int `json:"summary"`
+	Entries   []Entry        `json:"entries"`
+
+	// RateLimits maps rate limited services to the pauses made, services
+	// never paused are omitted
+	RateLimits map[string]RateLimitWaits `json:"rateLimits,omitempty"`
+}
+
+// New creates a report starting now
+func New() *Report {
+	return &Report{StartTime: time.Now(), Summary: make(map[Status]int)}
+}
+
+// Add appends an entry to r
+func (r *Report) Add(e Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Entries = append(r.Entries, e)
+	r.Summary[e.Status]++
+}
+
+// AddRateLimitWaits records waits pauses lasting d in total made for the rate limit of service
+func (r *Report) AddRateLimitWaits(service string, waits int, d time.Duration) {
+	if waits == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.RateLimits == nil {
+		r.RateLimits = make(map[string]RateLimitWaits)
+	}
+	w := r.RateLimits[service]
+	w.Waits += waits
+	w.WaitSeconds += d.Seconds()
+	r.RateLimits[service] = w
+}
+
+// Failed returns true if any entry fails
+func (r *Report) Failed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for status, count := range r.Summary {
+		if status.Failed() && count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Finish sets the end time of r, and sorts entries by job and source
+func (r *Report) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.EndTime = time.Now()
+	sort.SliceStable(r.Entries, func(i, j int) bool {
+		if r.Entries[i].Job != r.Entries[j].Job {
+			return r.Entries[i].Job < r.Entries[j].Job
+		}
+		return r.Entries[i].Source < r.Entries[j].Source
+	})
+}
+
+// WriteJSON writes r to w in json
+func (r *Report) WriteJSON(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Type    string `xml:"type,attr"`
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes r to w as junit xml, each job is a test suite and each image a test case
+func (r *Report) WriteJUnit(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var suites junitTestSuites
+	index := make(map[string]int)
+	durations := make(map[string]float64)
+	for _, e := range r.Entries {
+		i, ok := index[e.Job]
+		if !ok {
+			i = len(suites.Suites)
+			index[e.Job] = i
+			suites.Suites = append(suites.Suites, junitTestSuite{Name: e.Job})
+		}
+		suite := &suites.Suites[i]
+		name := e.Source
+		if name == "" {
+			name = e.Destination
+		}
+		tc := junitTestCase{ClassName: e.Job, Name: name, Time: fmt.Sprintf("%.3f", e.Duration)}
+		switch {
+		case e.Status == StatusSkipped:
+			tc.Skipped = &struct{}{}
+			suite.Skipped++
+		case e.Status.Failed():
+			tc.Failure = &junitFailure{Type: string(e.Status), Message: e.Error, Text: e.Error}
+			suite.Failures++
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+		durations[e.Job] += e.Duration
+	}
+	for i := range suites.Suites {
+		suites.Suites[i].Time = fmt.Sprintf("%.3f", durations[suites.Suites[i].Name])
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	return encoder.Encode(suites)
+}
+
+// WriteFile writes r to path with write, which is WriteJSON or WriteJUnit
+func WriteFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,85 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+func newTestReport() *Report {
+	r := New()
+	r.Add(Entry{Job: "hub", Status: StatusCopied, Source: "library/busybox:latest", Destination: "docker_library/busybox:latest", Digest: "sha256:abc", Bytes: 100})
+	r.Add(Entry{Job: "hub", Status: StatusPushFailed, Source: "library/alpine:3.4", Destination: "docker_library/alpine:3.4", Error: "unauthorized"})
+	r.Add(Entry{Job: "gcr", Status: StatusSkipped, Source: "gcr.io/google_containers/pause:3.0"})
+	r.AddRateLimitWaits("docker hub pull", 2, 90*time.Second)
+	r.AddRateLimitWaits("docker hub api", 0, 0)
+	r.Finish()
+	return r
+}
+
+func TestFailed(t *testing.T) {
+	testCases := []struct {
+		statuses []Status
+		expected bool
+	}{
+		{nil, false},
+		{[]Status{StatusCopied, StatusSkipped}, false},
+		{[]Status{StatusCopied, StatusDeleteFailed}, true},
+		{[]Status{StatusListFailed}, true},
+		{[]Status{StatusSkipped, StatusPruned}, false},
+		{[]Status{StatusPruneFailed}, true},
+	}
+	for _, tc := range testCases {
+		r := New()
+		for _, status := range tc.statuses {
+			r.Add(Entry{Status: status})
+		}
+		if r.Failed() != tc.expected {
+			t.Errorf("report of %v failed should be %v\n", tc.statuses, tc.expected)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newTestReport().WriteJSON(&buf); err != nil {
+		t.Fatalf("write json should succeed, error:%s\n", err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid json report, error:%s\n", err)
+	}
+	if len(decoded.Entries) != 3 || decoded.Summary[StatusPushFailed] != 1 {
+		t.Errorf("unexpected report: %s\n", buf.String())
+	}
+	// entries are sorted by job
+	if decoded.Entries[0].Job != "gcr" || decoded.Entries[1].Source != "library/alpine:3.4" {
+		t.Errorf("entries should be sorted by job and source, got %+v\n", decoded.Entries)
+	}
+	if w := decoded.RateLimits["docker hub pull"]; w.Waits != 2 || w.WaitSeconds != 90 || len(decoded.RateLimits) != 1 {
+		t.Errorf("rate limit waits should be reported, got %+v\n", decoded.RateLimits)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := newTestReport().WriteJUnit(&buf); err != nil {
+		t.Fatalf("write junit should succeed, error:%s\n", err)
+	}
+	var decoded junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid junit report, error:%s\n", err)
+	}
+	if len(decoded.Suites) != 2 {
+		t.Fatalf("each job should be a suite, got %d\n", len(decoded.Suites))
+	}
+	gcr, hub := decoded.Suites[0], decoded.Suites[1]
+	if gcr.Tests != 1 || gcr.Skipped != 1 || gcr.Cases[0].Skipped == nil {
+		t.Errorf("unexpected suite: %+v\n", gcr)
+	}
+	if hub.Tests != 2 || hub.Failures != 1 || hub.Cases[0].Failure == nil || hub.Cases[0].Failure.Type != "push-failed" {
+		t.Errorf("unexpected suite: %+v\n", hub)
+	}
+}
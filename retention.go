@@ -0,0 +1,178 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/oscarzhao/image-sync/config"
+	"github.com/oscarzhao/image-sync/plan"
+	"github.com/oscarzhao/image-sync/registry"
+	"github.com/oscarzhao/image-sync/report"
+)
+
+// retainedImages drops the tags which the retention of their repo would delete
+// from the destination, so that they are not copied again by every run. The
+// update time of tags is known if the source registry reports it. Protected
+// tags are never deleted, so they are kept.
+func (s *syncer) retainedImages(repo2tags map[string][]string) map[string][]string {
+	now := time.Now()
+	res := make(map[string][]string, len(repo2tags))
+	for repo, tags := range repo2tags {
+		policy := s.job.RetentionOf(s.relativeRepo(repo))
+		if !policy.Enabled() {
+			res[repo] = tags
+			continue
+		}
+		infos := make([]config.Tag, 0, len(tags))
+		for _, tag := range tags {
+			infos = append(infos, config.Tag{Name: tag, Updated: s.upstream[repo][tag]})
+		}
+		expired := make(map[string]bool)
+		for _, tag := range policy.Expired(infos, now) {
+			expired[tag] = !s.job.Prune.Protected(tag)
+		}
+		kept := make([]string, 0, len(tags))
+		for _, tag := range tags {
+			if expired[tag] {
+				glog.V(2).Infof("job %s, %s:%s is not kept by the retention of the destination, it is not synchronized\n", s.job.Name, repo, tag)
+				continue
+			}
+			kept = append(kept, tag)
+		}
+		res[repo] = kept
+	}
+	return res
+}
+
+// retain deletes the destination tags of repos in repo2tags which their
+// retention does not keep, tags in repo2tags are synchronized by the run and
+// always kept. Nothing is deleted if more tags than the max deletions of the
+// job would be.
+func (s *syncer) retain(repo2tags map[string][]string) {
+	fail := func(destination string, err error) {
+		glog.Errorf("job %s, apply retention to %s fails, error:%s\n", s.job.Name, destination, err)
+		s.recordPrune(destination, "", report.StatusPruneFailed, err)
+	}
+	deletions := s.retentionDeletions(repo2tags, fail)
+	if err := s.checkMaxDeletions(deletions); err != nil {
+		fail(s.job.Destination.Namespace, err)
+		return
+	}
+	s.deleteTags(deletions)
+}
+
+// planRetention adds the destination tags retain would delete to p, tags in
+// pruned are deleted by prune first
+func (s *syncer) planRetention(p *plan.Plan, repo2tags map[string][]string, pruned []deletion) {
+	fail := func(destination string, err error) {
+		glog.Errorf("job %s, plan retention of %s fails, error:%s\n", s.job.Name, destination, err)
+		p.Add(plan.Entry{Job: s.job.Name, Action: plan.ActionError, Destination: destination, Error: err.Error()})
+	}
+	isPruned := make(map[deletion]bool, len(pruned))
+	for _, d := range pruned {
+		isPruned[d] = true
+	}
+	var deletions []deletion
+	for _, d := range s.retentionDeletions(repo2tags, fail) {
+		if !isPruned[d] {
+			deletions = append(deletions, d)
+		}
+	}
+	if err := s.checkMaxDeletions(deletions); err != nil {
+		fail(s.job.Destination.Namespace, err)
+		return
+	}
+	for _, d := range deletions {
+		dst := Image{s.job.Destination.Registry, d.repo, d.tag}
+		p.Add(plan.Entry{Job: s.job.Name, Action: plan.ActionPrune, Destination: dst.String(), DestinationDigest: d.digest})
+	}
+}
+
+// retentionDeletions returns the destination tags of repos in repo2tags which
+// their retention does not keep, protected tags are left alone. fail is called
+// for destination repos which cannot be inspected.
+func (s *syncer) retentionDeletions(repo2tags map[string][]string, fail func(destination string, err error)) []deletion {
+	repos := make([]string, 0, len(repo2tags))
+	for repo := range repo2tags {
+		if s.job.RetentionOf(s.relativeRepo(repo)).Enabled() {
+			repos = append(repos, repo)
+		}
+	}
+	sort.Strings(repos)
+
+	now := time.Now()
+	var deletions []deletion
+	for _, repo := range repos {
+		if stopped() {
+			return deletions
+		}
+		policy := s.job.RetentionOf(s.relativeRepo(repo))
+		dstRepo := s.dstImage(Image{repo: repo}).repo
+		synced := make(map[string]bool, len(repo2tags[repo]))
+		for _, tag := range repo2tags[repo] {
+			synced[tag] = true
+		}
+
+		release := s.limiter.acquire(s.job.Destination.Registry)
+		infos, err := s.dstClient.ListTagInfos(dstRepo)
+		release()
+		if registry.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			fail(dstRepo, err)
+			continue
+		}
+		tags, names, err := s.pushTimes(dstRepo, infos, policy, synced)
+		if err != nil {
+			fail(dstRepo, err)
+			continue
+		}
+		var expired []string
+		for _, tag := range policy.Expired(tags, now) {
+			if !synced[tag] && !s.job.Prune.Protected(tag) {
+				expired = append(expired, tag)
+			}
+		}
+		if len(expired) == 0 {
+			continue
+		}
+		digests, err := s.tagDigests(dstRepo, names)
+		if err != nil {
+			fail(dstRepo, err)
+			continue
+		}
+		kept, shared := resolveDeletions(dstRepo, digests, expired)
+		for _, tag := range shared {
+			glog.Warningf("job %s, %s:%s is not kept by the retention, but a kept tag refers to the same manifest, it is not deleted\n", s.job.Name, dstRepo, tag)
+		}
+		deletions = append(deletions, kept...)
+	}
+	return deletions
+}
+
+// pushTimes returns the tags of a destination repo with the time they are
+// pushed, which is needed by keepWithin only. Registries other than docker
+// hub do not report it, the creation time of the image is taken instead.
+// Synchronized tags are kept anyway, their time is not fetched.
+func (s *syncer) pushTimes(repo string, infos []registry.TagInfo, policy *config.RetentionPolicy, synced map[string]bool) ([]config.Tag, []string, error) {
+	tags := make([]config.Tag, 0, len(infos))
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		tag := config.Tag{Name: info.Name, Updated: info.LastUpdated}
+		if policy.KeepWithin != "" && tag.Updated.IsZero() && !synced[tag.Name] {
+			release := s.limiter.acquire(s.job.Destination.Registry)
+			created, err := s.dstClient.ImageCreated(repo, tag.Name)
+			release()
+			if err != nil {
+				return nil, nil, err
+			}
+			tag.Updated = created
+		}
+		tags = append(tags, tag)
+		names = append(names, tag.Name)
+	}
+	return tags, names, nil
+}
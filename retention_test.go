@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/oscarzhao/image-sync/config"
+)
+
+func TestRetainedImages(t *testing.T) {
+	cfg, err := config.Parse([]byte(`
+jobs:
+- name: library
+  source: {namespace: library}
+  destination: {namespace: docker_library}
+  retention: {keepLatest: 2, keep: ["latest"]}
+  prune: {protect: ["1\\.34"]}
+  repoRetention:
+    nginx: {keepWithin: 30d}
+    alpine: {}
+`))
+	if err != nil {
+		t.Fatalf("parse config fails, error:%s\n", err)
+	}
+	now := time.Now()
+	s := &syncer{job: cfg.Jobs[0], upstream: map[string]map[string]time.Time{
+		"library/nginx": {"1.24": now.AddDate(0, -3, 0), "1.25": now.AddDate(0, 0, -3)},
+	}}
+	repo2tags := map[string][]string{
+		"library/busybox": {"latest", "1.34", "1.35", "1.36", "edge"},
+		"library/nginx":   {"1.24", "1.25", "mainline"},
+		"library/alpine":  {"3.18", "3.19"},
+	}
+	expected := map[string][]string{
+		"library/busybox": {"latest", "1.34", "1.35", "1.36"},
+		"library/nginx":   {"1.25", "mainline"},
+		"library/alpine":  {"3.18", "3.19"},
+	}
+	if retained := s.retainedImages(repo2tags); !reflect.DeepEqual(retained, expected) {
+		t.Errorf("expected retained images %v, got %v\n", expected, retained)
+	}
+}
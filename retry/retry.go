@@ -0,0 +1,178 @@
+// Package retry retries operations failed with transient errors, with
+// exponential backoff and jitter. It is shared by the docker hub client, the
+// registry client and the docker command wrappers.
+package retry
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Policy decides how many times and how long after an operation is retried
+type Policy struct {
+	// MaxAttempts is the number of attempts including the first one, 1 disables retries
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, it doubles after each retry
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between two attempts
+	MaxDelay time.Duration
+	// MaxRetryAfter is the longest Retry-After honored, an operation asked
+	// to wait longer fails instead
+	MaxRetryAfter time.Duration
+
+	// sleep waits between attempts, replaced in tests
+	sleep func(time.Duration)
+}
+
+// Default is the policy used by all packages, main sets it from flags
+var Default = Policy{
+	MaxAttempts:   4,
+	BaseDelay:     500 * time.Millisecond,
+	MaxDelay:      30 * time.Second,
+	MaxRetryAfter: 5 * time.Minute,
+}
+
+// Error marks an error retryable or fatal explicitly, and carries the delay
+// asked by the server
+type Error struct {
+	Err        error
+	Retryable  bool
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Transient marks err retryable
+func Transient(err error) error {
+	return &Error{Err: err, Retryable: true}
+}
+
+// Fatal marks err not retryable
+func Fatal(err error) error {
+	return &Error{Err: err}
+}
+
+// Do calls fn until it succeeds, fails with an error which is not retryable,
+// or p.MaxAttempts is reached. The last error is returned.
+func (p Policy) Do(op string, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt >= p.MaxAttempts || !Retryable(err) {
+			return err
+		}
+		delay := p.Delay(attempt)
+		if after := retryAfter(err); after > 0 {
+			if after > p.MaxRetryAfter {
+				glog.Warningf("%s fails, server asks to retry after %s, give up, error:%s\n", op, after, err)
+				return err
+			}
+			if after > delay {
+				delay = after
+			}
+		}
+		glog.Warningf("%s fails, retry in %s (attempt %d/%d), error:%s\n", op, delay, attempt+1, p.MaxAttempts, err)
+		p.wait(delay)
+	}
+}
+
+// Delay returns the delay before the next attempt, after attempt attempts
+// failed. It is picked randomly between half and all of the exponential
+// backoff, so that clients failed together do not retry together.
+func (p Policy) Delay(attempt int) time.Duration {
+	backoff := p.BaseDelay
+	for i := 1; i < attempt && backoff < p.MaxDelay; i++ {
+		backoff *= 2
+	}
+	if p.MaxDelay > 0 && backoff > p.MaxDelay {
+		backoff = p.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func (p Policy) wait(d time.Duration) {
+	if p.sleep != nil {
+		p.sleep(d)
+		return
+	}
+	time.Sleep(d)
+}
+
+// Retryable returns true if err is transient: network errors, connections
+// closed halfway, and errors marked by Transient or returned by StatusError
+// for retryable status codes
+func Retryable(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Retryable
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// RetryableStatus returns true if a response of status code may succeed if sent again
+func RetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// StatusError returns the error of resp, marked retryable with its
+// Retry-After if the status code is retryable
+func StatusError(resp *http.Response, err error) error {
+	return &Error{Err: err, Retryable: RetryableStatus(resp.StatusCode), RetryAfter: ParseRetryAfter(resp.Header.Get("Retry-After"))}
+}
+
+// ParseRetryAfter parses Retry-After header in seconds or in http date, 0 if it is invalid
+func ParseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+func retryAfter(err error) time.Duration {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.RetryAfter
+	}
+	return 0
+}
@@ -0,0 +1,169 @@
+package retry
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPolicy retries 3 times, and records delays instead of sleeping
+func testPolicy(delays *[]time.Duration) Policy {
+	return Policy{
+		MaxAttempts:   3,
+		BaseDelay:     100 * time.Millisecond,
+		MaxDelay:      time.Second,
+		MaxRetryAfter: time.Minute,
+		sleep:         func(d time.Duration) { *delays = append(*delays, d) },
+	}
+}
+
+func TestDo(t *testing.T) {
+	testCases := []struct {
+		name     string
+		errs     []error
+		attempts int
+		success  bool
+		minDelay time.Duration
+	}{
+		{"success", nil, 1, true, 0},
+		{"transient", []error{io.ErrUnexpectedEOF, Transient(errors.New("503"))}, 3, true, 0},
+		{"exhausted", []error{io.ErrUnexpectedEOF, io.ErrUnexpectedEOF, io.ErrUnexpectedEOF, nil}, 3, false, 0},
+		{"fatal", []error{errors.New("unauthorized")}, 1, false, 0},
+		{"marked fatal", []error{Fatal(io.ErrUnexpectedEOF)}, 1, false, 0},
+		{"retry after", []error{&Error{Err: errors.New("429"), Retryable: true, RetryAfter: 10 * time.Second}}, 2, true, 10 * time.Second},
+		{"retry after too long", []error{&Error{Err: errors.New("429"), Retryable: true, RetryAfter: time.Hour}}, 1, false, 0},
+	}
+	for _, tc := range testCases {
+		var delays []time.Duration
+		attempts := 0
+		err := testPolicy(&delays).Do(tc.name, func() error {
+			attempts++
+			if attempts <= len(tc.errs) {
+				return tc.errs[attempts-1]
+			}
+			return nil
+		})
+		if (err == nil) != tc.success || attempts != tc.attempts {
+			t.Errorf("%s: expected %d attempts (success:%v), got %d, error:%v\n", tc.name, tc.attempts, tc.success, attempts, err)
+		}
+		if len(delays) != attempts-1 {
+			t.Errorf("%s: expected %d waits, got %v\n", tc.name, attempts-1, delays)
+		}
+		if len(delays) > 0 && delays[0] < tc.minDelay {
+			t.Errorf("%s: retry after %s should be honored, got %s\n", tc.name, tc.minDelay, delays[0])
+		}
+	}
+}
+
+func TestDelay(t *testing.T) {
+	p := Policy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	testCases := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{10, time.Second},
+	}
+	for _, tc := range testCases {
+		for i := 0; i < 20; i++ {
+			if d := p.Delay(tc.attempt); d < tc.max/2 || d > tc.max {
+				t.Errorf("delay after attempt %d should be within [%s, %s], got %s\n", tc.attempt, tc.max/2, tc.max, d)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-1", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, tc := range testCases {
+		if d := ParseRetryAfter(tc.value); d != tc.expected {
+			t.Errorf("retry after %q should be %s, got %s\n", tc.value, tc.expected, d)
+		}
+	}
+	if d := ParseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); d < 59*time.Minute {
+		t.Errorf("retry after an http date should be honored, got %s\n", d)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var requests []string
+	failures := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		requests = append(requests, req.Method+" "+req.URL.Path+" "+string(body))
+		switch req.URL.Path {
+		case "/flaky":
+			if failures < 2 {
+				failures++
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte("ok"))
+		case "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	var delays []time.Duration
+	policy := testPolicy(&delays)
+	client := &http.Client{Transport: &Transport{Transport: http.DefaultTransport, Policy: &policy}}
+	testCases := []struct {
+		name     string
+		req      func() *http.Request
+		status   int
+		requests int
+	}{
+		{"retried until success", func() *http.Request {
+			req, _ := http.NewRequest("PATCH", server.URL+"/flaky", strings.NewReader("chunk"))
+			return req
+		}, http.StatusOK, 3},
+		{"last response returned", func() *http.Request {
+			req, _ := http.NewRequest("GET", server.URL+"/down", nil)
+			return req
+		}, http.StatusServiceUnavailable, 3},
+		{"not retryable", func() *http.Request {
+			req, _ := http.NewRequest("GET", server.URL+"/missing", nil)
+			return req
+		}, http.StatusNotFound, 1},
+		{"streamed body sent once", func() *http.Request {
+			req, _ := http.NewRequest("PUT", server.URL+"/down", ioutil.NopCloser(strings.NewReader("layer")))
+			return req
+		}, http.StatusServiceUnavailable, 1},
+	}
+	for _, tc := range testCases {
+		requests = nil
+		resp, err := client.Do(tc.req())
+		if err != nil {
+			t.Errorf("%s: request should get a response, error:%s\n", tc.name, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status || len(requests) != tc.requests {
+			t.Errorf("%s: expected status %d after %d requests, got %d after %v\n", tc.name, tc.status, tc.requests, resp.StatusCode, requests)
+		}
+		for _, r := range requests {
+			if strings.HasPrefix(r, "PATCH") && r != "PATCH /flaky chunk" {
+				t.Errorf("%s: body should be sent again, got %q\n", tc.name, r)
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package retry
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Transport sends requests again through Policy if they fail with a network
+// error or a retryable status code. Requests with a body which cannot be
+// read again are sent once.
+type Transport struct {
+	Transport http.RoundTripper
+	// Policy is used instead of Default if it is set
+	Policy *Policy
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	policy := Default
+	if t.Policy != nil {
+		policy = *t.Policy
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return t.Transport.RoundTrip(req)
+	}
+
+	var resp *http.Response
+	attempt := 0
+	err := policy.Do(req.Method+" "+req.URL.Redacted(), func() error {
+		attempt++
+		r := req
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return Fatal(err)
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+
+		res, err := t.Transport.RoundTrip(r)
+		if err != nil {
+			return err
+		}
+		if RetryableStatus(res.StatusCode) && attempt < policy.MaxAttempts &&
+			ParseRetryAfter(res.Header.Get("Retry-After")) <= policy.MaxRetryAfter {
+			io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4096))
+			res.Body.Close()
+			return StatusError(res, fmt.Errorf("%s %s returns status %d", req.Method, req.URL.Redacted(), res.StatusCode))
+		}
+		// the caller gets the response of the last attempt, or the one
+		// asking to wait too long
+		resp = res
+		return nil
+	})
+	return resp, err
+}
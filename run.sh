@@ -1,6 +1,7 @@
 #!/bin/bash
 
-echo "docker login index.tenxcloud.com"
-/usr/bin/expect /usr/local/bin/login.sh
+# image-sync logs in to the destination registry with these
+export DST_REGISTRY_USERNAME=$USERNAME
+export DST_REGISTRY_PASSWORD=$PASSWORD
 
-/usr/local/bin/image-sync --src=$SRC_REPOSITORY --dst=$DST_REPOSITORY --skip=$SKIP_PUSHED --v=4
\ No newline at end of file
+/usr/local/bin/image-sync --repo-owner=$SRC_REPOSITORY --dst-repo-owner=$DST_REPOSITORY --skip=$SKIP_PUSHED --v=4
\ No newline at end of file
@@ -0,0 +1,67 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		spec  string
+		valid bool
+	}{
+		{"*/15 * * * *", true},
+		{"0 2 * * mon-fri", true},
+		{"30 4 1,15 jan,jul *", true},
+		{"0 0 * * 7", true},
+		{"@daily", true},
+		{"@every 6h", true},
+		{"@every 10s", false},
+		{"@every soon", false},
+		{"* * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"*/0 * * * *", false},
+		{"5-1 * * * *", false},
+		{"* * * foo *", false},
+	}
+	for _, tc := range testCases {
+		_, err := Parse(tc.spec)
+		if (err == nil) != tc.valid {
+			t.Errorf("schedule %q should be valid:%v, error:%v\n", tc.spec, tc.valid, err)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// 2024-03-15 is a friday
+	now := time.Date(2024, 3, 15, 10, 7, 30, 0, time.UTC)
+	testCases := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2024, 3, 15, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 3, 15, 10, 15, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2024, 3, 16, 2, 0, 0, 0, time.UTC)},
+		{"0 2 * * mon-fri", time.Date(2024, 3, 18, 2, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// restricted day of month and day of week match either
+		{"0 0 20 * sat", time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+		{"@hourly", time.Date(2024, 3, 15, 11, 0, 0, 0, time.UTC)},
+		{"@every 90m", now.Add(90 * time.Minute)},
+	}
+	for _, tc := range testCases {
+		s, err := Parse(tc.spec)
+		if err != nil {
+			t.Errorf("failed to parse %q, error:%s\n", tc.spec, err)
+			continue
+		}
+		if next := s.Next(now); !next.Equal(tc.expected) {
+			t.Errorf("next of %q after %s should be %s, got %s\n", tc.spec, now, tc.expected, next)
+		}
+	}
+}
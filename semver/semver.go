@@ -0,0 +1,213 @@
+package semver
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var versionRegexp = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// Version is a semantic version parsed from an image tag,
+// minor and patch versions are optional, for example, v1.8 means 1.8.0
+type Version struct {
+	Major      int64
+	Minor      int64
+	Patch      int64
+	Prerelease string
+	Original   string
+}
+
+// Parse parses a tag like 1.8.3, v1.8 or 1.8.3-alpine into a Version
+func Parse(tag string) (*Version, error) {
+	m := versionRegexp.FindStringSubmatch(tag)
+	if m == nil {
+		return nil, fmt.Errorf("%s is not a semantic version", tag)
+	}
+	v := &Version{Prerelease: m[4], Original: tag}
+	for i, dst := range []*int64{&v.Major, &v.Minor, &v.Patch} {
+		if m[i+1] == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(m[i+1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		*dst = n
+	}
+	return v, nil
+}
+
+func (v *Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 if v is less than, equal to or greater than o
+func (v *Version) Compare(o *Version) int {
+	if c := compareInt(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	return comparePrerelease(v.Prerelease, o.Prerelease)
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// comparePrerelease follows semver 2.0.0, a version without prerelease is greater
+func comparePrerelease(a, b string) int {
+	if a == b {
+		return 0
+	}
+	if a == "" {
+		return 1
+	}
+	if b == "" {
+		return -1
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.ParseInt(as[i], 10, 64)
+		bn, bErr := strconv.ParseInt(bs[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if c := compareInt(an, bn); c != 0 {
+				return c
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInt(int64(len(as)), int64(len(bs)))
+}
+
+// Constraints is a list of alternatives (separated by ||), each of them is a
+// list of comparisons (separated by commas or spaces) which must all hold,
+// for example, ">=1.8 <2.0 || ~2.1"
+type Constraints struct {
+	alternatives [][]comparison
+}
+
+type comparison struct {
+	op      string
+	version *Version
+}
+
+var operators = []string{">=", "<=", "!=", ">", "<", "=", "~", "^"}
+
+// ParseConstraints parses a constraint string
+func ParseConstraints(s string) (*Constraints, error) {
+	c := &Constraints{}
+	for _, alternative := range strings.Split(s, "||") {
+		fields := strings.FieldsFunc(alternative, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("invalid constraint %q", s)
+		}
+		var comparisons []comparison
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			// an operator separated from its version by spaces, e.g. ">= 1.8"
+			if isOperator(field) && i+1 < len(fields) {
+				i++
+				field += fields[i]
+			}
+			cmp, err := parseComparison(field)
+			if err != nil {
+				return nil, err
+			}
+			comparisons = append(comparisons, cmp)
+		}
+		c.alternatives = append(c.alternatives, comparisons)
+	}
+	return c, nil
+}
+
+func isOperator(s string) bool {
+	for _, op := range operators {
+		if s == op {
+			return true
+		}
+	}
+	return false
+}
+
+func parseComparison(s string) (comparison, error) {
+	op := "="
+	for _, candidate := range operators {
+		if strings.HasPrefix(s, candidate) {
+			op = candidate
+			s = s[len(candidate):]
+			break
+		}
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return comparison{}, err
+	}
+	return comparison{op: op, version: v}, nil
+}
+
+// Check returns true if v satisfies c
+func (c *Constraints) Check(v *Version) bool {
+	for _, comparisons := range c.alternatives {
+		ok := true
+		for _, cmp := range comparisons {
+			if !cmp.check(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (cmp comparison) check(v *Version) bool {
+	c := v.Compare(cmp.version)
+	switch cmp.op {
+	case ">=":
+		return c >= 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case "<":
+		return c < 0
+	case "!=":
+		return c != 0
+	case "~":
+		// ~1.8.3 means >=1.8.3 and <1.9.0
+		return c >= 0 && v.Major == cmp.version.Major && v.Minor == cmp.version.Minor
+	case "^":
+		// ^1.8.3 means >=1.8.3 and <2.0.0
+		return c >= 0 && v.Major == cmp.version.Major
+	}
+	return c == 0
+}
@@ -0,0 +1,84 @@
+package semver
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	shouldSuccess := map[string]string{
+		"1.8.3":         "1.8.3",
+		"v1.8":          "1.8.0",
+		"2":             "2.0.0",
+		"1.8.3-alpine":  "1.8.3-alpine",
+		"v1.4.0-beta.1": "1.4.0-beta.1",
+		"1.2.3+build5":  "1.2.3",
+	}
+	for tag, expected := range shouldSuccess {
+		v, err := Parse(tag)
+		if err != nil {
+			t.Errorf("parse %s should succeed, error:%s\n", tag, err)
+			continue
+		}
+		if v.String() != expected {
+			t.Errorf("parse %s should get %s, got %s\n", tag, expected, v)
+		}
+	}
+
+	for _, tag := range []string{"latest", "trusty", "1.8.x", "", "1..2"} {
+		if _, err := Parse(tag); err == nil {
+			t.Errorf("parse %s should fail\n", tag)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0", "1.2", "1.10.0", "2.0.0"}
+	for i := 0; i+1 < len(ordered); i++ {
+		a, _ := Parse(ordered[i])
+		b, _ := Parse(ordered[i+1])
+		if a.Compare(b) != -1 || b.Compare(a) != 1 {
+			t.Errorf("%s should be less than %s\n", a, b)
+		}
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	testCases := []struct {
+		constraint string
+		matches    []string
+		mismatches []string
+	}{
+		{">=1.8 <2.0", []string{"1.8", "1.9.4", "v1.12.0"}, []string{"1.7.9", "2.0.0", "3"}},
+		{">= 1.8, < 2.0", []string{"1.8.0"}, []string{"2.1"}},
+		{"~1.8.3", []string{"1.8.3", "1.8.9"}, []string{"1.8.2", "1.9.0"}},
+		{"^1.8", []string{"1.8.0", "1.99.0"}, []string{"2.0.0", "1.7.0"}},
+		{"<1.0 || >=3.0", []string{"0.9", "3.1"}, []string{"1.0", "2.9"}},
+		{"!=1.5", []string{"1.4", "1.6"}, []string{"1.5.0"}},
+		{"1.5", []string{"v1.5.0"}, []string{"1.5.1"}},
+	}
+	for _, tc := range testCases {
+		c, err := ParseConstraints(tc.constraint)
+		if err != nil {
+			t.Errorf("parse constraint %s should succeed, error:%s\n", tc.constraint, err)
+			continue
+		}
+		for _, tag := range tc.matches {
+			v, _ := Parse(tag)
+			if !c.Check(v) {
+				t.Errorf("%s should satisfy %s\n", tag, tc.constraint)
+			}
+		}
+		for _, tag := range tc.mismatches {
+			v, _ := Parse(tag)
+			if c.Check(v) {
+				t.Errorf("%s should not satisfy %s\n", tag, tc.constraint)
+			}
+		}
+	}
+
+	for _, constraint := range []string{"", ">=latest", "<1.0 ||"} {
+		if _, err := ParseConstraints(constraint); err == nil {
+			t.Errorf("parse constraint %q should fail\n", constraint)
+		}
+	}
+}
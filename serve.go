@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/oscarzhao/image-sync/config"
+	"github.com/oscarzhao/image-sync/registry"
+	"github.com/oscarzhao/image-sync/report"
+	"github.com/oscarzhao/image-sync/schedule"
+	"github.com/oscarzhao/image-sync/webhook"
+)
+
+// maxQueuedEvents limits webhook events waiting for each job
+const maxQueuedEvents = 100
+
+// daemon runs jobs on their schedules, and syncs images pushed upstream
+// on webhooks, until stop is closed
+type daemon struct {
+	cfg     *config.Config
+	limiter *registryLimiter
+	jobs    []*scheduledJob
+	// webhooks is true if webhooks are received
+	webhooks bool
+
+	// wg waits for the schedule loops and the webhook workers of jobs
+	wg sync.WaitGroup
+}
+
+// scheduledJob is a job run by the daemon, runs and webhook syncs of a job
+// never overlap
+type scheduledJob struct {
+	job config.Job
+	// schedule is nil if the job is run on webhooks only
+	schedule schedule.Schedule
+	events   chan webhook.Event
+
+	// mu is held while the job runs
+	mu sync.Mutex
+	// syncer is created by the first run, and kept with its registry clients
+	syncer *syncer
+}
+
+// newDaemon creates a daemon running jobs of cfg, jobs without a schedule
+// in the config take --schedule, or are run on webhooks only. Webhooks are
+// received on mux if --listen is set.
+func newDaemon(cfg *config.Config, limiter *registryLimiter, mux *http.ServeMux) (*daemon, error) {
+	d := &daemon{cfg: cfg, limiter: limiter, webhooks: listenAddress != ""}
+	scheduled := 0
+	for _, job := range cfg.Jobs {
+		j := &scheduledJob{job: job, events: make(chan webhook.Event, maxQueuedEvents)}
+		spec := job.Schedule
+		if spec == "" {
+			spec = defaultSchedule
+		}
+		if spec != "" {
+			s, err := schedule.Parse(spec)
+			if err != nil {
+				return nil, err
+			}
+			j.schedule = s
+			scheduled++
+		} else {
+			glog.Warningf("job %s has no schedule, it is run on webhooks only\n", job.Name)
+		}
+		d.jobs = append(d.jobs, j)
+	}
+
+	if !d.webhooks {
+		if scheduled == 0 {
+			return nil, errors.New("no job has a schedule, set schedule of jobs in the config, --schedule or --listen to receive webhooks")
+		}
+		return d, nil
+	}
+	if webhookSecret == "" {
+		glog.Warningf("no --webhook-secret, webhooks from anyone are accepted\n")
+	}
+	mux.Handle("/webhook", &webhook.Handler{Secret: webhookSecret, Window: webhookWindow, Sync: d.enqueue})
+	glog.Infof("receive webhooks on %s/webhook\n", listenAddress)
+	return d, nil
+}
+
+// run runs jobs until stop is closed and running jobs finish, or
+// shutdownTimeout passes
+func (d *daemon) run() {
+	for _, j := range d.jobs {
+		if j.schedule != nil {
+			d.start(j, d.loop)
+		}
+		if d.webhooks {
+			d.start(j, d.worker)
+		}
+	}
+	glog.Infof("serving %d jobs\n", len(d.jobs))
+	<-stop
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		glog.Errorf("running jobs do not finish in %s, exit anyway\n", shutdownTimeout)
+	}
+}
+
+func (d *daemon) start(j *scheduledJob, fn func(*scheduledJob)) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		fn(j)
+	}()
+}
+
+// loop runs j at each activation of its schedule until stop is closed
+func (d *daemon) loop(j *scheduledJob) {
+	var last time.Time
+	for {
+		next, missed := nextRun(j.schedule, last, time.Now())
+		if missed {
+			glog.Warningf("job %s, the previous run lasts beyond the next activation, which is skipped\n", j.job.Name)
+		}
+		if next.IsZero() {
+			glog.Warningf("job %s, schedule never activates again\n", j.job.Name)
+			return
+		}
+		glog.V(2).Infof("job %s, next run at %s\n", j.job.Name, next)
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		last = next
+		d.runJob(j)
+	}
+}
+
+// nextRun returns the first activation of s after last, or after now if it
+// is already missed
+func nextRun(s schedule.Schedule, last, now time.Time) (next time.Time, missed bool) {
+	if last.IsZero() {
+		return s.Next(now), false
+	}
+	if next = s.Next(last); !next.IsZero() && next.Before(now) {
+		return s.Next(now), true
+	}
+	return next, false
+}
+
+// worker syncs images of webhook events queued for j until stop is closed
+func (d *daemon) worker(j *scheduledJob) {
+	for {
+		select {
+		case <-stop:
+			return
+		case e := <-j.events:
+			d.syncEvent(j, e)
+		}
+	}
+}
+
+// enqueue queues e for the jobs synchronizing the pushed image
+func (d *daemon) enqueue(e webhook.Event) {
+	found := false
+	for _, j := range d.jobs {
+		if !j.match(e) {
+			continue
+		}
+		found = true
+		select {
+		case j.events <- e:
+		default:
+			glog.Errorf("job %s, too many webhook events queued, drop %s\n", j.job.Name, e)
+		}
+	}
+	if !found {
+		glog.V(2).Infof("no job synchronizes %s, webhook event is ignored\n", e)
+	}
+}
+
+// match returns true if j synchronizes the image pushed in e
+func (j *scheduledJob) match(e webhook.Event) bool {
+	source := j.job.Source
+	if !registry.SameRegistry(source.Registry, e.Registry) {
+		return false
+	}
+	// an empty namespace selects every repo of the registry
+	if source.Namespace != "" && !strings.HasPrefix(e.Repo, source.Namespace+"/") {
+		return false
+	}
+	repo := strings.TrimPrefix(e.Repo, source.Namespace+"/")
+	if !j.job.Repos.Match(repo) {
+		return false
+	}
+	return len(j.job.TagFilter(repo).Select([]config.Tag{{Name: e.Tag}})) > 0
+}
+
+// runJob runs j once unless it is running
+func (d *daemon) runJob(j *scheduledJob) {
+	if !j.mu.TryLock() {
+		glog.Warningf("job %s is still running, skip this run\n", j.job.Name)
+		return
+	}
+	defer j.mu.Unlock()
+	d.sync(j, "run", func(s *syncer) {
+		s.run()
+		// only the first run resumes the previous one
+		s.resume = false
+	})
+}
+
+// syncEvent syncs the image pushed in e once the running run of j finishes
+func (d *daemon) syncEvent(j *scheduledJob, e webhook.Event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if stopped() {
+		return
+	}
+	d.sync(j, "sync of "+e.String(), func(s *syncer) {
+		// the pushed tag is synchronized even if the previous run finished it
+		resume := s.resume
+		s.resume = false
+		s.syncImages(map[string][]string{e.Repo: {e.Tag}})
+		s.resume = resume
+	})
+}
+
+// sync runs fn with the syncer of j while j is locked, the state is saved
+// and the report is written after it
+func (d *daemon) sync(j *scheduledJob, name string, fn func(*syncer)) {
+	r := report.New()
+	if j.syncer == nil {
+		s, err := newSyncer(d.cfg, j.job, d.limiter, r)
+		if err != nil {
+			glog.Errorf("job %s, create registry clients fails, error:%s\n", j.job.Name, err)
+			return
+		}
+		j.syncer = s
+	}
+	glog.V(2).Infof("job %s, %s starts\n", j.job.Name, name)
+	j.syncer.report = r
+	fn(j.syncer)
+
+	r.Finish()
+	glog.Infof("job %s, %s finished, summary: %v\n", j.job.Name, name, r.Summary)
+	saveState()
+	writeReports(r)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oscarzhao/image-sync/config"
+	"github.com/oscarzhao/image-sync/schedule"
+	"github.com/oscarzhao/image-sync/webhook"
+)
+
+func TestNextRun(t *testing.T) {
+	hourly, err := schedule.Parse("@hourly")
+	if err != nil {
+		t.Fatalf("parse schedule fails, error:%s\n", err)
+	}
+	base := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		last     time.Time
+		now      time.Time
+		expected time.Time
+		missed   bool
+	}{
+		{"first run", time.Time{}, base.Add(10 * time.Minute), base.Add(time.Hour), false},
+		{"run in time", base, base.Add(20 * time.Minute), base.Add(time.Hour), false},
+		{"run lasts beyond activations", base, base.Add(150 * time.Minute), base.Add(3 * time.Hour), true},
+	}
+	for _, tc := range testCases {
+		next, missed := nextRun(hourly, tc.last, tc.now)
+		if !next.Equal(tc.expected) || missed != tc.missed {
+			t.Errorf("%s: expected next run at %s (missed:%v), got %s (missed:%v)\n", tc.name, tc.expected, tc.missed, next, missed)
+		}
+	}
+}
+
+func TestScheduledJobMatch(t *testing.T) {
+	cfg, err := config.Parse([]byte(`
+jobs:
+- name: library
+  source: {namespace: library}
+  destination: {namespace: docker_library}
+  repos: {exclude: ["busybox"]}
+  tags: {exclude: [".*-rc"]}
+- name: private
+  source: {registry: "registry.example.com:5000", namespace: team}
+  destination: {namespace: team}
+- name: mirror
+  source: {registry: "mirror.example.com"}
+  destination: {namespace: mirror}
+`))
+	if err != nil {
+		t.Fatalf("parse config fails, error:%s\n", err)
+	}
+	hub, private, mirror := &scheduledJob{job: cfg.Jobs[0]}, &scheduledJob{job: cfg.Jobs[1]}, &scheduledJob{job: cfg.Jobs[2]}
+	testCases := []struct {
+		job      *scheduledJob
+		event    webhook.Event
+		expected bool
+	}{
+		{hub, webhook.Event{Repo: "library/nginx", Tag: "1.25"}, true},
+		{hub, webhook.Event{Registry: "docker.io", Repo: "library/nginx", Tag: "1.25"}, true},
+		{hub, webhook.Event{Repo: "library/nginx", Tag: "1.25-rc"}, false},
+		{hub, webhook.Event{Repo: "library/busybox", Tag: "latest"}, false},
+		{hub, webhook.Event{Repo: "bitnami/nginx", Tag: "latest"}, false},
+		{hub, webhook.Event{Registry: "registry.example.com:5000", Repo: "library/nginx", Tag: "latest"}, false},
+		{private, webhook.Event{Registry: "registry.example.com:5000", Repo: "team/app", Tag: "v1"}, true},
+		{private, webhook.Event{Repo: "team/app", Tag: "v1"}, false},
+		{mirror, webhook.Event{Registry: "mirror.example.com", Repo: "team/app", Tag: "v1"}, true},
+		{mirror, webhook.Event{Registry: "mirror.example.com", Repo: "app", Tag: "v1"}, true},
+		{mirror, webhook.Event{Repo: "team/app", Tag: "v1"}, false},
+	}
+	for _, tc := range testCases {
+		if matched := tc.job.match(tc.event); matched != tc.expected {
+			t.Errorf("job %s should match %s: %v, got %v\n", tc.job.job.Name, tc.event, tc.expected, matched)
+		}
+	}
+}
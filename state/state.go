@@ -0,0 +1,210 @@
+// Package state keeps the progress of sync runs in a json file, so that an
+// interrupted run can be resumed by the next one
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/oscarzhao/image-sync/report"
+)
+
+// StatusStarted is the status of an image being synchronized
+const StatusStarted report.Status = "started"
+
+// saveInterval is the minimum interval between two saves of the state file,
+// progress of a run killed in between is lost, and the images are synchronized again
+const saveInterval = time.Second
+
+// Image is the progress of an image
+type Image struct {
+	Destination string        `json:"destination,omitempty"`
+	Status      report.Status `json:"status"`
+	Digest      string        `json:"digest,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	Updated     time.Time     `json:"updated"`
+}
+
+// Done returns true if the image needs no sync anymore
+func (i *Image) Done() bool {
+	return i.Status == report.StatusCopied || i.Status == report.StatusSkipped
+}
+
+// Job is the progress of a sync job
+type Job struct {
+	// Listing maps repos to the selected tags, it is kept once all repos
+	// and tags are listed, so that a resumed run does not list them again
+	Listing map[string][]string `json:"listing,omitempty"`
+	// Images maps source images to their progress
+	Images map[string]*Image `json:"images"`
+}
+
+// State is the progress of a run, it is safe for concurrent use
+type State struct {
+	path string
+
+	mu      sync.Mutex
+	saved   time.Time
+	dirty   bool
+	Jobs    map[string]*Job   `json:"jobs"`
+	Uploads map[string]string `json:"uploads"`
+}
+
+// New returns an empty state saved to path
+func New(path string) *State {
+	return &State{path: path, Jobs: make(map[string]*Job), Uploads: make(map[string]string)}
+}
+
+// Load loads the state saved to path by a previous run, an empty state is
+// returned if the file does not exist
+func Load(path string) (*State, error) {
+	s := New(path)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid state file %s, error:%s", path, err)
+	}
+	if s.Jobs == nil {
+		s.Jobs = make(map[string]*Job)
+	}
+	if s.Uploads == nil {
+		s.Uploads = make(map[string]string)
+	}
+	return s, nil
+}
+
+// job returns the progress of job name, s.mu must be held
+func (s *State) job(name string) *Job {
+	j, ok := s.Jobs[name]
+	if !ok {
+		j = &Job{Images: make(map[string]*Image)}
+		s.Jobs[name] = j
+	}
+	if j.Images == nil {
+		j.Images = make(map[string]*Image)
+	}
+	return j
+}
+
+// Listing returns repos and tags of job listed by a previous run, ok is false if there are none
+func (s *State) Listing(job string) (repo2tags map[string][]string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.Jobs[job]
+	if !ok || j.Listing == nil {
+		return nil, false
+	}
+	return j.Listing, true
+}
+
+// SetListing keeps repos and tags of job
+func (s *State) SetListing(job string, repo2tags map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.job(job).Listing = repo2tags
+	s.changed()
+}
+
+// Image returns the progress of source image of job, nil if it is unknown
+func (s *State) Image(job, source string) *Image {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.Jobs[job]
+	if !ok {
+		return nil
+	}
+	if img, ok := j.Images[source]; ok {
+		copied := *img
+		return &copied
+	}
+	return nil
+}
+
+// Update sets the progress of source image of job
+func (s *State) Update(job, source string, img Image) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	img.Updated = time.Now()
+	s.job(job).Images[source] = &img
+	s.changed()
+}
+
+// Get returns the location of an unfinished blob upload, s keeps the upload
+// sessions of registry copies
+func (s *State) Get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Uploads[key]
+}
+
+// Put keeps the location of an unfinished blob upload
+func (s *State) Put(key, location string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Uploads[key] == location {
+		return
+	}
+	s.Uploads[key] = location
+	s.changed()
+}
+
+// Delete forgets a finished blob upload
+func (s *State) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.Uploads[key]; !ok {
+		return
+	}
+	delete(s.Uploads, key)
+	s.changed()
+}
+
+// changed saves s unless it was saved within saveInterval, s.mu must be held
+func (s *State) changed() {
+	s.dirty = true
+	if time.Since(s.saved) < saveInterval {
+		return
+	}
+	if err := s.save(); err != nil {
+		glog.Warningf("save state to %s fails, error:%s\n", s.path, err)
+	}
+}
+
+// Save writes s to its file if it has changed since the last save
+func (s *State) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	return s.save()
+}
+
+// save writes s to a temporary file renamed to path, so that a crash never
+// leaves a truncated file, s.mu must be held
+func (s *State) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.saved, s.dirty = time.Now(), false
+	return nil
+}
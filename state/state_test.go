@@ -0,0 +1,58 @@
+package state
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/oscarzhao/image-sync/report"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := Load(path)
+	if err != nil {
+		t.Fatalf("load a missing state file should succeed, error:%s\n", err)
+	}
+	if _, ok := s.Listing("default"); ok {
+		t.Errorf("empty state should have no listing\n")
+	}
+
+	listing := map[string][]string{"google_containers/pause": {"3.0", "3.1"}}
+	s.SetListing("default", listing)
+	s.Update("default", "gcr.io/google_containers/pause:3.0", Image{Destination: "index.tenxcloud.com/google_containers/pause:3.0", Status: report.StatusCopied, Digest: "sha256:abc"})
+	s.Update("default", "gcr.io/google_containers/pause:3.1", Image{Status: StatusStarted})
+	s.Put("upload", "http://registry/v2/pause/blobs/uploads/u1")
+	if err := s.Save(); err != nil {
+		t.Fatalf("save state fails, error:%s\n", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("load state fails, error:%s\n", err)
+	}
+	if got, ok := loaded.Listing("default"); !ok || !reflect.DeepEqual(got, listing) {
+		t.Errorf("listing should be loaded, expected %v, got %v\n", listing, got)
+	}
+	testCases := []struct {
+		source string
+		known  bool
+		done   bool
+	}{
+		{"gcr.io/google_containers/pause:3.0", true, true},
+		{"gcr.io/google_containers/pause:3.1", true, false},
+		{"gcr.io/google_containers/pause:3.2", false, false},
+	}
+	for _, tc := range testCases {
+		img := loaded.Image("default", tc.source)
+		if (img != nil) != tc.known || (img != nil && img.Done() != tc.done) {
+			t.Errorf("image %s should be known:%v, done:%v, got %#v\n", tc.source, tc.known, tc.done, img)
+		}
+	}
+	if loaded.Image("other", "gcr.io/google_containers/pause:3.0") != nil {
+		t.Errorf("images of other jobs should be unknown\n")
+	}
+	if location := loaded.Get("upload"); location != "http://registry/v2/pause/blobs/uploads/u1" {
+		t.Errorf("upload session should be loaded, got %q\n", location)
+	}
+}
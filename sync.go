@@ -0,0 +1,546 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+
+	"github.com/oscarzhao/image-sync/archive"
+	"github.com/oscarzhao/image-sync/config"
+	"github.com/oscarzhao/image-sync/dockerexec"
+	"github.com/oscarzhao/image-sync/dockerhub"
+	"github.com/oscarzhao/image-sync/registry"
+	"github.com/oscarzhao/image-sync/report"
+	"github.com/oscarzhao/image-sync/state"
+)
+
+// pushDigestRegexp finds the manifest digest in the output of docker push
+var pushDigestRegexp = regexp.MustCompile(`digest: (sha256:[0-9a-f]{64})`)
+
+// syncer runs a sync job, images flow through a pipeline of stages
+type syncer struct {
+	job         config.Job
+	concurrency config.Concurrency
+	limiter     *registryLimiter
+	report      *report.Report
+	copyOptions *registry.CopyOptions
+	srcClient   *registry.Client
+	dstClient   *registry.Client
+	// source is the archive images are imported from instead of srcClient,
+	// and archive the archive images are exported to instead of dstClient
+	source  *archive.Reader
+	archive *archive.Layout
+	// resume skips images finished by the previous run recorded in the state
+	resume bool
+	// failures counts images failed in the run
+	failures int32
+	// upstream maps repos listed by the run to all of their tags upstream
+	// with their update time, it is nil if the listing is resumed
+	upstream map[string]map[string]time.Time
+
+	// wg waits for background operations started by stages
+	wg sync.WaitGroup
+}
+
+// task is an image flowing through the pipeline
+type task struct {
+	src     Image
+	dst     Image
+	started time.Time
+	digest  string
+	bytes   int64
+}
+
+// newSyncer creates the registry clients of job
+func newSyncer(cfg *config.Config, job config.Job, limiter *registryLimiter, r *report.Report) (*syncer, error) {
+	src, dst := job.Source, job.Destination
+	s := &syncer{
+		job:         job,
+		concurrency: cfg.Concurrency,
+		limiter:     limiter,
+		report:      r,
+		resume:      resume,
+		copyOptions: &registry.CopyOptions{
+			Platforms: job.Platforms,
+			ChunkSize: int64(chunkSize) << 20,
+			Sessions:  uploadSessions,
+		},
+	}
+	var srcCred registry.Credential
+	var err error
+	if src.Archive != "" {
+		if s.source, err = openSource(src.Archive); err != nil {
+			return nil, err
+		}
+	} else {
+		if srcCred, err = resolveCredential(cfg, src); err != nil {
+			return nil, err
+		}
+		if s.srcClient, err = registry.NewClientWithAuth(src.Proto, src.Registry, src.Version, srcCred); err != nil {
+			return nil, err
+		}
+		s.srcClient.PageSize = src.PageSize
+	}
+	if dst.Archive != "" {
+		if s.archive, err = openArchive(dst.Archive, dst.Baseline); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	dstCred, err := resolveCredential(cfg, dst)
+	if err != nil {
+		return nil, err
+	}
+	if s.dstClient, err = registry.NewClientWithAuth(dst.Proto, dst.Registry, dst.Version, dstCred); err != nil {
+		return nil, err
+	}
+	s.dstClient.PageSize = dst.PageSize
+	if copyMode == "docker" && !dryRun {
+		// the docker daemon pulls and pushes with its own credentials
+		dockerLogin(src.Registry, srcCred)
+		dockerLogin(dst.Registry, dstCred)
+	}
+	return s, nil
+}
+
+// resolveCredential returns the credential of endpoint, credentials saved
+// by docker login are used if the endpoint refers to none
+func resolveCredential(cfg *config.Config, endpoint config.Endpoint) (registry.Credential, error) {
+	c, err := cfg.Credential(endpoint.Credentials)
+	if err != nil {
+		return registry.Credential{}, err
+	}
+	cred := registry.Credential{Username: c.Username, Password: c.Password, IdentityToken: c.IdentityToken}
+	if cred.Empty() {
+		return registry.DockerConfigCredential(endpoint.Registry)
+	}
+	return cred, nil
+}
+
+// dockerLogin logs the docker daemon in to host with cred, failures are logged only,
+// since the daemon may have logged in already
+func dockerLogin(host string, cred registry.Credential) {
+	if cred.Username == "" || cred.Password == "" {
+		if cred.IdentityToken != "" {
+			glog.Warningf("identity token of %s cannot be passed to docker login, run docker login before syncing\n", host)
+		}
+		return
+	}
+	if _, stderr, err := dockerexec.Login(host, cred.Username, cred.Password); err != nil {
+		glog.Errorf("docker login %s as %s fails, stderr:%s, error:%s\n", host, cred.Username, stderr, err)
+	}
+}
+
+// run synchronizes all selected images of the job
+func (s *syncer) run() {
+	atomic.StoreInt32(&s.failures, 0)
+	srcRepo2Tags, listTagFailedRepos, ok := s.listImages()
+	if !ok {
+		return
+	}
+
+	glog.V(4).Infof("images found in source registry: %#v\n", srcRepo2Tags)
+	srcRepo2Tags = s.retainedImages(srcRepo2Tags)
+	s.syncImages(srcRepo2Tags)
+	if s.job.Prune.Enabled && !stopped() {
+		s.prune()
+	}
+	if !stopped() {
+		s.retain(srcRepo2Tags)
+	}
+
+	if len(listTagFailedRepos) > 0 {
+		glog.Errorf("job %s, the following repos, list tag operation fails:\n%s\n", s.job.Name, strings.Join(listTagFailedRepos, ", "))
+	}
+	if atomic.LoadInt32(&s.failures) == 0 && !stopped() {
+		lastSuccess.Set(float64(time.Now().Unix()), s.job.Name)
+	}
+}
+
+// syncImages synchronizes tags of repos through the pipeline
+func (s *syncer) syncImages(srcRepo2Tags map[string][]string) {
+	tasks := s.listImagesToPull(srcRepo2Tags)
+	if s.source != nil {
+		for t := range s.importImages(tasks) {
+			glog.V(2).Infof("image %s imported from %s\n", t.dst, s.source)
+			s.record(t, report.StatusCopied, nil)
+		}
+		s.wg.Wait()
+		return
+	}
+	if s.archive != nil {
+		// blobs in the archive already are skipped by the export
+		for t := range s.exportImages(tasks) {
+			glog.V(2).Infof("image %s exported to %s\n", t.dst, s.archive)
+			s.record(t, report.StatusCopied, nil)
+		}
+		s.wg.Wait()
+		return
+	}
+	if skipSynced {
+		tasks = s.skipSyncedImages(tasks)
+	}
+	if copyMode != "docker" {
+		for t := range s.copyImages(tasks) {
+			glog.V(2).Infof("image %s copied\n", t.dst)
+			s.record(t, report.StatusCopied, nil)
+		}
+	} else {
+		imagePulled := s.pullImages(tasks)
+		images2push := s.makeTag(imagePulled)
+		imagePushed := s.pushImages(images2push)
+
+		for t := range imagePushed {
+			image := t.dst
+			if _, stderr, err := dockerexec.DeleteImage(image.registry, image.repo, image.tag); err != nil {
+				glog.Errorf("image %s pushed, but delete fails, stderror:%s, error:%s\n", image, stderr, err)
+				s.record(t, report.StatusDeleteFailed, dockerError(stderr, err))
+			} else {
+				glog.V(2).Infof("image %s pushed and deleted\n", image)
+				s.record(t, report.StatusCopied, nil)
+			}
+		}
+	}
+	s.wg.Wait()
+}
+
+// listImages lists selected tags of selected repos under the source namespace,
+// a resumed run reuses the listing of the previous run. Archives are listed
+// once the destination is verified to have their baseline. ok is false if
+// repos cannot be listed.
+func (s *syncer) listImages() (srcRepo2Tags map[string][]string, listTagFailedRepos []string, ok bool) {
+	s.upstream = nil
+	if s.source != nil {
+		if err := s.verifyBaseline(); err != nil {
+			glog.Errorf("job %s, import %s fails, error:%s\n", s.job.Name, s.source, err)
+			s.report.Add(report.Entry{Job: s.job.Name, Status: report.StatusListFailed, Source: s.job.Source.Archive, Error: err.Error()})
+			s.count(report.StatusListFailed, 0)
+			return nil, nil, false
+		}
+		return s.listArchive(), nil, true
+	}
+	if syncState != nil && s.resume {
+		if repo2tags, ok := syncState.Listing(s.job.Name); ok {
+			glog.V(2).Infof("job %s, resume with %d repos listed by the previous run\n", s.job.Name, len(repo2tags))
+			return repo2tags, nil, true
+		}
+	}
+
+	srcRegistry := s.job.Source.Registry
+	srcRepo2Tags = make(map[string][]string)
+	upstream := make(map[string]map[string]time.Time)
+	listTagFailedRepos = make([]string, 0, 4)
+
+	repoList, err := s.srcClient.ListRepositories(s.job.Source.Namespace)
+	if err != nil {
+		glog.Errorf("job %s, list repos (%s) failed, error: %s\n", s.job.Name, s.job.Source.Namespace, err)
+		s.report.Add(report.Entry{Job: s.job.Name, Status: report.StatusListFailed, Source: s.job.Source.Namespace, Error: err.Error()})
+		s.count(report.StatusListFailed, 0)
+		return nil, nil, false
+	}
+
+	glog.V(4).Infof("repos got: %s\n", strings.Join(repoList, "\n"))
+
+	// fetch all tags of all selected repos under the source namespace
+	for _, repoName := range repoList {
+		if !s.job.Repos.Match(s.relativeRepo(repoName)) {
+			glog.V(4).Infof("repo %s is filtered out\n", repoName)
+			continue
+		}
+		tagInfos, err := s.srcClient.ListTagInfos(repoName)
+		if err != nil {
+			// the repo is left out of upstream, prune skips it
+			listTagFailedRepos = append(listTagFailedRepos, repoName)
+			glog.Errorf("list tag of repo (%s/%s) fails, error:%s\n", srcRegistry, repoName, err)
+			s.report.Add(report.Entry{Job: s.job.Name, Status: report.StatusListFailed, Source: repoName, Error: err.Error()})
+			s.count(report.StatusListFailed, 0)
+			continue
+		}
+		tags := make([]config.Tag, 0, len(tagInfos))
+		for _, t := range tagInfos {
+			tags = append(tags, config.Tag{Name: t.Name, Updated: t.LastUpdated})
+		}
+		srcRepo2Tags[repoName] = s.job.TagFilter(s.relativeRepo(repoName)).Select(tags)
+		upstream[repoName] = upstreamTags(tags)
+	}
+	s.upstream = upstream
+
+	// a partial listing is not kept, the next run lists again
+	if syncState != nil && len(listTagFailedRepos) == 0 {
+		syncState.SetListing(s.job.Name, srcRepo2Tags)
+	}
+	return srcRepo2Tags, listTagFailedRepos, true
+}
+
+// record adds the outcome of t to the report
+func (s *syncer) record(t *task, status report.Status, err error) {
+	e := report.Entry{
+		Job:         s.job.Name,
+		Status:      status,
+		Source:      t.src.String(),
+		Destination: t.dst.String(),
+		Digest:      t.digest,
+		Bytes:       t.bytes,
+		Duration:    time.Since(t.started).Seconds(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	s.report.Add(e)
+	s.count(status, t.bytes)
+	if syncState != nil {
+		syncState.Update(s.job.Name, e.Source, state.Image{Destination: e.Destination, Status: status, Digest: e.Digest, Error: e.Error})
+	}
+}
+
+// start records that t is being synchronized
+func (s *syncer) start(t *task) {
+	t.started = time.Now()
+	if syncState != nil {
+		syncState.Update(s.job.Name, t.src.String(), state.Image{Destination: t.dst.String(), Status: state.StatusStarted})
+	}
+}
+
+func (s *syncer) listImagesToPull(repo2tags map[string][]string) <-chan *task {
+	tasks := make(chan *task)
+	go func() {
+		defer close(tasks)
+		done := 0
+		for repo, tags := range repo2tags {
+			for _, tag := range tags {
+				image := Image{registry: s.job.Source.Registry, repo: repo, tag: tag}
+				if s.finished(image) {
+					done++
+					continue
+				}
+				select {
+				case tasks <- &task{src: image, dst: s.dstImage(image), started: time.Now()}:
+				case <-stop:
+					glog.Warningf("job %s is stopped, images left are not synchronized\n", s.job.Name)
+					return
+				}
+			}
+		}
+		if done > 0 {
+			glog.Infof("job %s, %d images finished by the previous run are not synchronized again\n", s.job.Name, done)
+		}
+	}()
+	return tasks
+}
+
+// finished returns true if image is synchronized by the previous run which is resumed
+func (s *syncer) finished(image Image) bool {
+	if syncState == nil || !s.resume {
+		return false
+	}
+	img := syncState.Image(s.job.Name, image.String())
+	return img != nil && img.Done()
+}
+
+// runStage starts workers goroutines applying fn to tasks, tasks for which fn
+// returns true are sent to the returned channel. The channel is closed once all
+// workers exit, so stages shut down in pipeline order.
+func runStage(workers int, tasks <-chan *task, fn func(*task) bool) <-chan *task {
+	if workers < 1 {
+		workers = 1
+	}
+	out := make(chan *task)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range tasks {
+				if fn(t) {
+					out <- t
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// skipSyncedImages drops images which exist in the destination registry with the same content
+func (s *syncer) skipSyncedImages(tasks <-chan *task) <-chan *task {
+	dstTags := newTagCache(s.dstClient)
+	return runStage(s.concurrency.Check, tasks, s.timed("check", func(t *task) bool {
+		image, dstImg := t.src, t.dst
+		release := s.limiter.acquire(image.registry, dstImg.registry)
+		defer release()
+
+		if dstTags.has(dstImg.repo, image.tag) {
+			same, err := registry.SameImage(s.srcClient, s.dstClient, image.repo, dstImg.repo, image.tag, s.copyOptions)
+			if err != nil {
+				glog.Errorf("compare %s with %s fails, error:%s\n", image, dstImg, err)
+			} else if same {
+				glog.V(2).Infof("image %s exists in %s, skip it\n", image, dstImg)
+				s.record(t, report.StatusSkipped, nil)
+				return false
+			}
+		}
+		return true
+	}))
+}
+
+// copyImages copies images from the source registry to the destination registry
+// through registry v2 api, no docker daemon is involved
+func (s *syncer) copyImages(tasks <-chan *task) <-chan *task {
+	return runStage(s.concurrency.Copy, tasks, s.timed("copy", func(t *task) bool {
+		image, dstImg := t.src, t.dst
+		release := s.limiter.acquire(image.registry, dstImg.registry)
+		defer release()
+
+		s.start(t)
+		result, err := registry.CopyImage(s.srcClient, s.dstClient, image.repo, dstImg.repo, image.tag, s.copyOptions)
+		if err != nil {
+			glog.Errorf("registry.CopyImage from %s to %s failed, error:%s\n", image, dstImg, err)
+			status := report.StatusPullFailed
+			if copyErr, ok := err.(*registry.CopyError); ok && copyErr.Op == "push" {
+				status = report.StatusPushFailed
+			}
+			s.record(t, status, err)
+			return false
+		}
+		t.digest, t.bytes = result.Digest, result.Bytes
+		return true
+	}))
+}
+
+func (s *syncer) pullImages(tasks <-chan *task) <-chan *task {
+	if err := s.srcClient.ProbePullLimit(); err != nil {
+		glog.Warningf("probe pull rate limit of docker hub fails, error:%s\n", err)
+	}
+	return runStage(s.concurrency.Pull, tasks, s.timed("pull", func(t *task) bool {
+		image := t.src
+		release := s.limiter.acquire(image.registry)
+		defer release()
+
+		s.start(t)
+		if s.srcClient.IsHub() {
+			// the docker daemon pulls with the same budget
+			dockerhub.PullLimit.Wait()
+		}
+		if _, stderr, err := dockerexec.PullImage(image.registry, image.repo, image.tag); err != nil {
+			glog.Errorf("dockerexec.PullImage (%v) failed, stderr:%s, err:%s\n", image, stderr, err)
+			s.record(t, report.StatusPullFailed, dockerError(stderr, err))
+			return false
+		}
+		return true
+	}))
+}
+
+func (s *syncer) pushImages(tasks <-chan *task) <-chan *task {
+	return runStage(s.concurrency.Push, tasks, s.timed("push", func(t *task) bool {
+		image := t.dst
+		release := s.limiter.acquire(image.registry)
+		defer release()
+
+		stdout, stderr, err := dockerexec.PushImage(image.registry, image.repo, image.tag)
+		if err != nil {
+			glog.Errorf("dockerexec.PushImage %v failed, stderr:%s, err:%s, mark and delete it\n", image, stderr, err)
+			s.record(t, report.StatusPushFailed, dockerError(stderr, err))
+			s.wg.Add(1)
+			go func(registry, repo, tag string) {
+				defer s.wg.Done()
+				if _, stderr, err := dockerexec.DeleteImage(registry, repo, tag); err != nil {
+					glog.Errorf("delete image %s/%s:%s fails, stderror:%s, error:%s\n", registry, repo, tag, stderr, err)
+				}
+			}(image.registry, image.repo, image.tag)
+			return false
+		}
+		if m := pushDigestRegexp.FindStringSubmatch(stdout); m != nil {
+			t.digest = m[1]
+		}
+		return true
+	}))
+}
+
+func (s *syncer) makeTag(tasks <-chan *task) <-chan *task {
+	return runStage(s.concurrency.Tag, tasks, s.timed("tag", func(t *task) bool {
+		image, dstImg := t.src, t.dst
+		// check if create tag success
+		_, stderr, err := dockerexec.MakeTag(image.String(), dstImg.String())
+		if err != nil {
+			glog.Errorf("create tag from %s to %s fails, stderr:%s, error:%s\n", image, dstImg, stderr, err)
+			s.record(t, report.StatusTagFailed, dockerError(stderr, err))
+		}
+		// delete old one
+		if _, stderr, err := dockerexec.DeleteImage(image.registry, image.repo, image.tag); err != nil {
+			glog.Errorf("delete image %s fails, stderror:%s, error:%s\n", image, stderr, err)
+		}
+		return err == nil
+	}))
+}
+
+// dockerError adds the stderr of a failed docker command to err
+func dockerError(stderr string, err error) error {
+	if stderr = strings.TrimSpace(stderr); stderr != "" {
+		return fmt.Errorf("%s, stderr:%s", err, stderr)
+	}
+	return err
+}
+
+// tagCache lists tags of each repo once, it is safe for concurrent use
+type tagCache struct {
+	client *registry.Client
+
+	mu    sync.Mutex
+	repos map[string]*repoTags
+}
+
+type repoTags struct {
+	once sync.Once
+	tags map[string]bool
+}
+
+func newTagCache(client *registry.Client) *tagCache {
+	return &tagCache{client: client, repos: make(map[string]*repoTags)}
+}
+
+// has returns true if repo:tag exists, errors are logged and treated as not existing
+func (c *tagCache) has(repo, tag string) bool {
+	c.mu.Lock()
+	entry, ok := c.repos[repo]
+	if !ok {
+		entry = &repoTags{tags: make(map[string]bool)}
+		c.repos[repo] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		tags, err := c.client.ListTags(repo)
+		if err != nil && !registry.IsNotFound(err) {
+			glog.Errorf("list tag of repo %s fails, error:%s\n", repo, err)
+		}
+		for _, t := range tags {
+			entry.tags[t] = true
+		}
+	})
+	return entry.tags[tag]
+}
+
+// relativeRepo returns the repo name without the source namespace
+func (s *syncer) relativeRepo(repo string) string {
+	return strings.TrimPrefix(repo, s.job.Source.Namespace+"/")
+}
+
+// dstImage returns the image in the destination registry which image is synchronized to,
+// the source namespace is replaced by the destination namespace. Images in
+// archives are named without a registry.
+func (s *syncer) dstImage(image Image) Image {
+	dstRepo := s.job.Destination.Namespace + "/" + s.relativeRepo(image.repo)
+	if s.archive != nil {
+		return Image{"", dstRepo, image.tag}
+	}
+	return Image{s.job.Destination.Registry, dstRepo, image.tag}
+}
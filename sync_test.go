@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/oscarzhao/image-sync/config"
+)
+
+func TestDstImage(t *testing.T) {
+	testCases := []struct {
+		job      config.Job
+		image    Image
+		expected string
+	}{
+		{
+			job: config.Job{
+				Source:      config.Endpoint{Namespace: "library"},
+				Destination: config.Endpoint{Registry: "index.tenxcloud.com", Namespace: "docker_library"},
+			},
+			image:    Image{"", "ubuntu", "14.04"},
+			expected: "index.tenxcloud.com/docker_library/ubuntu:14.04",
+		},
+		{
+			job: config.Job{
+				Source:      config.Endpoint{Registry: "gcr.io", Namespace: "google_containers"},
+				Destination: config.Endpoint{Registry: "index.tenxcloud.com", Namespace: "google_containers"},
+			},
+			image:    Image{"gcr.io", "google_containers/pause", "3.0"},
+			expected: "index.tenxcloud.com/google_containers/pause:3.0",
+		},
+		{
+			job: config.Job{
+				Source:      config.Endpoint{Namespace: "oscarzhao"},
+				Destination: config.Endpoint{Registry: "index.tenxcloud.com", Namespace: "mirror"},
+			},
+			image:    Image{"", "oscarzhao/image-sync", "latest"},
+			expected: "index.tenxcloud.com/mirror/image-sync:latest",
+		},
+	}
+
+	for _, tc := range testCases {
+		s := &syncer{job: tc.job}
+		if dstImg := s.dstImage(tc.image); dstImg.String() != tc.expected {
+			t.Errorf("image %s should be synchronized to %s, got %s\n", tc.image, tc.expected, dstImg)
+		}
+	}
+}
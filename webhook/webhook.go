@@ -0,0 +1,198 @@
+// Package webhook receives push events of docker hub webhooks and docker
+// distribution notifications
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// maxBodySize limits the size of webhook payloads
+const maxBodySize = 1 << 20
+
+// Event is an image pushed to a registry
+type Event struct {
+	// Registry is the host the image is pushed to, empty for docker hub
+	Registry string
+	Repo     string
+	Tag      string
+	Digest   string
+}
+
+func (e Event) String() string {
+	if e.Registry == "" {
+		return e.Repo + ":" + e.Tag
+	}
+	return e.Registry + "/" + e.Repo + ":" + e.Tag
+}
+
+// hubPayload is the payload of docker hub webhooks
+type hubPayload struct {
+	PushData struct {
+		Tag string `json:"tag"`
+	} `json:"push_data"`
+	Repository struct {
+		RepoName string `json:"repo_name"`
+	} `json:"repository"`
+}
+
+// envelope is the body of docker distribution notifications
+type envelope struct {
+	Events []struct {
+		Action string `json:"action"`
+		Target struct {
+			Repository string `json:"repository"`
+			Tag        string `json:"tag"`
+			Digest     string `json:"digest"`
+		} `json:"target"`
+		Request struct {
+			Host string `json:"host"`
+		} `json:"request"`
+	} `json:"events"`
+}
+
+// Parse parses push events of a docker hub webhook payload or a docker
+// distribution notification envelope. Events other than pushes of tags, such
+// as pulls or pushes of blobs, are dropped.
+func Parse(body []byte) ([]Event, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, fmt.Errorf("invalid webhook payload, error:%s", err)
+	}
+	if _, ok := raw["events"]; ok {
+		return parseEnvelope(body)
+	}
+	if _, ok := raw["push_data"]; ok {
+		return parseHub(body)
+	}
+	return nil, errors.New("unknown webhook payload, expected a docker hub webhook or a docker distribution notification")
+}
+
+func parseHub(body []byte) ([]Event, error) {
+	var p hubPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("invalid docker hub webhook payload, error:%s", err)
+	}
+	repo, tag := p.Repository.RepoName, p.PushData.Tag
+	if repo == "" || tag == "" {
+		return nil, errors.New("docker hub webhook payload has no repo or tag")
+	}
+	if !strings.Contains(repo, "/") {
+		// official images
+		repo = "library/" + repo
+	}
+	return []Event{{Repo: repo, Tag: tag}}, nil
+}
+
+func parseEnvelope(body []byte) ([]Event, error) {
+	var env envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, fmt.Errorf("invalid docker distribution notification, error:%s", err)
+	}
+	var events []Event
+	for _, e := range env.Events {
+		if e.Action != "push" || e.Target.Tag == "" || e.Target.Repository == "" {
+			continue
+		}
+		events = append(events, Event{
+			Registry: e.Request.Host,
+			Repo:     e.Target.Repository,
+			Tag:      e.Target.Tag,
+			Digest:   e.Target.Digest,
+		})
+	}
+	return events, nil
+}
+
+// Handler receives webhooks, and passes push events to Sync. Events of the
+// same image arriving within Window are merged into one.
+type Handler struct {
+	// Secret is compared with the token query parameter (docker hub webhooks
+	// cannot set headers) or the bearer token of the Authorization header
+	// (set in headers of distribution notification endpoints).
+	// No secret accepts all requests.
+	Secret string
+	Window time.Duration
+	// Sync is called for each merged event, it must not block
+	Sync func(Event)
+
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorized(req) {
+		glog.Warningf("webhook from %s is rejected, secret mismatch\n", req.RemoteAddr)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events, err := Parse(body)
+	if err != nil {
+		glog.Warningf("webhook from %s is rejected, error:%s\n", req.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queued := 0
+	for _, e := range events {
+		if h.add(e) {
+			queued++
+		}
+	}
+	glog.V(2).Infof("webhook from %s, %d push events, %d queued\n", req.RemoteAddr, len(events), queued)
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "%d events queued\n", queued)
+}
+
+func (h *Handler) authorized(req *http.Request) bool {
+	if h.Secret == "" {
+		return true
+	}
+	token := req.URL.Query().Get("token")
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.Secret)) == 1
+}
+
+// add passes e to Sync after Window, false is returned if the same image is
+// waiting already
+func (h *Handler) add(e Event) bool {
+	key := e.String()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.pending == nil {
+		h.pending = make(map[string]bool)
+	}
+	if h.pending[key] {
+		glog.V(4).Infof("webhook event of %s is merged into a queued one\n", key)
+		return false
+	}
+	h.pending[key] = true
+	time.AfterFunc(h.Window, func() {
+		h.mu.Lock()
+		delete(h.pending, key)
+		h.mu.Unlock()
+		h.Sync(e)
+	})
+	return true
+}
@@ -0,0 +1,106 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const hubPayloadJSON = `{
+  "callback_url": "https://registry.hub.docker.com/u/svendowideit/testhook/hook/2141b5bi5i5b02bec211i4eeih0242eg11000a/",
+  "push_data": {"pushed_at": 1417566161, "pusher": "trustedbuilder", "tag": "latest"},
+  "repository": {"name": "testhook", "namespace": "svendowideit", "repo_name": "svendowideit/testhook"}
+}`
+
+const envelopeJSON = `{"events": [
+  {"id": "1", "action": "push", "target": {"mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip", "digest": "sha256:aaa", "repository": "library/nginx"}, "request": {"host": "registry.example.com"}},
+  {"id": "2", "action": "push", "target": {"mediaType": "application/vnd.docker.distribution.manifest.v2+json", "digest": "sha256:bbb", "repository": "library/nginx", "tag": "1.25"}, "request": {"host": "registry.example.com"}},
+  {"id": "3", "action": "pull", "target": {"digest": "sha256:bbb", "repository": "library/nginx", "tag": "1.25"}, "request": {"host": "registry.example.com"}}
+]}`
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name     string
+		body     string
+		expected []Event
+		valid    bool
+	}{
+		{"docker hub", hubPayloadJSON, []Event{{Repo: "svendowideit/testhook", Tag: "latest"}}, true},
+		{"official image", `{"push_data": {"tag": "3.19"}, "repository": {"repo_name": "alpine"}}`, []Event{{Repo: "library/alpine", Tag: "3.19"}}, true},
+		{"distribution", envelopeJSON, []Event{{Registry: "registry.example.com", Repo: "library/nginx", Tag: "1.25", Digest: "sha256:bbb"}}, true},
+		{"no push", `{"events": []}`, nil, true},
+		{"hub without tag", `{"push_data": {}, "repository": {"repo_name": "alpine"}}`, nil, false},
+		{"unknown", `{"ref": "refs/heads/master"}`, nil, false},
+		{"not json", `push`, nil, false},
+	}
+	for _, tc := range testCases {
+		events, err := Parse([]byte(tc.body))
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: payload should be valid:%v, error:%v\n", tc.name, tc.valid, err)
+			continue
+		}
+		if len(events) != len(tc.expected) {
+			t.Errorf("%s: expected events %v, got %v\n", tc.name, tc.expected, events)
+			continue
+		}
+		for i := range events {
+			if events[i] != tc.expected[i] {
+				t.Errorf("%s: expected events %v, got %v\n", tc.name, tc.expected, events)
+			}
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var mu sync.Mutex
+	var synced []Event
+	h := &Handler{Secret: "s3cret", Window: 50 * time.Millisecond, Sync: func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		synced = append(synced, e)
+	}}
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	testCases := []struct {
+		name   string
+		method string
+		query  string
+		auth   string
+		body   string
+		status int
+	}{
+		{"no secret", "POST", "", "", hubPayloadJSON, http.StatusUnauthorized},
+		{"wrong secret", "POST", "?token=guess", "", hubPayloadJSON, http.StatusUnauthorized},
+		{"query token", "POST", "?token=s3cret", "", hubPayloadJSON, http.StatusAccepted},
+		{"burst", "POST", "?token=s3cret", "", hubPayloadJSON, http.StatusAccepted},
+		{"bearer token", "POST", "", "Bearer s3cret", envelopeJSON, http.StatusAccepted},
+		{"invalid payload", "POST", "?token=s3cret", "", `{}`, http.StatusBadRequest},
+		{"get", "GET", "?token=s3cret", "", "", http.StatusMethodNotAllowed},
+	}
+	for _, tc := range testCases {
+		req, _ := http.NewRequest(tc.method, server.URL+tc.query, strings.NewReader(tc.body))
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("%s: request fails, error:%s\n", tc.name, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: expected status %d, got %d\n", tc.name, tc.status, resp.StatusCode)
+		}
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(synced) != 2 {
+		t.Errorf("the burst should be merged into 2 syncs, got %v\n", synced)
+	}
+}